@@ -0,0 +1,74 @@
+// Command apiclient manages the API clients that may call the key
+// endpoints.
+//
+//	apiclient create -name billing -scopes keys:generate,keys:read_public
+//	apiclient revoke -key-id ak_0123456789abcdef
+//
+// create prints the key ID and the signing secret of the new client; the
+// secret is stored encrypted by the key manager and cannot be shown again.
+package main
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/bootstrap"
+	"crypto-keygen-service/internal/services"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: apiclient create|revoke [flags]")
+	}
+	ctx := audit.WithActor(context.Background(), "cli:apiclient")
+
+	switch os.Args[1] {
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ExitOnError)
+		name := flags.String("name", "", "name of the client")
+		scopes := flags.String("scopes", auth.ScopeGenerate+","+auth.ScopeReadPublic, "comma separated scopes granted to the client")
+		flags.Parse(os.Args[2:])
+		if *name == "" {
+			log.Fatalf("-name is required")
+		}
+
+		client, secret, err := newService().CreateAPIClient(ctx, *name, strings.Split(*scopes, ","))
+		if err != nil {
+			log.Fatalf("Failed to create API client: %v", err)
+		}
+		fmt.Printf("API_KEY_ID=%s\nAPI_SECRET=%s\n", client.KeyID, secret)
+	case "revoke":
+		flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+		keyID := flags.String("key-id", "", "key ID of the client")
+		flags.Parse(os.Args[2:])
+		if *keyID == "" {
+			log.Fatalf("-key-id is required")
+		}
+
+		if err := newService().RevokeAPIClient(ctx, *keyID); err != nil {
+			log.Fatalf("Failed to revoke API client: %v", err)
+		}
+		log.Printf("Revoked %s", *keyID)
+	default:
+		log.Fatalf("unknown command %q, expected create or revoke", os.Args[1])
+	}
+}
+
+func newService() *services.KeyGenService {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+	// No generator is used, the master seed is not needed
+	keyGenService, _, err := bootstrap.OfflineService(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up: %v", err)
+	}
+	return keyGenService
+}
@@ -2,13 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/bootstrap"
 	"crypto-keygen-service/internal/db/mongo"
-	"crypto-keygen-service/internal/util/encryption"
+	"crypto-keygen-service/internal/policy"
+	"crypto-keygen-service/internal/ratelimit"
+	"crypto-keygen-service/internal/util/kms"
+	"crypto-keygen-service/internal/util/mnemonic"
+	"crypto-keygen-service/internal/util/shamir"
+	"crypto-keygen-service/internal/util/tlsconfig"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"crypto-keygen-service/internal/handlers"
@@ -22,36 +33,107 @@ func main() {
 	loadEnv()
 	validateEnv()
 
-	mongoURI := os.Getenv("MONGODB_URI")
 	serverPort := os.Getenv("SERVER_PORT")
 	dbName := os.Getenv("DB_NAME")
 	dbCollection := os.Getenv("DB_COLLECTION")
-	encryptionKey := os.Getenv("ENCRYPTION_KEY")
-	masterSeed := os.Getenv("MASTER_SEED")
 
-	setupEncryption(encryptionKey)
-	database := setupDatabase(mongoURI, dbName, dbCollection)
+	keyManager, keyManagerLoader := setupKeyManager()
+	var masterSeed []byte
+	if isShamirSealed() {
+		log.Println("SEAL_MODE is shamir, starting sealed until enough shares are submitted to POST /sys/unseal")
+	} else {
+		masterSeed = loadMasterSeed(keyManager)
+	}
+	database, err := bootstrap.Database()
+	if err != nil {
+		log.Fatalf("Error setting up database: %v", err)
+	}
+	auditSink, err := bootstrap.AuditSink(context.Background(), database)
+	if err != nil {
+		log.Fatalf("Error setting up audit log: %v", err)
+	}
 
 	keyGenRepository := repositories.NewKeyGenRepository(database)
-	keyGenService := services.NewKeyGenService(keyGenRepository, []byte(masterSeed))
-	keyGenHandler := handlers.NewKeyGenHandler(keyGenService)
+	keyGenService := services.NewKeyGenService(keyGenRepository, masterSeed, keyManager)
+	keyGenService.SetAuditLogger(audit.NewLogger(auditSink))
+	if !keyGenService.Sealed() {
+		if err := keyGenService.LoadWatchOnlyAccounts(context.Background()); err != nil {
+			log.Fatalf("Failed to load watch-only accounts: %v", err)
+		}
+	}
+	if !bootstrap.IsProduction() {
+		log.Println("APP_ENV is not production, mainnet networks are disabled")
+		keyGenService.DisableMainnet()
+	}
+	if os.Getenv("REQUIRE_BOUND_RECORDS") == "true" {
+		keyGenService.RequireBoundRecords()
+	}
+	revealToken := os.Getenv("PRIVATE_KEY_REVEAL_TOKEN")
+	if os.Getenv("PRIVATE_KEY_REVEAL_ENABLED") == "true" {
+		if revealToken == "" {
+			log.Fatalf("PRIVATE_KEY_REVEAL_TOKEN must be set when PRIVATE_KEY_REVEAL_ENABLED is true")
+		}
+		keyGenService.EnablePrivateKeyReveal()
+		setupRevealQuorum(keyGenService)
+	}
+	keyGenHandler := handlers.NewKeyGenHandler(keyGenService, revealToken)
+	sysHandler := handlers.NewSysHandler(keyGenService, os.Getenv("SEAL_TOKEN"))
+	if policyFile := os.Getenv("POLICY_FILE"); policyFile != "" {
+		engine, err := policy.Load(policyFile)
+		if err != nil {
+			log.Fatalf("Failed to load POLICY_FILE: %v", err)
+		}
+		keyGenHandler.SetPolicy(engine)
+		sysHandler.SetPolicy(engine)
+	}
+	keyGenService.SetKeyManagerLoader(keyManagerLoader)
+	// After POST /sys/seal only the configured seed unseals the service again
+	seedCheck := os.Getenv("SEAL_SEED_CHECK")
+	if seedCheck == "" && masterSeed != nil {
+		seedCheck = shamir.CheckValue(masterSeed)
+	}
+	keyGenService.SetSeedCheck(seedCheck)
+	unsetSecretEnv()
 
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	router := gin.Default()
+	setupTrustedProxies(router)
+	router.Use(handlers.LimitRequestBody())
 	router.GET("/health", func(c *gin.Context) {
-		healthCheck(c, database)
+		healthCheck(c, database, keyGenService)
 	})
-	keyGenHandler.RegisterRoutes(router)
+	rateLimitStore := setupRateLimitStore(database, dbName, dbCollection)
+	var limits []gin.HandlerFunc
+	if limiter := setupRateLimiter(rateLimitStore); limiter != nil {
+		keyGenService.SetGenerationQuota(limiter)
+		limits = append(limits, limiter.Middleware())
+	}
+	ipLimit, sysLimit := setupIPLimits(rateLimitStore)
+	authenticate := authMiddleware(keyGenService, setupNonceStore(database, dbName, dbCollection))
+	keyGenHandler.RegisterRoutes(authenticated(router.Group("", ipLimit...), authenticate, limits...))
+	sysHandler.RegisterRoutes(authenticated(router.Group("", append(ipLimit, sysLimit)...), authenticate))
 
 	server := &http.Server{
 		Addr:    ":" + serverPort,
 		Handler: router,
 	}
+	stopTLSWatch := make(chan struct{})
+	defer close(stopTLSWatch)
+	tlsReloader := setupTLS(stopTLSWatch)
+	if tlsReloader != nil {
+		server.TLSConfig = tlsReloader.TLSConfig()
+	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsReloader != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
@@ -70,37 +152,343 @@ func main() {
 	log.Println("Server exiting")
 }
 
+// authMiddleware requires every request to be signed by an API client, see
+// cmd/apiclient, to carry a bearer token when JWKS_FILE or JWKS_URL is set or
+// to come with a verified client certificate when TLS_CLIENT_AUTH is set. It
+// returns nil if AUTH_DISABLED is true outside production.
+func authMiddleware(keyGenService *services.KeyGenService, nonces auth.NonceStore) gin.HandlerFunc {
+	if os.Getenv("AUTH_DISABLED") == "true" {
+		if bootstrap.IsProduction() {
+			log.Fatalf("AUTH_DISABLED cannot be used in production")
+		}
+		log.Println("AUTH_DISABLED is true, key and /sys endpoints accept unauthenticated requests")
+		return nil
+	}
+	hmacAuthenticator := auth.NewHMACAuthenticator(keyGenService)
+	hmacAuthenticator.SetNonceStore(nonces)
+	authenticators := []auth.Authenticator{hmacAuthenticator}
+	if jwtAuthenticator := setupJWTAuthenticator(); jwtAuthenticator != nil {
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+	// Credentials sent with the request take precedence over the connection's
+	// client certificate
+	if clientAuth := os.Getenv("TLS_CLIENT_AUTH"); clientAuth != "" && clientAuth != tlsconfig.ClientAuthNone {
+		scopes := auth.ScopeGenerate + "," + auth.ScopeReadPublic
+		if v := os.Getenv("TLS_CLIENT_SCOPES"); v != "" {
+			scopes = v
+		}
+		for _, scope := range strings.Split(scopes, ",") {
+			if !slices.Contains(auth.Scopes, scope) {
+				log.Fatalf("Unknown scope %q in TLS_CLIENT_SCOPES", scope)
+			}
+		}
+		authenticators = append(authenticators, auth.NewCertificateAuthenticator(strings.Split(scopes, ",")))
+	}
+	return auth.Middleware(authenticators...)
+}
+
+// authenticated returns a route group behind authMiddleware, the middleware,
+// e.g. rate limits, runs after authentication.
+func authenticated(router gin.IRouter, authenticate gin.HandlerFunc, middleware ...gin.HandlerFunc) gin.IRouter {
+	if authenticate == nil {
+		return router.Group("", middleware...)
+	}
+	return router.Group("", append([]gin.HandlerFunc{authenticate}, middleware...)...)
+}
+
+// setupNonceStore keeps the nonces of signed requests in the
+// <DB_COLLECTION>_nonces collection, shared by all replicas, unless
+// NONCE_STORE is memory.
+func setupNonceStore(database *mongo.MongoDatabase, dbName, collection string) auth.NonceStore {
+	switch os.Getenv("NONCE_STORE") {
+	case "", "mongo":
+		store, err := mongo.NewNonceStore(context.Background(), database.Client.Database(dbName), collection+"_nonces")
+		if err != nil {
+			log.Fatalf("Failed to initialize nonce store: %v", err)
+		}
+		return store
+	case "memory":
+		return auth.NewMemoryNonceStore()
+	default:
+		log.Fatalf("Unknown NONCE_STORE %q, expected mongo or memory", os.Getenv("NONCE_STORE"))
+		return nil
+	}
+}
+
+// setupRevealQuorum requires REVEAL_QUORUM of the comma separated
+// REVEAL_APPROVERS principals, e.g. jwt:alice, to approve a reveal request
+// before the key can be fetched. Requests wait REVEAL_REQUEST_TTL (default
+// 24h) for approvals and the key can be fetched for REVEAL_FETCH_TTL
+// (default 5m) once approved. Without REVEAL_APPROVERS it refuses to start,
+// unless REVEAL_WITHOUT_QUORUM is true and keys are revealed directly.
+func setupRevealQuorum(keyGenService *services.KeyGenService) {
+	if os.Getenv("REVEAL_APPROVERS") == "" {
+		if os.Getenv("REVEAL_WITHOUT_QUORUM") != "true" {
+			log.Fatalf("REVEAL_APPROVERS must be set when PRIVATE_KEY_REVEAL_ENABLED is true, unless REVEAL_WITHOUT_QUORUM is true")
+		}
+		log.Println("REVEAL_WITHOUT_QUORUM is true, private keys are revealed without approvals")
+		return
+	}
+	quorum := services.RevealQuorum{RequestTTL: 24 * time.Hour, FetchTTL: 5 * time.Minute}
+	for _, approver := range strings.Split(os.Getenv("REVEAL_APPROVERS"), ",") {
+		if approver = strings.TrimSpace(approver); approver != "" {
+			quorum.Approvers = append(quorum.Approvers, approver)
+		}
+	}
+	var err error
+	if quorum.Required, err = strconv.Atoi(os.Getenv("REVEAL_QUORUM")); err != nil {
+		log.Fatalf("REVEAL_QUORUM must be set to the number of required approvals")
+	}
+	for name, ttl := range map[string]*time.Duration{"REVEAL_REQUEST_TTL": &quorum.RequestTTL, "REVEAL_FETCH_TTL": &quorum.FetchTTL} {
+		if v := os.Getenv(name); v != "" {
+			if *ttl, err = time.ParseDuration(v); err != nil {
+				log.Fatalf("Invalid %s: %v", name, err)
+			}
+		}
+	}
+	if err := keyGenService.EnableRevealQuorum(quorum); err != nil {
+		log.Fatalf("Invalid reveal quorum: %v", err)
+	}
+	log.Printf("Private key reveal requires %d of %d approvals", quorum.Required, len(quorum.Approvers))
+}
+
+// setupJWTAuthenticator loads the JWKS from JWKS_FILE or JWKS_URL, it returns
+// nil when neither is set.
+func setupJWTAuthenticator() *auth.JWTAuthenticator {
+	var source auth.JWKSSource
+	switch {
+	case os.Getenv("JWKS_FILE") != "" && os.Getenv("JWKS_URL") != "":
+		log.Fatalf("Only one of JWKS_FILE and JWKS_URL can be set")
+	case os.Getenv("JWKS_FILE") != "":
+		source = auth.FileSource(os.Getenv("JWKS_FILE"))
+	case os.Getenv("JWKS_URL") != "":
+		source = auth.URLSource{URL: os.Getenv("JWKS_URL")}
+	default:
+		return nil
+	}
+	if os.Getenv("JWT_AUDIENCE") == "" {
+		log.Fatalf("JWT_AUDIENCE must be set when JWKS_FILE or JWKS_URL is set")
+	}
+
+	refreshInterval := auth.DefaultJWKSRefreshInterval
+	if v := os.Getenv("JWKS_REFRESH_INTERVAL"); v != "" {
+		var err error
+		if refreshInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid JWKS_REFRESH_INTERVAL: %v", err)
+		}
+	}
+	keys := auth.NewKeySet(source, refreshInterval)
+	if err := keys.Load(context.Background()); err != nil {
+		log.Fatalf("Failed to load JWKS: %v", err)
+	}
+	return auth.NewJWTAuthenticator(keys, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+}
+
+// setupRateLimiter limits key endpoint requests per client (RATE_LIMIT_CLIENT)
+// and per user ID (RATE_LIMIT_USER), and key generations (RATE_LIMIT_GENERATION),
+// each a rate like 10/s with an optional _BURST, in store. It returns nil
+// when no limit is set.
+func setupRateLimiter(store ratelimit.Store) *ratelimit.Limiter {
+	var limits [3]ratelimit.Limit
+	for i, name := range []string{"RATE_LIMIT_CLIENT", "RATE_LIMIT_USER", "RATE_LIMIT_GENERATION"} {
+		limits[i] = parseRateLimit(name, "")
+	}
+	if !limits[0].Enabled() && !limits[1].Enabled() && !limits[2].Enabled() {
+		log.Println("No rate limits are set")
+		return nil
+	}
+	return ratelimit.NewLimiter(store, limits[0], limits[1], limits[2])
+}
+
+// setupIPLimits returns the per-IP limits that run before authentication:
+// RATE_LIMIT_IP on every authenticated route, none when unset, and
+// RATE_LIMIT_SYS, 30/m by default, on the /sys endpoints in addition.
+func setupIPLimits(store ratelimit.Store) ([]gin.HandlerFunc, gin.HandlerFunc) {
+	var ipLimit []gin.HandlerFunc
+	if limit := parseRateLimit("RATE_LIMIT_IP", ""); limit.Enabled() {
+		ipLimit = append(ipLimit, ratelimit.IPMiddleware(store, ratelimit.LimitIP, limit))
+	}
+	return ipLimit, ratelimit.IPMiddleware(store, ratelimit.LimitSys, parseRateLimit("RATE_LIMIT_SYS", "30/m"))
+}
+
+// parseRateLimit reads the limit name, or defaultRate when it is unset, and
+// its burst name_BURST.
+func parseRateLimit(name, defaultRate string) ratelimit.Limit {
+	rate := os.Getenv(name)
+	if rate == "" {
+		rate = defaultRate
+	}
+	limit, err := ratelimit.ParseLimit(rate, os.Getenv(name+"_BURST"))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return limit
+}
+
+// setupRateLimitStore keeps the token buckets in memory, or in the
+// <DB_COLLECTION>_rate_limits collection with RATE_LIMIT_STORE=mongo.
+func setupRateLimitStore(database *mongo.MongoDatabase, dbName, collection string) ratelimit.Store {
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		return ratelimit.NewMemoryStore()
+	case "mongo":
+		store, err := mongo.NewRateLimitStore(context.Background(), database.Client.Database(dbName), collection+"_rate_limits")
+		if err != nil {
+			log.Fatalf("Failed to initialize rate limit store: %v", err)
+		}
+		return store
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q, expected memory or mongo", os.Getenv("RATE_LIMIT_STORE"))
+		return nil
+	}
+}
+
+// setupTLS serves TLS when TLS_CERT_FILE and TLS_KEY_FILE are set, see
+// tlsconfig.ConfigFromEnv. The files are checked for changes every
+// TLS_RELOAD_INTERVAL (default 30s) until stop is closed.
+func setupTLS(stop <-chan struct{}) *tlsconfig.Reloader {
+	cfg, ok, err := tlsconfig.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if !ok {
+		return nil
+	}
+	reloader, err := tlsconfig.NewReloader(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificates: %v", err)
+	}
+
+	interval := 30 * time.Second
+	if v := os.Getenv("TLS_RELOAD_INTERVAL"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil || interval <= 0 {
+			log.Fatalf("Invalid TLS_RELOAD_INTERVAL %q", v)
+		}
+	}
+	go reloader.Watch(interval, stop)
+	log.Printf("Serving TLS, client certificates: %s", cfg.ClientAuth)
+	return reloader
+}
+
+// setupTrustedProxies makes the router believe X-Forwarded-For only from the
+// comma separated TRUSTED_PROXIES. The client IP names the actor of
+// unauthenticated requests and keys rate limits, so by default no proxy is
+// trusted and it is the address of the peer.
+func setupTrustedProxies(router *gin.Engine) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+}
+
+// loadEnv loads an optional .env file, deployments can set the environment
+// directly instead.
 func loadEnv() {
 	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Printf("No .env file loaded: %v", err)
 	}
 }
 
 func validateEnv() {
-	requiredVars := []string{"MONGODB_URI", "SERVER_PORT", "DB_NAME", "DB_COLLECTION", "ENCRYPTION_KEY", "MASTER_SEED", "GIN_MODE"}
+	requiredVars := []string{"MONGODB_URI", "SERVER_PORT", "DB_NAME", "DB_COLLECTION", "GIN_MODE"}
 	for _, v := range requiredVars {
 		if os.Getenv(v) == "" {
 			log.Fatalf("Environment variable %s is not set", v)
 		}
 	}
+	if os.Getenv("AUDIT_SINK") == "file" && os.Getenv("AUDIT_FILE") == "" {
+		log.Fatalf("AUDIT_FILE must be set when AUDIT_SINK is file")
+	}
+	if !isShamirSealed() && os.Getenv("MASTER_SEED_WRAPPED") == "" && os.Getenv("MASTER_MNEMONIC") == "" && os.Getenv("MASTER_SEED") == "" {
+		log.Fatalf("One of MASTER_SEED_WRAPPED, MASTER_MNEMONIC or MASTER_SEED must be set")
+	}
+	if isShamirSealed() && (os.Getenv("SEAL_TOKEN") == "" || os.Getenv("SEAL_SEED_CHECK") == "") {
+		log.Fatalf("SEAL_TOKEN and SEAL_SEED_CHECK must be set when SEAL_MODE is shamir")
+	}
 }
 
-func setupEncryption(key string) {
-	if err := encryption.Setup(key); err != nil {
-		log.Fatalf("Error setting up encryption: %v", err)
+// isShamirSealed reports whether SEAL_MODE is shamir, in which case the
+// master seed is never configured and has to be unsealed from shares.
+func isShamirSealed() bool {
+	return os.Getenv("SEAL_MODE") == "shamir"
+}
+
+// loadMasterSeed prefers MASTER_SEED_WRAPPED, a seed sealed by the key
+// manager (see cmd/wrapseed), then a BIP39 MASTER_MNEMONIC (with optional
+// MASTER_PASSPHRASE) and falls back to the raw MASTER_SEED for development.
+func loadMasterSeed(keyManager kms.KeyManager) []byte {
+	if wrapped := os.Getenv("MASTER_SEED_WRAPPED"); wrapped != "" {
+		seed, err := kms.UnwrapSeed(context.Background(), keyManager, wrapped)
+		if err != nil {
+			log.Fatalf("Error unwrapping MASTER_SEED_WRAPPED: %v", err)
+		}
+		return seed
+	}
+
+	phrase := os.Getenv("MASTER_MNEMONIC")
+	if phrase == "" {
+		log.Println("MASTER_MNEMONIC not set, using raw MASTER_SEED")
+		return []byte(os.Getenv("MASTER_SEED"))
+	}
+
+	seed, err := mnemonic.ToSeed(phrase, os.Getenv("MASTER_PASSPHRASE"))
+	if err != nil {
+		log.Fatalf("Error loading MASTER_MNEMONIC: %v", err)
 	}
+	return seed
 }
 
-func setupDatabase(uri, dbName, collection string) *mongo.MongoDatabase {
-	database, err := mongo.NewMongoDatabase(uri, dbName, collection)
+// setupKeyManager configures the key manager that wraps the per-record data
+// keys, see bootstrap.KeyManager. It also returns how the key manager is loaded
+// again when the service is unsealed after /sys/seal wiped it: key material
+// from the environment is not kept, see unsetSecretEnv, so only a keystore
+// file or the PKCS#11 backend can be loaded again. Otherwise the loader is
+// nil and a sealed service has to be restarted.
+func setupKeyManager() (kms.KeyManager, func() (kms.KeyManager, error)) {
+	keyManager, cfg, err := bootstrap.KeyManager()
 	if err != nil {
-		log.Fatalf("Failed to initialize MongoDB: %v", err)
+		log.Fatalf("Error setting up key manager: %v", err)
+	}
+
+	if cfg.Backend != kms.BackendPKCS11 && cfg.KeystoreFile == "" {
+		return keyManager, nil
+	}
+	cfg.CurrentKey, cfg.RetiredKeys, cfg.SigningKey = kms.Key{}, nil, ""
+	return keyManager, func() (kms.KeyManager, error) {
+		return kms.New(cfg)
+	}
+}
+
+// secretEnv lists the variables holding key material, seeds or tokens.
+var secretEnv = []string{
+	"ENCRYPTION_KEY", "ENCRYPTION_RETIRED_KEYS", "SIGNING_KEY", "PKCS11_PIN",
+	"MASTER_MNEMONIC", "MASTER_PASSPHRASE", "MASTER_SEED",
+	"SEAL_TOKEN", "PRIVATE_KEY_REVEAL_TOKEN",
+}
+
+// unsetSecretEnv removes the secrets from the process environment once they
+// have been read, so that they do not survive a seal there or leak into
+// child processes.
+func unsetSecretEnv() {
+	for _, name := range secretEnv {
+		if err := os.Unsetenv(name); err != nil {
+			log.Fatalf("Failed to unset %s: %v", name, err)
+		}
 	}
-	return database
 }
 
 // todo: update health check for generic db
-func healthCheck(c *gin.Context, database *mongo.MongoDatabase) {
+func healthCheck(c *gin.Context, database *mongo.MongoDatabase, keyGenService *services.KeyGenService) {
+	if status := keyGenService.SealStatus(); status.Sealed() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "sealed", "state": status.State})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
@@ -0,0 +1,110 @@
+// Command importkey imports private keys generated elsewhere into the store.
+// It reads one JSON object per line from the file given as argument, or from
+// standard input:
+//
+//	{"user_id": 1, "network": "bitcoin-testnet", "private_key": "cV...", "address": "mx..."}
+//	{"user_id": 2, "network": "ethereum", "private_key": "{...}", "passphrase": "...", "address": "0x..."}
+//
+// private_key is a WIF, hex or BIP38 key for Bitcoin and a hex or keystore v3
+// JSON key for Ethereum, encrypted keys need the passphrase. Every key is
+// checked against its address and network, keys that already exist are not
+// replaced. It exits with status 1 if any line failed.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/bootstrap"
+	"crypto-keygen-service/internal/services"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+type importLine struct {
+	UserID     int    `json:"user_id"`
+	Network    string `json:"network"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+	Address    string `json:"address"`
+}
+
+func main() {
+	flag.Parse()
+
+	var input io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", flag.Arg(0), err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	keyGenService := newService()
+	ctx := audit.WithActor(context.Background(), "cli:importkey")
+
+	var imported, failed int
+	scanner := bufio.NewScanner(input)
+	// keystore JSON exceeds the default line length
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line importLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			log.Printf("line %d: invalid JSON: %v", n, err)
+			failed++
+			continue
+		}
+		if line.UserID <= 0 || line.Network == "" || line.PrivateKey == "" || line.Address == "" {
+			log.Printf("line %d: user_id, network, private_key and address are required", n)
+			failed++
+			continue
+		}
+
+		keyPairAndAddress, err := keyGenService.ImportKey(ctx, line.UserID, line.Network, services.KeyImport{
+			PrivateKey: line.PrivateKey,
+			Passphrase: line.Passphrase,
+			Address:    line.Address,
+		})
+		if err != nil {
+			log.Printf("line %d: user %d on %s: %v", n, line.UserID, line.Network, err)
+			failed++
+			continue
+		}
+		log.Printf("line %d: imported %s for user %d on %s", n, keyPairAndAddress.Address, line.UserID, line.Network)
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+
+	log.Printf("Done: %d imported, %d failed", imported, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func newService() *services.KeyGenService {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+	// Imports do not derive keys, the master seed is not needed
+	keyGenService, _, err := bootstrap.OfflineService(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up: %v", err)
+	}
+	if !bootstrap.IsProduction() {
+		log.Println("APP_ENV is not production, mainnet networks are disabled")
+		keyGenService.DisableMainnet()
+	}
+	return keyGenService
+}
@@ -0,0 +1,31 @@
+// Command mnemonic generates a new BIP39 mnemonic for use as MASTER_MNEMONIC.
+// It never touches the network or the database and is meant to be run on an
+// offline machine during the key ceremony.
+package main
+
+import (
+	"crypto-keygen-service/internal/util/mnemonic"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+func main() {
+	bits := flag.Int("bits", mnemonic.DefaultEntropyBits, "entropy size in bits (128, 160, 192, 224 or 256)")
+	numbered := flag.Bool("numbered", false, "print one numbered word per line for paper backups")
+	flag.Parse()
+
+	phrase, err := mnemonic.Generate(*bits)
+	if err != nil {
+		log.Fatalf("Failed to generate mnemonic: %v", err)
+	}
+
+	if !*numbered {
+		fmt.Println(phrase)
+		return
+	}
+	for i, word := range strings.Fields(phrase) {
+		fmt.Printf("%2d. %s\n", i+1, word)
+	}
+}
@@ -0,0 +1,49 @@
+// Command rekey re-encrypts every stored private key under the current
+// ENCRYPTION_KEY. Run it after rotating the key, with the previous key listed
+// in ENCRYPTION_RETIRED_KEYS; once it reports no failures the retired key can
+// be removed from the configuration.
+package main
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/bootstrap"
+	"crypto-keygen-service/internal/services"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	every := flag.Int64("progress", 100, "report progress every n records")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+	keyGenService, keyManager, err := bootstrap.OfflineService(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx = audit.WithActor(ctx, "cli:rekey")
+
+	log.Printf("Re-encrypting keys under %s", keyManager.KeyID())
+	progress, err := keyGenService.ReencryptAll(ctx, func(p services.ReencryptionProgress) {
+		if p.Processed%*every == 0 {
+			log.Printf("%d/%d processed, %d re-encrypted, %d skipped, %d failed", p.Processed, p.Total, p.Reencrypted, p.Skipped, p.Failed)
+		}
+	})
+	log.Printf("Done: %d/%d processed, %d re-encrypted, %d skipped, %d failed", progress.Processed, progress.Total, progress.Reencrypted, progress.Skipped, progress.Failed)
+	if err != nil {
+		log.Fatalf("Re-encryption aborted: %v", err)
+	}
+	if progress.Failed > 0 {
+		os.Exit(1)
+	}
+}
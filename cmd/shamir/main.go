@@ -0,0 +1,93 @@
+// Command shamir splits the master seed into Shamir shares for the sealed
+// startup mode and combines shares again for recovery. Like cmd/mnemonic it
+// never touches the network and is meant to be run offline.
+//
+//	shamir split -shares 5 -threshold 3 < secret
+//	shamir combine < shares
+//
+// split reads a BIP39 mnemonic, stretched with MASTER_PASSPHRASE, or a raw
+// MASTER_SEED value from the first line of stdin and prints one share per
+// line, followed on stderr by the SEAL_SEED_CHECK value the service needs to
+// recognise the seed. combine reads one share per line and prints the seed
+// in hex.
+package main
+
+import (
+	"bufio"
+	"crypto-keygen-service/internal/util/mnemonic"
+	"crypto-keygen-service/internal/util/shamir"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: shamir split|combine [flags]")
+	}
+
+	switch os.Args[1] {
+	case "split":
+		split(os.Args[2:])
+	case "combine":
+		combine()
+	default:
+		log.Fatalf("unknown command %q, expected split or combine", os.Args[1])
+	}
+}
+
+func split(args []string) {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	n := flags.Int("shares", 5, "number of shares")
+	threshold := flags.Int("threshold", 3, "number of shares needed to unseal")
+	flags.Parse(args)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		log.Fatalf("Expected a mnemonic or seed on stdin")
+	}
+	input := strings.TrimSpace(scanner.Text())
+
+	seed := []byte(input)
+	if words := len(strings.Fields(input)); words >= 12 {
+		var err error
+		seed, err = mnemonic.ToSeed(input, os.Getenv("MASTER_PASSPHRASE"))
+		if err != nil {
+			log.Fatalf("Input looks like a mnemonic but is invalid: %v", err)
+		}
+	}
+
+	shares, err := shamir.Split(seed, *n, *threshold)
+	if err != nil {
+		log.Fatalf("Failed to split seed: %v", err)
+	}
+	for _, share := range shares {
+		fmt.Println(share)
+	}
+	log.Printf("SEAL_SEED_CHECK=%s", shamir.CheckValue(seed))
+}
+
+func combine() {
+	var shares []shamir.Share
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		share, err := shamir.ParseShare(line)
+		if err != nil {
+			log.Fatalf("Share %d: %v", len(shares)+1, err)
+		}
+		shares = append(shares, share)
+	}
+
+	seed, err := shamir.Combine(shares)
+	if err != nil {
+		log.Fatalf("Failed to combine shares: %v", err)
+	}
+	fmt.Println(hex.EncodeToString(seed))
+}
@@ -0,0 +1,46 @@
+// Command wrapseed seals the master seed with the configured key manager and
+// prints it as MASTER_SEED_WRAPPED. With KMS_BACKEND=pkcs11 the seed can then
+// only be recovered through the HSM, and MASTER_MNEMONIC or MASTER_SEED can be
+// removed from the deployment configuration.
+package main
+
+import (
+	"context"
+	"crypto-keygen-service/internal/bootstrap"
+	"crypto-keygen-service/internal/util/kms"
+	"crypto-keygen-service/internal/util/mnemonic"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+
+	keyManager, _, err := bootstrap.KeyManager()
+	if err != nil {
+		log.Fatalf("Error setting up key manager: %v", err)
+	}
+
+	var seed []byte
+	if phrase := os.Getenv("MASTER_MNEMONIC"); phrase != "" {
+		seed, err = mnemonic.ToSeed(phrase, os.Getenv("MASTER_PASSPHRASE"))
+		if err != nil {
+			log.Fatalf("Error loading MASTER_MNEMONIC: %v", err)
+		}
+	} else if raw := os.Getenv("MASTER_SEED"); raw != "" {
+		seed = []byte(raw)
+	} else {
+		log.Fatalf("Either MASTER_MNEMONIC or MASTER_SEED must be set")
+	}
+
+	wrapped, err := kms.WrapSeed(context.Background(), keyManager, seed)
+	if err != nil {
+		log.Fatalf("Failed to wrap master seed: %v", err)
+	}
+	fmt.Printf("MASTER_SEED_WRAPPED=%s\n", wrapped)
+}
@@ -0,0 +1,195 @@
+// Package audit keeps an append-only, hash-chained record of every key
+// generation, retrieval, private key reveal, export and administrative
+// action. Each entry carries the hash of its predecessor, so editing,
+// removing or reordering an entry breaks the chain, see Verify.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrConflict is returned by Sink.Append when an entry with the same
+// sequence number was already appended, e.g. by another replica.
+var ErrConflict = errors.New("audit entry already exists")
+
+type Action string
+
+const (
+	ActionGenerate          Action = "key.generate"
+	ActionRetrieve          Action = "key.retrieve"
+	ActionRevealRequest     Action = "key.reveal_request"
+	ActionRevealApprove     Action = "key.reveal_approve"
+	ActionRevealExpire      Action = "key.reveal_expire"
+	ActionExportPrivateKey  Action = "key.export_private_key"
+	ActionImport            Action = "key.import"
+	ActionSignMessage       Action = "key.sign_message"
+	ActionSignTypedData     Action = "key.sign_typed_data"
+	ActionVerifyMessage     Action = "key.verify_message"
+	ActionExportAccountKey  Action = "key.export_account_key"
+	ActionRegisterWatchOnly Action = "admin.register_watch_only"
+	ActionReencrypt         Action = "admin.reencrypt"
+	ActionCreateAPIClient   Action = "admin.create_api_client"
+	ActionRevokeAPIClient   Action = "admin.revoke_api_client"
+	ActionSeal              Action = "admin.seal"
+	ActionUnsealShare       Action = "admin.unseal_share"
+	ActionUnsealReset       Action = "admin.unseal_reset"
+	ActionUnseal            Action = "admin.unseal"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	// OutcomeDenied is recorded when the caller was not allowed to perform
+	// the action.
+	OutcomeDenied Outcome = "denied"
+)
+
+// Event is what callers record, Logger turns it into a chained Entry.
+type Event struct {
+	Actor   string
+	Action  Action
+	UserID  int
+	Network string
+	Outcome Outcome
+	// Detail is the error message of a failed action or a short summary
+	Detail string
+}
+
+// Entry is a persisted audit record. Seq starts at 1 and has no gaps,
+// PrevHash is the Hash of the previous entry and empty for the first one.
+type Entry struct {
+	Seq      uint64    `bson:"seq" json:"seq"`
+	Time     time.Time `bson:"time" json:"time"`
+	Actor    string    `bson:"actor" json:"actor"`
+	Action   Action    `bson:"action" json:"action"`
+	UserID   int       `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Network  string    `bson:"network,omitempty" json:"network,omitempty"`
+	Outcome  Outcome   `bson:"outcome" json:"outcome"`
+	Detail   string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	PrevHash string    `bson:"prev_hash" json:"prev_hash"`
+	Hash     string    `bson:"hash" json:"hash"`
+}
+
+// ComputeHash returns the hex SHA-256 over every field but Hash.
+func (e Entry) ComputeHash() string {
+	// A fixed struct keeps the encoding independent of how a sink stores entries
+	encoded, _ := json.Marshal(struct {
+		Seq      uint64
+		Time     string
+		Actor    string
+		Action   Action
+		UserID   int
+		Network  string
+		Outcome  Outcome
+		Detail   string
+		PrevHash string
+	}{e.Seq, e.Time.UTC().Format(time.RFC3339Nano), e.Actor, e.Action, e.UserID, e.Network, e.Outcome, e.Detail, e.PrevHash})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sink stores audit entries. Implementations must never modify or delete
+// appended entries.
+type Sink interface {
+	// Append stores entry, it fails with ErrConflict if entry.Seq is taken.
+	Append(ctx context.Context, entry Entry) error
+	// Last returns the entry with the highest sequence number, ok is false
+	// for an empty log.
+	Last(ctx context.Context) (entry Entry, ok bool, err error)
+	// Head returns the latest checkpoint written by Append. It is stored
+	// apart from the entries so that dropping entries from the end of the
+	// log can be detected.
+	Head(ctx context.Context) (head Anchor, ok bool, err error)
+	// ForEach calls fn for every entry in sequence order until fn returns
+	// an error.
+	ForEach(ctx context.Context, fn func(Entry) error) error
+}
+
+// maxAppendAttempts bounds the retries when other replicas append to the
+// same sink concurrently.
+const maxAppendAttempts = 5
+
+// Logger chains events onto the entries of a sink. A nil Logger discards
+// every event.
+type Logger struct {
+	mu     sync.Mutex
+	sink   Sink
+	last   Entry
+	loaded bool
+	now    func() time.Time
+}
+
+func NewLogger(sink Sink) *Logger {
+	return &Logger{sink: sink, now: time.Now}
+}
+
+// Record appends event to the log and returns the stored entry.
+func (l *Logger) Record(ctx context.Context, event Event) (Entry, error) {
+	if l == nil {
+		return Entry{}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if !l.loaded {
+			last, _, err := l.sink.Last(ctx)
+			if err != nil {
+				return Entry{}, err
+			}
+			l.last, l.loaded = last, true
+		}
+
+		entry := Entry{
+			Seq: l.last.Seq + 1,
+			// Mongo stores milliseconds, the hash must survive the round trip
+			Time:     l.now().UTC().Truncate(time.Millisecond),
+			Actor:    event.Actor,
+			Action:   event.Action,
+			UserID:   event.UserID,
+			Network:  event.Network,
+			Outcome:  event.Outcome,
+			Detail:   event.Detail,
+			PrevHash: l.last.Hash,
+		}
+		entry.Hash = entry.ComputeHash()
+
+		err := l.sink.Append(ctx, entry)
+		if errors.Is(err, ErrConflict) {
+			log.WithField("seq", entry.Seq).Warn("Audit log was appended concurrently, retrying")
+			l.loaded = false
+			continue
+		}
+		if err != nil {
+			return Entry{}, err
+		}
+		l.last = entry
+		return entry, nil
+	}
+	return Entry{}, ErrConflict
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that attributes recorded events to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor, or "unknown".
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "unknown"
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFileLog(t *testing.T, n int) (string, *FileSink) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	logger := NewLogger(sink)
+	for i := 1; i <= n; i++ {
+		_, err := logger.Record(context.Background(), Event{Actor: "ip:127.0.0.1", Action: ActionGenerate, UserID: i, Network: "bitcoin", Outcome: OutcomeSuccess})
+		require.NoError(t, err)
+	}
+	return path, sink
+}
+
+func rewriteLines(t *testing.T, path string, edit func([]string) []string) {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	lines = edit(lines)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+}
+
+func TestLoggerChainsEntries(t *testing.T) {
+	ctx := context.Background()
+	path, sink := newFileLog(t, 3)
+
+	var entries []Entry
+	require.NoError(t, sink.ForEach(ctx, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	require.Len(t, entries, 3)
+	assert.Equal(t, uint64(1), entries[0].Seq)
+	assert.Empty(t, entries[0].PrevHash)
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	assert.Equal(t, entries[1].Hash, entries[2].PrevHash)
+	assert.Equal(t, 3, entries[2].UserID)
+
+	head, err := Verify(ctx, sink)
+	assert.NoError(t, err)
+	assert.Equal(t, Anchor{Seq: 3, Hash: entries[2].Hash}, head)
+
+	// A logger on a reopened sink continues the chain
+	reopened, err := NewFileSink(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	entry, err := NewLogger(reopened).Record(ctx, Event{Action: ActionSeal, Outcome: OutcomeSuccess})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), entry.Seq)
+	assert.Equal(t, head.Hash, entry.PrevHash)
+}
+
+func TestLoggerRetriesConcurrentAppends(t *testing.T) {
+	ctx := context.Background()
+	_, sink := newFileLog(t, 0)
+	first, second := NewLogger(sink), NewLogger(sink)
+
+	_, err := first.Record(ctx, Event{Action: ActionRetrieve, Outcome: OutcomeSuccess})
+	require.NoError(t, err)
+	_, err = second.Record(ctx, Event{Action: ActionRetrieve, Outcome: OutcomeSuccess})
+	require.NoError(t, err)
+	entry, err := first.Record(ctx, Event{Action: ActionExportPrivateKey, Outcome: OutcomeDenied})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), entry.Seq)
+
+	_, err = Verify(ctx, sink)
+	assert.NoError(t, err)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("edited entry", func(t *testing.T) {
+		path, sink := newFileLog(t, 3)
+		rewriteLines(t, path, func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"user_id":2`, `"user_id":7`, 1)
+			return lines
+		})
+		_, err := Verify(ctx, sink)
+		assert.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("removed entry", func(t *testing.T) {
+		path, sink := newFileLog(t, 3)
+		rewriteLines(t, path, func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		})
+		_, err := Verify(ctx, sink)
+		assert.ErrorIs(t, err, ErrTampered)
+	})
+
+	t.Run("truncated log", func(t *testing.T) {
+		path, sink := newFileLog(t, 3)
+		rewriteLines(t, path, func(lines []string) []string {
+			return lines[:2]
+		})
+		_, err := Verify(ctx, sink)
+		assert.ErrorIs(t, err, ErrTruncated)
+	})
+
+	t.Run("truncated log and head", func(t *testing.T) {
+		path, sink := newFileLog(t, 3)
+		anchor, err := Verify(ctx, sink)
+		require.NoError(t, err)
+
+		rewriteLines(t, path, func(lines []string) []string {
+			return lines[:2]
+		})
+		require.NoError(t, os.Remove(path+".head"))
+		_, err = Verify(ctx, sink)
+		assert.NoError(t, err)
+		_, err = Verify(ctx, sink, anchor)
+		assert.ErrorIs(t, err, ErrTruncated)
+	})
+
+	t.Run("rewritten chain", func(t *testing.T) {
+		_, sink := newFileLog(t, 3)
+		anchor, err := Verify(ctx, sink)
+		require.NoError(t, err)
+
+		// A consistent chain with different entries
+		_, other := newFileLog(t, 0)
+		logger := NewLogger(other)
+		for i := 1; i <= 3; i++ {
+			_, err := logger.Record(ctx, Event{Actor: "ip:127.0.0.1", Action: ActionGenerate, UserID: i, Network: "ethereum", Outcome: OutcomeSuccess})
+			require.NoError(t, err)
+		}
+		_, err = Verify(ctx, other, anchor)
+		assert.ErrorIs(t, err, ErrTampered)
+	})
+}
+
+func TestParseAnchor(t *testing.T) {
+	anchor, err := ParseAnchor("12:abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, Anchor{Seq: 12, Hash: "abcd"}, anchor)
+	assert.Equal(t, "12:abcd", anchor.String())
+
+	for _, invalid := range []string{"", "12", "0:abcd", "x:abcd", "12:"} {
+		_, err := ParseAnchor(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
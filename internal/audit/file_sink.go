@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSink appends entries as JSON lines to a local file and keeps its head
+// checkpoint in a file next to it with a ".head" suffix. Entries appended by
+// another process, e.g. cmd/rekey, are picked up before the next append, but
+// two processes appending at the same instant can fork the log.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	last Entry
+	// size of the file after the last entry this sink read or wrote
+	size int64
+}
+
+// NewFileSink opens, or creates, the log at path.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	sink := &FileSink{path: path, file: file}
+	if err := sink.reload(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// reload reads the last entry and the size of the file, it must be called
+// with mu held or before the sink is shared.
+func (s *FileSink) reload() error {
+	var last Entry
+	err := s.ForEach(context.Background(), func(entry Entry) error {
+		last = entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.last, s.size = last, info.Size()
+	return nil
+}
+
+func (s *FileSink) Append(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != s.size {
+		if err := s.reload(); err != nil {
+			return err
+		}
+	}
+	if entry.Seq != s.last.Seq+1 {
+		return ErrConflict
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	n, err := s.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.last, s.size = entry, s.size+int64(n)
+	return s.writeHead(Anchor{Seq: entry.Seq, Hash: entry.Hash})
+}
+
+// writeHead replaces the head file atomically.
+func (s *FileSink) writeHead(head Anchor) error {
+	encoded, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".head-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path+".head")
+}
+
+func (s *FileSink) Last(ctx context.Context) (Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return Entry{}, false, err
+	}
+	return s.last, s.last.Seq > 0, nil
+}
+
+func (s *FileSink) Head(ctx context.Context) (Anchor, bool, error) {
+	encoded, err := os.ReadFile(s.path + ".head")
+	if errors.Is(err, fs.ErrNotExist) {
+		return Anchor{}, false, nil
+	}
+	if err != nil {
+		return Anchor{}, false, err
+	}
+	var head Anchor
+	if err := json.Unmarshal(encoded, &head); err != nil {
+		return Anchor{}, false, fmt.Errorf("%w: unreadable head checkpoint: %v", ErrTampered, err)
+	}
+	return head, true, nil
+}
+
+// ForEach reads the file from the start, a missing file is an empty log.
+func (s *FileSink) ForEach(ctx context.Context, fn func(Entry) error) error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%w: line %d is not an entry: %v", ErrTampered, line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Close closes the log file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
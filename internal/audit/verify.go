@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTampered  = errors.New("audit log was modified")
+	ErrTruncated = errors.New("audit log was truncated")
+)
+
+// Anchor identifies an entry by its sequence number and hash. Anchors
+// recorded outside the sink, e.g. the head printed by a previous
+// verification, let Verify detect truncation even if the sink's own head
+// checkpoint was rewound as well.
+type Anchor struct {
+	Seq  uint64 `bson:"seq" json:"seq"`
+	Hash string `bson:"hash" json:"hash"`
+}
+
+func (a Anchor) String() string {
+	return fmt.Sprintf("%d:%s", a.Seq, a.Hash)
+}
+
+// ParseAnchor parses the "seq:hash" form returned by Anchor.String.
+func ParseAnchor(s string) (Anchor, error) {
+	seq, hash, ok := strings.Cut(s, ":")
+	if !ok {
+		return Anchor{}, fmt.Errorf("invalid anchor %q, expected seq:hash", s)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n == 0 || hash == "" {
+		return Anchor{}, fmt.Errorf("invalid anchor %q, expected seq:hash", s)
+	}
+	return Anchor{Seq: n, Hash: hash}, nil
+}
+
+// Verify walks the whole log and checks that sequence numbers have no gaps,
+// every entry hashes to its Hash and links to its predecessor, and that the
+// sink's head checkpoint and every given anchor are still part of the log.
+// It returns the head of the verified log.
+func Verify(ctx context.Context, sink Sink, anchors ...Anchor) (Anchor, error) {
+	head, ok, err := sink.Head(ctx)
+	if err != nil {
+		return Anchor{}, err
+	}
+	if ok {
+		anchors = append(anchors, head)
+	}
+	expected := make(map[uint64]string, len(anchors))
+	var minSeq uint64
+	for _, anchor := range anchors {
+		if hash, exists := expected[anchor.Seq]; exists && hash != anchor.Hash {
+			return Anchor{}, fmt.Errorf("%w: conflicting anchors for entry %d", ErrTampered, anchor.Seq)
+		}
+		expected[anchor.Seq] = anchor.Hash
+		minSeq = max(minSeq, anchor.Seq)
+	}
+
+	var last Entry
+	err = sink.ForEach(ctx, func(entry Entry) error {
+		switch {
+		case entry.Seq != last.Seq+1:
+			return fmt.Errorf("%w: entry %d follows entry %d", ErrTampered, entry.Seq, last.Seq)
+		case entry.PrevHash != last.Hash:
+			return fmt.Errorf("%w: entry %d does not link to entry %d", ErrTampered, entry.Seq, last.Seq)
+		case entry.Hash != entry.ComputeHash():
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrTampered, entry.Seq)
+		}
+		if hash, ok := expected[entry.Seq]; ok && hash != entry.Hash {
+			return fmt.Errorf("%w: entry %d does not match its anchor", ErrTampered, entry.Seq)
+		}
+		last = entry
+		return nil
+	})
+	if err != nil {
+		return Anchor{}, err
+	}
+
+	if last.Seq < minSeq {
+		return Anchor{}, fmt.Errorf("%w: log ends at entry %d, expected at least %d", ErrTruncated, last.Seq, minSeq)
+	}
+	return Anchor{Seq: last.Seq, Hash: last.Hash}, nil
+}
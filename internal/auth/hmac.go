@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of a signed request.
+const (
+	KeyIDHeader     = "X-Api-Key-Id"
+	TimestampHeader = "X-Api-Timestamp"
+	NonceHeader     = "X-Api-Nonce"
+	SignatureHeader = "X-Api-Signature"
+)
+
+const (
+	// DefaultMaxSkew is how far the request timestamp may be from the
+	// server's clock.
+	DefaultMaxSkew = 5 * time.Minute
+	// maxSignedBodySize limits the body that is read to be hashed.
+	maxSignedBodySize = 1 << 20
+	maxNonceLength    = 128
+)
+
+// CredentialResolver returns the signing secret and the scopes of an API
+// client. It fails with errors.ErrUnauthorized for unknown or revoked clients.
+type CredentialResolver interface {
+	APIClientCredentials(ctx context.Context, keyID string) (secret []byte, scopes []string, err error)
+}
+
+// HMACAuthenticator authenticates requests signed with an API client secret,
+// see Sign. A request is only accepted within DefaultMaxSkew of its
+// timestamp and only once, its nonce is remembered for that long.
+type HMACAuthenticator struct {
+	clients CredentialResolver
+	maxSkew time.Duration
+	now     func() time.Time
+	nonces  NonceStore
+}
+
+// NewHMACAuthenticator remembers nonces in memory, see SetNonceStore.
+func NewHMACAuthenticator(clients CredentialResolver) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		clients: clients,
+		maxSkew: DefaultMaxSkew,
+		now:     time.Now,
+		nonces:  NewMemoryNonceStore(),
+	}
+}
+
+// SetNonceStore replaces the in-memory nonces, replicas behind a load
+// balancer need a store they share to refuse a request replayed against
+// another replica.
+func (a *HMACAuthenticator) SetNonceStore(store NonceStore) {
+	a.nonces = store
+}
+
+// StringToSign is the canonical form of a request: method, path with query,
+// unix timestamp, nonce and the hex SHA-256 of the body, one per line.
+func StringToSign(method, requestURI, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{method, requestURI, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")
+}
+
+// Sign returns the hex HMAC-SHA256 of StringToSign under secret, the value of
+// the X-Api-Signature header.
+func Sign(secret []byte, method, requestURI, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(StringToSign(method, requestURI, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Applies to requests that name an API client.
+func (a *HMACAuthenticator) Applies(c *gin.Context) bool {
+	return c.GetHeader(KeyIDHeader) != ""
+}
+
+// Authenticate accepts requests signed by an enabled API client, the
+// principal has the client's scopes.
+func (a *HMACAuthenticator) Authenticate(c *gin.Context) (Principal, error) {
+	keyID := c.GetHeader(KeyIDHeader)
+	timestamp := c.GetHeader(TimestampHeader)
+	nonce := c.GetHeader(NonceHeader)
+	signature, err := hex.DecodeString(c.GetHeader(SignatureHeader))
+	if keyID == "" || nonce == "" || len(nonce) > maxNonceLength || err != nil || len(signature) != sha256.Size {
+		return Principal{}, errors.ErrUnauthorized
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, errors.ErrUnauthorized
+	}
+	now := a.now()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return Principal{}, errors.ErrUnauthorized
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+	var tooLarge *http.MaxBytesError
+	if stderrors.As(err, &tooLarge) || len(body) > maxSignedBodySize {
+		return Principal{}, errors.ErrRequestTooLarge
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	secret, scopes, err := a.clients.APIClientCredentials(c.Request.Context(), keyID)
+	if err != nil {
+		return Principal{}, err
+	}
+	expected, _ := hex.DecodeString(Sign(secret, c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal(signature, expected) {
+		return Principal{}, errors.ErrUnauthorized
+	}
+
+	// Only nonces of valid signatures are remembered, so that nobody else
+	// can use up a client's nonces. The request is replayable until its
+	// timestamp is out of the window.
+	fresh, err := a.nonces.Add(c.Request.Context(), keyID+":"+nonce, time.Unix(unix, 0).Add(a.maxSkew))
+	if err != nil {
+		return Principal{}, err
+	}
+	if !fresh {
+		return Principal{}, errReplayed
+	}
+	return Principal{ID: keyID, Method: MethodAPIKey, Scopes: scopes}, nil
+}
+
+var (
+	errReplayed = errors.NewKeyGenError(http.StatusUnauthorized, "Request was already used")
+)
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKeyID  = "ak_0123456789abcdef"
+	testSecret = "test-secret"
+)
+
+type staticSecrets map[string]string
+
+func (s staticSecrets) APIClientCredentials(ctx context.Context, keyID string) ([]byte, []string, error) {
+	secret, ok := s[keyID]
+	if !ok {
+		return nil, nil, errors.ErrUnauthorized
+	}
+	return []byte(secret), []string{ScopeReadPublic}, nil
+}
+
+type sealedSecrets struct{}
+
+func (sealedSecrets) APIClientCredentials(ctx context.Context, keyID string) ([]byte, []string, error) {
+	return nil, nil, errors.ErrSealed
+}
+
+// newTestRouter answers with the principal and its scopes, and the body.
+func newTestRouter(authenticators ...Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(authenticators...))
+	router.Any("/*path", func(c *gin.Context) {
+		principal, _ := PrincipalFrom(c.Request.Context())
+		body, _ := c.GetRawData()
+		c.String(http.StatusOK, principal.String()+" "+strings.Join(principal.Scopes, ",")+" "+string(body))
+	})
+	return router
+}
+
+func signedRequest(method, uri, body, nonce string, timestamp time.Time, secret string) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	req := httptest.NewRequest(method, uri, strings.NewReader(body))
+	req.Header.Set(KeyIDHeader, testKeyID)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, Sign([]byte(secret), method, uri, ts, nonce, []byte(body)))
+	return req
+}
+
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	router := newTestRouter(NewHMACAuthenticator(staticSecrets{testKeyID: testSecret}))
+	now := time.Now()
+
+	w := serve(router, signedRequest(http.MethodPost, "/watch-only", `{"network":"acme"}`, "nonce-1", now, testSecret))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// The body is still readable by the handler
+	assert.Equal(t, `api-key:ak_0123456789abcdef keys:read_public {"network":"acme"}`, w.Body.String())
+
+	// Replayed request
+	w = serve(router, signedRequest(http.MethodPost, "/watch-only", `{"network":"acme"}`, "nonce-1", now, testSecret))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Same nonce of another request is also refused
+	w = serve(router, signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "nonce-1", now, testSecret))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(router, signedRequest(http.MethodGet, "/keygen/1/bitcoin?type=p2wpkh", "", "nonce-2", now, testSecret))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHMACAuthenticatorRejects(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		code    int
+	}{
+		{"unsigned", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/keygen/1/bitcoin", nil)
+		}, http.StatusUnauthorized},
+		{"wrong secret", func() *http.Request {
+			return signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now, "other-secret")
+		}, http.StatusUnauthorized},
+		{"unknown key", func() *http.Request {
+			req := signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now, testSecret)
+			req.Header.Set(KeyIDHeader, "ak_unknown")
+			return req
+		}, http.StatusUnauthorized},
+		{"tampered body", func() *http.Request {
+			req := signedRequest(http.MethodPost, "/watch-only", `{"network":"acme"}`, "n", now, testSecret)
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"network":"evil"}`)).Body
+			return req
+		}, http.StatusUnauthorized},
+		{"tampered path", func() *http.Request {
+			req := signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now, testSecret)
+			req.URL.RawQuery = "type=p2tr"
+			return req
+		}, http.StatusUnauthorized},
+		{"tampered method", func() *http.Request {
+			req := signedRequest(http.MethodGet, "/keygen/1/bitcoin/private-key", "", "n", now, testSecret)
+			req.Method = http.MethodPost
+			return req
+		}, http.StatusUnauthorized},
+		{"expired", func() *http.Request {
+			return signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now.Add(-DefaultMaxSkew-time.Minute), testSecret)
+		}, http.StatusUnauthorized},
+		{"from the future", func() *http.Request {
+			return signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now.Add(DefaultMaxSkew+time.Minute), testSecret)
+		}, http.StatusUnauthorized},
+		{"body too large", func() *http.Request {
+			return signedRequest(http.MethodPost, "/watch-only", strings.Repeat("a", maxSignedBodySize+1), "n", now, testSecret)
+		}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(NewHMACAuthenticator(staticSecrets{testKeyID: testSecret}))
+			w := serve(router, tt.request())
+			assert.Equal(t, tt.code, w.Code)
+			assert.Contains(t, w.Body.String(), `"error"`)
+		})
+	}
+
+	t.Run("sealed", func(t *testing.T) {
+		router := newTestRouter(NewHMACAuthenticator(sealedSecrets{}))
+		w := serve(router, signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", now, testSecret))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
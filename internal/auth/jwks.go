@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrUnknownSigningKey = stderrors.New("unknown token signing key")
+
+const (
+	// DefaultJWKSRefreshInterval is how long a fetched key set is used
+	// before it is fetched again.
+	DefaultJWKSRefreshInterval = 15 * time.Minute
+	// minJWKSRefreshInterval limits how often a token with an unknown key ID
+	// makes the key set be fetched again.
+	minJWKSRefreshInterval = time.Minute
+	maxJWKSSize            = 1 << 20
+)
+
+// JWKSSource returns a JSON Web Key Set document.
+type JWKSSource interface {
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// FileSource reads the key set from a local file, which is read again on
+// every refresh, so keys can be rotated by replacing the file.
+type FileSource string
+
+func (f FileSource) Fetch(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(string(f))
+}
+
+// URLSource fetches the key set over HTTP, e.g. from an OIDC provider's
+// jwks_uri.
+type URLSource struct {
+	URL    string
+	Client *http.Client
+}
+
+func (u URLSource) Fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u.URL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// KeySet caches the signing keys of a JWKS source by key ID. Keys are
+// fetched again once they are older than the refresh interval, or when a
+// token names a key ID that is not known yet. If fetching fails the cached
+// keys stay in use.
+type KeySet struct {
+	source             JWKSSource
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetched     time.Time
+	lastAttempt time.Time
+}
+
+func NewKeySet(source JWKSSource, refreshInterval time.Duration) *KeySet {
+	return &KeySet{
+		source:             source,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minJWKSRefreshInterval,
+		now:                time.Now,
+	}
+}
+
+// Load fetches the key set, it is called at startup to fail early on a
+// misconfigured source.
+func (k *KeySet) Load(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.refresh(ctx)
+}
+
+// Key returns the key with kid. An empty kid selects the only key of a set
+// with a single key.
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	key, known := k.lookup(kid)
+	stale := now.Sub(k.fetched) > k.refreshInterval
+	if (stale || !known) && now.Sub(k.lastAttempt) >= k.minRefreshInterval {
+		if err := k.refresh(ctx); err != nil {
+			log.WithError(err).Error("Failed to refresh JWKS, keeping the cached keys")
+		}
+		key, known = k.lookup(kid)
+	}
+	if !known {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+func (k *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// refresh must be called with mu held.
+func (k *KeySet) refresh(ctx context.Context) error {
+	k.lastAttempt = k.now()
+	document, err := k.source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(document)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return stderrors.New("JWKS contains no usable signing key")
+	}
+	k.keys, k.fetched = keys, k.lastAttempt
+	log.WithField("keys", len(keys)).Info("Loaded JWKS")
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA, EC (P-256, P-384, P-521) and Ed25519
+// signature keys of a JWKS document by key ID. Keys of other types or for
+// encryption are skipped.
+func ParseJWKS(document []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(document, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK %q: %w", key.Kid, err)
+		}
+		if publicKey == nil {
+			log.WithFields(log.Fields{"kid": key.Kid, "kty": key.Kty}).Debug("Skipping unsupported JWK")
+			continue
+		}
+		if _, exists := keys[key.Kid]; exists {
+			return nil, fmt.Errorf("duplicate JWK %q", key.Kid)
+		}
+		keys[key.Kid] = publicKey
+	}
+	return keys, nil
+}
+
+// publicKey returns nil for unsupported key types.
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, stderrors.New("RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, stderrors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, stderrors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, stderrors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
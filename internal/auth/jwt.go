@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// jwtLeeway tolerates clock skew between the issuer and the service.
+const jwtLeeway = 30 * time.Second
+
+// jwtMethods are the accepted signature algorithms, symmetric algorithms
+// are excluded so a public key can never be used as an HMAC secret.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWTAuthenticator accepts bearer tokens signed by a key of a JWKS. Tokens
+// must be issued by issuer for audience, carry a subject and expire.
+type JWTAuthenticator struct {
+	keys   *KeySet
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(keys *KeySet, issuer, audience string) *JWTAuthenticator {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+		jwt.WithAudience(audience),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	return &JWTAuthenticator{keys: keys, parser: jwt.NewParser(options...)}
+}
+
+// tokenClaims reads scopes from the OAuth 2.0 "scope" claim, a space
+// separated string, and from "scp", a string or a list, as used by some
+// identity providers.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string           `json:"scope"`
+	Scp   jwt.ClaimStrings `json:"scp"`
+}
+
+func (c tokenClaims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	return append(scopes, c.Scp...)
+}
+
+// Applies to requests with a bearer token.
+func (a *JWTAuthenticator) Applies(c *gin.Context) bool {
+	_, ok := bearerToken(c)
+	return ok
+}
+
+// Authenticate maps the token's subject and scopes to the principal.
+func (a *JWTAuthenticator) Authenticate(c *gin.Context) (Principal, error) {
+	raw, _ := bearerToken(c)
+	var claims tokenClaims
+	_, err := a.parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.Key(c.Request.Context(), kid)
+	})
+	if err != nil {
+		log.WithError(err).Warn("Invalid bearer token")
+		return Principal{}, errors.ErrUnauthorized
+	}
+	if claims.Subject == "" {
+		log.Warn("Bearer token without subject")
+		return Principal{}, errors.ErrUnauthorized
+	}
+	return Principal{ID: claims.Subject, Method: MethodJWT, Scopes: claims.scopes()}, nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "https://issuer.example"
+	testAudience = "crypto-keygen-service"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwkOf renders the public part of key as a JWK.
+func jwkOf(t *testing.T, kid string, key crypto.Signer) map[string]string {
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(public.N.Bytes()), "e": b64(big.NewInt(int64(public.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "crv": public.Curve.Params().Name, "x": b64(public.X.FillBytes(make([]byte, size))), "y": b64(public.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(public)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	document, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, document, 0o600))
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key crypto.Signer, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims(changes jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "keys:generate keys:read_public",
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/keygen/1/bitcoin", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile, jwkOf(t, "rsa", rsaKey), jwkOf(t, "ec", ecKey), jwkOf(t, "ed", edKey),
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"})
+	keys := NewKeySet(FileSource(jwksFile), DefaultJWKSRefreshInterval)
+	require.NoError(t, keys.Load(context.Background()))
+	router := newTestRouter(NewHMACAuthenticator(staticSecrets{testKeyID: testSecret}), NewJWTAuthenticator(keys, testIssuer, testAudience))
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+		body  string
+	}{
+		{"rsa", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(nil)),
+			http.StatusOK, "jwt:alice keys:generate,keys:read_public "},
+		{"rsa-pss", signToken(t, jwt.SigningMethodPS384, "rsa", rsaKey, validClaims(nil)),
+			http.StatusOK, "jwt:alice keys:generate,keys:read_public "},
+		{"ec with scp list", signToken(t, jwt.SigningMethodES256, "ec", ecKey, validClaims(jwt.MapClaims{"scope": nil, "scp": []string{"keys:reveal_private"}})),
+			http.StatusOK, "jwt:alice keys:reveal_private "},
+		{"ed25519 with scp string", signToken(t, jwt.SigningMethodEdDSA, "ed", edKey, validClaims(jwt.MapClaims{"scope": nil, "scp": "keys:read_public"})),
+			http.StatusOK, "jwt:alice keys:read_public "},
+		{"expired", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})),
+			http.StatusUnauthorized, ""},
+		{"without expiry", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"exp": nil})),
+			http.StatusUnauthorized, ""},
+		{"not yet valid", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()})),
+			http.StatusUnauthorized, ""},
+		{"wrong audience", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"aud": "other"})),
+			http.StatusUnauthorized, ""},
+		{"wrong issuer", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"iss": "https://evil.example"})),
+			http.StatusUnauthorized, ""},
+		{"without subject", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims(jwt.MapClaims{"sub": nil})),
+			http.StatusUnauthorized, ""},
+		{"unknown kid", signToken(t, jwt.SigningMethodRS256, "other", otherKey, validClaims(nil)),
+			http.StatusUnauthorized, ""},
+		{"signed by another key", signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims(nil)),
+			http.StatusUnauthorized, ""},
+		{"key of another type", signToken(t, jwt.SigningMethodRS256, "ec", rsaKey, validClaims(nil)),
+			http.StatusUnauthorized, ""},
+		{"encryption key", signToken(t, jwt.SigningMethodRS256, "enc", rsaKey, validClaims(nil)),
+			http.StatusUnauthorized, ""},
+		{"malformed", "not-a-token", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, bearerRequest(tt.token))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusOK {
+				assert.Equal(t, tt.body, w.Body.String())
+			} else {
+				assert.Contains(t, w.Body.String(), `"error"`)
+			}
+		})
+	}
+
+	t.Run("symmetric algorithms", func(t *testing.T) {
+		// An HMAC token keyed with the public key must not verify
+		publicKey, err := json.Marshal(jwkOf(t, "rsa", rsaKey))
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(nil))
+		token.Header["kid"] = "rsa"
+		signed, err := token.SignedString(publicKey)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, serve(router, bearerRequest(signed)).Code)
+
+		unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, serve(router, bearerRequest(unsigned)).Code)
+	})
+
+	t.Run("signed requests still work", func(t *testing.T) {
+		w := serve(router, signedRequest(http.MethodGet, "/keygen/1/bitcoin", "", "n", time.Now(), testSecret))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile, jwkOf(t, "old", oldKey))
+	now := time.Now()
+	keys := NewKeySet(FileSource(jwksFile), time.Hour)
+	keys.now = func() time.Time { return now }
+	require.NoError(t, keys.Load(context.Background()))
+	router := newTestRouter(NewJWTAuthenticator(keys, testIssuer, testAudience))
+
+	oldToken := signToken(t, jwt.SigningMethodES256, "old", oldKey, validClaims(nil))
+	newToken := signToken(t, jwt.SigningMethodES384, "new", newKey, validClaims(nil))
+	assert.Equal(t, http.StatusOK, serve(router, bearerRequest(oldToken)).Code)
+
+	// A new key is picked up as soon as a token uses it
+	writeJWKS(t, jwksFile, jwkOf(t, "old", oldKey), jwkOf(t, "new", newKey))
+	now = now.Add(minJWKSRefreshInterval)
+	assert.Equal(t, http.StatusOK, serve(router, bearerRequest(newToken)).Code)
+
+	// Known keys are served from the cache until the refresh interval passed
+	writeJWKS(t, jwksFile, jwkOf(t, "new", newKey))
+	assert.Equal(t, http.StatusOK, serve(router, bearerRequest(oldToken)).Code)
+
+	// The retired key is dropped at the next refresh
+	now = now.Add(time.Hour + time.Second)
+	assert.Equal(t, http.StatusUnauthorized, serve(router, bearerRequest(oldToken)).Code)
+	assert.Equal(t, http.StatusOK, serve(router, bearerRequest(newToken)).Code)
+
+	// Cached keys are kept when the source fails
+	require.NoError(t, os.WriteFile(jwksFile, []byte("{"), 0o600))
+	now = now.Add(time.Hour + time.Second)
+	assert.Equal(t, http.StatusOK, serve(router, bearerRequest(newToken)).Code)
+}
+
+func TestURLSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	document, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{jwkOf(t, "", key)}})
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	assert.Error(t, NewKeySet(URLSource{URL: server.URL + "/missing"}, time.Hour).Load(context.Background()))
+
+	keys := NewKeySet(URLSource{URL: server.URL + "/.well-known/jwks.json", Client: server.Client()}, time.Hour)
+	require.NoError(t, keys.Load(context.Background()))
+	// A single key without ID verifies tokens without kid
+	router := newTestRouter(NewJWTAuthenticator(keys, "", testAudience))
+	w := serve(router, bearerRequest(signToken(t, jwt.SigningMethodRS256, "", key, validClaims(jwt.MapClaims{"iss": nil}))))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestParseJWKSRejectsInvalidKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"RSA","kid":"weak","n":"` + b64(weak.N.Bytes()) + `","e":"AQAB"}]}`))
+	assert.Error(t, err)
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"off-curve","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.Error(t, err)
+
+	keys, err := ParseJWKS([]byte(`{"keys":[{"kty":"oct","kid":"symmetric","k":"c2VjcmV0"}]}`))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Authenticator verifies one kind of credentials.
+type Authenticator interface {
+	// Applies reports whether the request carries this kind of credentials.
+	Applies(c *gin.Context) bool
+	Authenticate(c *gin.Context) (Principal, error)
+}
+
+// Middleware authenticates every request with the first authenticator that
+// applies to it and attaches the Principal to the request context. Requests
+// without credentials are rejected.
+func Middleware(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, authenticator := range authenticators {
+			if !authenticator.Applies(c) {
+				continue
+			}
+			principal, err := authenticator.Authenticate(c)
+			if err != nil {
+				log.WithField("path", c.Request.URL.Path).WithError(err).Warn("Rejected API request")
+				abort(c, err)
+				return
+			}
+			setPrincipal(c, principal)
+			c.Next()
+			return
+		}
+
+		log.WithField("path", c.Request.URL.Path).Warn("Rejected unauthenticated API request")
+		abort(c, errors.ErrUnauthorized)
+	}
+}
+
+// abort answers like handlers.handleServiceError.
+func abort(c *gin.Context, err error) {
+	if apiErr, ok := err.(*errors.KeyGenError); ok {
+		c.AbortWithStatusJSON(apiErr.Code, gin.H{"error": apiErr.Message})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": errors.ErrInternalServerError.Message})
+}
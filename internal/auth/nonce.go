@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers the nonces of accepted requests.
+type NonceStore interface {
+	// Add remembers nonce until expires and reports whether it was new.
+	Add(ctx context.Context, nonce string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceStore keeps the nonces of a single replica.
+type MemoryNonceStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{now: time.Now, nonces: make(map[string]time.Time)}
+}
+
+func (n *MemoryNonceStore) Add(_ context.Context, nonce string, expires time.Time) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	if now.Sub(n.lastPrune) > time.Minute {
+		for seen, expiry := range n.nonces {
+			if now.After(expiry) {
+				delete(n.nonces, seen)
+			}
+		}
+		n.lastPrune = now
+	}
+
+	if expiry, seen := n.nonces[nonce]; seen && now.Before(expiry) {
+		return false, nil
+	}
+	n.nonces[nonce] = expires
+	return true, nil
+}
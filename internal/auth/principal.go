@@ -0,0 +1,101 @@
+// Package auth authenticates API callers and attaches the resulting
+// Principal to the request context.
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// MethodAPIKey principals signed their request with an API client secret.
+	MethodAPIKey = "api-key"
+	// MethodJWT principals presented a bearer token.
+	MethodJWT = "jwt"
+)
+
+// Scopes grant access to the key and /sys endpoints.
+const (
+	ScopeGenerate       = "keys:generate"
+	ScopeReadPublic     = "keys:read_public"
+	ScopeRevealPrivate  = "keys:reveal_private"
+	ScopeImport         = "keys:import"
+	ScopeSign           = "keys:sign"
+	ScopeManageAccounts = "accounts:manage"
+	ScopeSys            = "sys:manage"
+)
+
+// Scopes lists every known scope.
+var Scopes = []string{ScopeGenerate, ScopeReadPublic, ScopeRevealPrivate, ScopeImport, ScopeSign, ScopeManageAccounts, ScopeSys}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// ID is unique per Method, e.g. the API client key ID or the token subject
+	ID     string
+	Method string
+	Scopes []string
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// String identifies the principal in logs and in the audit log.
+func (p Principal) String() string {
+	return p.Method + ":" + p.ID
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of an authenticated request.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Authorize fails with ErrForbidden unless the principal of ctx has scope,
+// or with the error scope was restricted with, see Restrict. Calls without a
+// principal, from the command line tools or with authentication disabled,
+// are allowed.
+func Authorize(ctx context.Context, scope string) error {
+	principal, ok := PrincipalFrom(ctx)
+	if ok && !principal.HasScope(scope) {
+		return errors.ErrForbidden
+	}
+	if restrictions, ok := ctx.Value(restrictionsKey{}).(map[string]error); ok && restrictions[scope] != nil {
+		return restrictions[scope]
+	}
+	return nil
+}
+
+type restrictionsKey struct{}
+
+// Restrict returns a context in which Authorize fails with err for scope. It
+// carries decisions made before calling a service that only finds out
+// whether it needs scope on the way, like the authorization policy deciding
+// whether a request may generate keys.
+func Restrict(ctx context.Context, scope string, err error) context.Context {
+	restrictions := map[string]error{scope: err}
+	if parent, ok := ctx.Value(restrictionsKey{}).(map[string]error); ok {
+		for s, e := range parent {
+			if s != scope {
+				restrictions[s] = e
+			}
+		}
+	}
+	return context.WithValue(ctx, restrictionsKey{}, restrictions)
+}
+
+// setPrincipal attaches principal to the request context of c.
+func setPrincipal(c *gin.Context, principal Principal) {
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+}
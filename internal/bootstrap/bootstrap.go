@@ -0,0 +1,108 @@
+// Package bootstrap sets up what the service and its commands share from the
+// environment: the database, the key manager and the audit log.
+package bootstrap
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/db/mongo"
+	"crypto-keygen-service/internal/repositories"
+	"crypto-keygen-service/internal/services"
+	"crypto-keygen-service/internal/util/kms"
+	"fmt"
+	"os"
+)
+
+// RequireEnv fails unless every one of names is set.
+func RequireEnv(names ...string) error {
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			return fmt.Errorf("environment variable %s is not set", name)
+		}
+	}
+	return nil
+}
+
+// IsProduction reports whether APP_ENV is production. An unset APP_ENV is
+// treated as production to keep existing deployments working.
+func IsProduction() bool {
+	env := os.Getenv("APP_ENV")
+	return env == "" || env == "production"
+}
+
+// Database connects to MONGODB_URI and uses the DB_NAME database and the
+// DB_COLLECTION collection.
+func Database() (*mongo.MongoDatabase, error) {
+	if err := RequireEnv("MONGODB_URI", "DB_NAME", "DB_COLLECTION"); err != nil {
+		return nil, err
+	}
+	database, err := mongo.NewMongoDatabase(os.Getenv("MONGODB_URI"), os.Getenv("DB_NAME"), os.Getenv("DB_COLLECTION"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize MongoDB: %w", err)
+	}
+	return database, nil
+}
+
+// KeyManager creates the key manager configured by kms.ConfigFromEnv and
+// returns the configuration it was created from.
+func KeyManager() (kms.KeyManager, kms.Config, error) {
+	cfg, err := kms.ConfigFromEnv()
+	if err != nil {
+		return nil, kms.Config{}, fmt.Errorf("invalid key manager configuration: %w", err)
+	}
+	keyManager, err := kms.New(cfg)
+	if err != nil {
+		return nil, kms.Config{}, fmt.Errorf("failed to set up key manager: %w", err)
+	}
+	return keyManager, cfg, nil
+}
+
+// AuditSink opens the audit log selected by AUDIT_SINK: the
+// <DB_COLLECTION>_audit collection of database (the default) or the JSON
+// lines file AUDIT_FILE. database is only used by the mongo sink.
+func AuditSink(ctx context.Context, database *mongo.MongoDatabase) (audit.Sink, error) {
+	switch os.Getenv("AUDIT_SINK") {
+	case "", "mongo":
+		sink, err := mongo.NewAuditSink(ctx, database.Client.Database(os.Getenv("DB_NAME")), os.Getenv("DB_COLLECTION")+"_audit")
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize audit log: %w", err)
+		}
+		return sink, nil
+	case "file":
+		if err := RequireEnv("AUDIT_FILE"); err != nil {
+			return nil, err
+		}
+		sink, err := audit.NewFileSink(os.Getenv("AUDIT_FILE"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown AUDIT_SINK %q, expected mongo or file", os.Getenv("AUDIT_SINK"))
+	}
+}
+
+// OfflineService sets up a KeyGenService without a master seed for the
+// commands that work on stored records, with the key manager, database and
+// audit log the service uses. It also returns the key manager.
+func OfflineService(ctx context.Context) (*services.KeyGenService, kms.KeyManager, error) {
+	keyManager, _, err := KeyManager()
+	if err != nil {
+		return nil, nil, err
+	}
+	database, err := Database()
+	if err != nil {
+		return nil, nil, err
+	}
+	sink, err := AuditSink(ctx, database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyGenService := services.NewKeyGenService(repositories.NewKeyGenRepository(database), nil, keyManager)
+	keyGenService.SetAuditLogger(audit.NewLogger(sink))
+	if os.Getenv("REQUIRE_BOUND_RECORDS") == "true" {
+		keyGenService.RequireBoundRecords()
+	}
+	return keyGenService, keyManager, nil
+}
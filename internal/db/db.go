@@ -1,18 +1,143 @@
 package db
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
+var (
+	// ErrDuplicate is returned when a unique record already exists.
+	ErrDuplicate = errors.New("duplicate record")
+	// ErrNotFound is returned when a record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a record changed since it was read.
+	ErrConflict = errors.New("record was modified concurrently")
+)
+
+// CurrentSchemaVersion of KeyData. Records without a version predate binding
+// the encrypted private key to its record through AEAD associated data,
+// version 2 records bind it to the address but not to the public key.
+const CurrentSchemaVersion = 3
+
+// KeyData is a persisted key record. Private keys are sealed under a
+// per-record data key, WrappedDataKey is that data key wrapped by the
+// key-encryption key KEKID; both are empty for records encrypted directly
+// under ENCRYPTION_KEY. WatchOnly records are derived from a registered
+// extended public key and have no EncryptedPrivateKey.
 type KeyData struct {
 	UserID              int    `bson:"user_id" json:"user_id"`
 	Network             string `bson:"network" json:"network"`
 	Address             string `bson:"address" json:"address"`
 	PublicKey           string `bson:"public_key" json:"public_key"`
 	EncryptedPrivateKey string `bson:"private_key" json:"private_key"`
+	WrappedDataKey      string `bson:"wrapped_data_key,omitempty" json:"wrapped_data_key,omitempty"`
+	KEKID               string `bson:"kek_id,omitempty" json:"kek_id,omitempty"`
+	DerivationPath      string `bson:"derivation_path,omitempty" json:"derivation_path,omitempty"`
+	AddressType         string `bson:"address_type,omitempty" json:"address_type,omitempty"`
+	Chain               string `bson:"chain,omitempty" json:"chain,omitempty"`
+	ChainID             uint64 `bson:"chain_id,omitempty" json:"chain_id,omitempty"`
+	WatchOnly           bool   `bson:"watch_only,omitempty" json:"watch_only,omitempty"`
+	SchemaVersion       int    `bson:"schema_version,omitempty" json:"schema_version,omitempty"`
+	// Provenance tells derived keys from imported ones, records without one
+	// are derived.
+	Provenance string `bson:"provenance,omitempty" json:"provenance,omitempty"`
+}
+
+// Provenances of KeyData.
+const (
+	// ProvenanceDerived keys are derived from the master seed or, for
+	// watch-only records, from a registered extended public key.
+	ProvenanceDerived = "derived"
+	// ProvenanceImported keys were generated elsewhere, see
+	// KeyGenService.ImportKey.
+	ProvenanceImported = "imported"
+)
+
+// WatchOnlyAccount is an extended public key registered by a tenant that
+// keeps its own seed. Network is the name under which its addresses are
+// served, BaseNetwork the seed-based network whose address format it uses.
+type WatchOnlyAccount struct {
+	Network           string    `bson:"network" json:"network"`
+	BaseNetwork       string    `bson:"base_network" json:"base_network"`
+	ExtendedPublicKey string    `bson:"extended_public_key" json:"extended_public_key"`
+	DerivationPath    string    `bson:"derivation_path" json:"derivation_path"`
+	CreatedAt         time.Time `bson:"created_at" json:"created_at"`
+}
+
+// APIClient is a caller authenticated by signing requests with a shared
+// secret, see auth.HMACAuthenticator. The secret is envelope encrypted like
+// private keys, bound to KeyID.
+type APIClient struct {
+	KeyID           string    `bson:"key_id" json:"key_id"`
+	Name            string    `bson:"name" json:"name"`
+	EncryptedSecret string    `bson:"secret" json:"secret"`
+	WrappedDataKey  string    `bson:"wrapped_data_key" json:"wrapped_data_key"`
+	KEKID           string    `bson:"kek_id" json:"kek_id"`
+	Scopes          []string  `bson:"scopes" json:"scopes"`
+	Disabled        bool      `bson:"disabled,omitempty" json:"disabled,omitempty"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+}
+
+// States of a RevealRequest.
+const (
+	RevealPending  = "pending"
+	RevealApproved = "approved"
+	RevealFetched  = "fetched"
+	RevealExpired  = "expired"
+)
+
+// RevealRequest asks for the private key of UserID on Network. It is
+// pending until Required designated approvers approved it, the requester can
+// then fetch the key once before FetchExpiresAt.
+type RevealRequest struct {
+	ID             string           `bson:"request_id" json:"id"`
+	UserID         int              `bson:"user_id" json:"user_id"`
+	Network        string           `bson:"network" json:"network"`
+	Requester      string           `bson:"requester" json:"requester"`
+	State          string           `bson:"state" json:"state"`
+	Required       int              `bson:"required" json:"required"`
+	Approvals      []RevealApproval `bson:"approvals" json:"approvals"`
+	CreatedAt      time.Time        `bson:"created_at" json:"created_at"`
+	ExpiresAt      time.Time        `bson:"expires_at" json:"expires_at"`
+	FetchExpiresAt time.Time        `bson:"fetch_expires_at,omitempty" json:"fetch_expires_at,omitempty"`
+	FetchedAt      time.Time        `bson:"fetched_at,omitempty" json:"fetched_at,omitempty"`
+	// Version is incremented by every update, see UpdateRevealRequest
+	Version int `bson:"version" json:"version"`
+}
+
+type RevealApproval struct {
+	Approver   string    `bson:"approver" json:"approver"`
+	ApprovedAt time.Time `bson:"approved_at" json:"approved_at"`
 }
 
 type Database interface {
 	SaveKey(ctx context.Context, keyData KeyData) error
+	// InsertKey inserts a new key record, it fails with ErrDuplicate if the
+	// user has a record on the network.
+	InsertKey(ctx context.Context, keyData KeyData) error
 	GetKey(ctx context.Context, userID int, network string) (KeyData, error)
 	KeyExists(ctx context.Context, userID int, network string) (bool, error)
+	CountKeys(ctx context.Context) (int64, error)
+	// ForEachKey calls fn for every stored key record until fn returns an error.
+	ForEachKey(ctx context.Context, fn func(KeyData) error) error
+	SaveWatchOnlyAccount(ctx context.Context, account WatchOnlyAccount) error
+	GetWatchOnlyAccounts(ctx context.Context) ([]WatchOnlyAccount, error)
+	// SaveAPIClient inserts a new client, it fails with ErrDuplicate if the
+	// key ID exists.
+	SaveAPIClient(ctx context.Context, client APIClient) error
+	// UpdateAPIClient replaces an existing client.
+	UpdateAPIClient(ctx context.Context, client APIClient) error
+	// GetAPIClient fails with ErrNotFound for unknown key IDs.
+	GetAPIClient(ctx context.Context, keyID string) (APIClient, error)
+	GetAPIClients(ctx context.Context) ([]APIClient, error)
+	// SaveRevealRequest inserts a new request, it fails with ErrDuplicate if
+	// the ID exists.
+	SaveRevealRequest(ctx context.Context, request RevealRequest) error
+	// UpdateRevealRequest replaces the request if its stored Version is still
+	// request.Version and increments it, otherwise it fails with ErrConflict.
+	UpdateRevealRequest(ctx context.Context, request RevealRequest) (RevealRequest, error)
+	// GetRevealRequest fails with ErrNotFound for unknown IDs.
+	GetRevealRequest(ctx context.Context, id string) (RevealRequest, error)
 	CreateIndexes(ctx context.Context) error
 }
@@ -0,0 +1,99 @@
+package mongo
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const auditHeadID = "head"
+
+// AuditSink stores audit entries in one collection, with a unique index on
+// their sequence number so that replicas sharing the log cannot fork the
+// chain, and the head checkpoint in a second one.
+type AuditSink struct {
+	Entries *mongo.Collection
+	Heads   *mongo.Collection
+}
+
+// NewAuditSink uses the collections collectionName and collectionName_head.
+func NewAuditSink(ctx context.Context, database *mongo.Database, collectionName string) (*AuditSink, error) {
+	sink := &AuditSink{
+		Entries: database.Collection(collectionName),
+		Heads:   database.Collection(collectionName + "_head"),
+	}
+	_, err := sink.Entries.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *AuditSink) Append(ctx context.Context, entry audit.Entry) error {
+	if _, err := s.Entries.InsertOne(ctx, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return audit.ErrConflict
+		}
+		return err
+	}
+
+	// Only move the head forward, a replica that appended later may have
+	// updated it already
+	filter := bson.M{"_id": auditHeadID, "seq": bson.M{"$lt": entry.Seq}}
+	update := bson.M{"$set": audit.Anchor{Seq: entry.Seq, Hash: entry.Hash}}
+	_, err := s.Heads.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *AuditSink) Last(ctx context.Context) (audit.Entry, bool, error) {
+	var entry audit.Entry
+	err := s.Entries.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return audit.Entry{}, false, nil
+	}
+	if err != nil {
+		return audit.Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (s *AuditSink) Head(ctx context.Context) (audit.Anchor, bool, error) {
+	var head audit.Anchor
+	err := s.Heads.FindOne(ctx, bson.M{"_id": auditHeadID}).Decode(&head)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return audit.Anchor{}, false, nil
+	}
+	if err != nil {
+		return audit.Anchor{}, false, err
+	}
+	return head, true, nil
+}
+
+func (s *AuditSink) ForEach(ctx context.Context, fn func(audit.Entry) error) error {
+	cursor, err := s.Entries.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry audit.Entry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -13,8 +13,12 @@ import (
 )
 
 type MongoDatabase struct {
-	Collection *mongo.Collection
-	Client     *mongo.Client
+	Collection          *mongo.Collection
+	WatchOnlyCollection *mongo.Collection
+	APIClientCollection *mongo.Collection
+	// RevealRequestCollection holds the quorum approved reveal requests
+	RevealRequestCollection *mongo.Collection
+	Client                  *mongo.Client
 }
 
 func NewMongoDatabase(mongoURI, dbName, collectionName string) (*MongoDatabase, error) {
@@ -33,7 +37,16 @@ func NewMongoDatabase(mongoURI, dbName, collectionName string) (*MongoDatabase,
 	}
 
 	collection := client.Database(dbName).Collection(collectionName)
-	db := &MongoDatabase{Collection: collection, Client: client}
+	watchOnlyCollection := client.Database(dbName).Collection(collectionName + "_watch_only")
+	apiClientCollection := client.Database(dbName).Collection(collectionName + "_api_clients")
+	revealRequestCollection := client.Database(dbName).Collection(collectionName + "_reveal_requests")
+	db := &MongoDatabase{
+		Collection:              collection,
+		WatchOnlyCollection:     watchOnlyCollection,
+		APIClientCollection:     apiClientCollection,
+		RevealRequestCollection: revealRequestCollection,
+		Client:                  client,
+	}
 	err = db.CreateIndexes(context.Background())
 	if err != nil {
 		return nil, err
@@ -50,6 +63,33 @@ func (db *MongoDatabase) CreateIndexes(ctx context.Context) error {
 		Options: options.Index().SetUnique(true),
 	}
 	_, err := db.Collection.Indexes().CreateOne(ctx, indexModel)
+	if err != nil {
+		return err
+	}
+
+	watchOnlyIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "network", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = db.WatchOnlyCollection.Indexes().CreateOne(ctx, watchOnlyIndexModel)
+	if err != nil {
+		return err
+	}
+
+	apiClientIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = db.APIClientCollection.Indexes().CreateOne(ctx, apiClientIndexModel)
+	if err != nil {
+		return err
+	}
+
+	revealRequestIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "request_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = db.RevealRequestCollection.Indexes().CreateOne(ctx, revealRequestIndexModel)
 	return err
 }
 
@@ -71,6 +111,20 @@ func (db *MongoDatabase) SaveKey(ctx context.Context, keyData dbi.KeyData) error
 	return err
 }
 
+func (db *MongoDatabase) InsertKey(ctx context.Context, keyData dbi.KeyData) error {
+	_, err := db.Collection.InsertOne(ctx, keyData)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return dbi.ErrDuplicate
+		}
+		log.WithFields(log.Fields{
+			"user_id": keyData.UserID,
+			"network": keyData.Network,
+		}).WithError(err).Error("Failed to insert keys into repository")
+	}
+	return err
+}
+
 func (db *MongoDatabase) GetKey(ctx context.Context, userID int, network string) (dbi.KeyData, error) {
 	filter := bson.M{"user_id": userID, "network": network}
 	var keyData dbi.KeyData
@@ -100,3 +154,142 @@ func (db *MongoDatabase) KeyExists(ctx context.Context, userID int, network stri
 	}
 	return count > 0, nil
 }
+
+func (db *MongoDatabase) CountKeys(ctx context.Context) (int64, error) {
+	return db.Collection.CountDocuments(ctx, bson.M{})
+}
+
+func (db *MongoDatabase) ForEachKey(ctx context.Context, fn func(dbi.KeyData) error) error {
+	// Iterate in _id order so that records updated by fn are not visited twice
+	cursor, err := db.Collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.WithError(err).Error("Failed to iterate keys")
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var keyData dbi.KeyData
+		if err := cursor.Decode(&keyData); err != nil {
+			log.WithError(err).Error("Failed to decode key record")
+			return err
+		}
+		if err := fn(keyData); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (db *MongoDatabase) SaveWatchOnlyAccount(ctx context.Context, account dbi.WatchOnlyAccount) error {
+	_, err := db.WatchOnlyCollection.InsertOne(ctx, account)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return dbi.ErrDuplicate
+		}
+		log.WithField("network", account.Network).WithError(err).Error("Failed to save watch-only account")
+	}
+	return err
+}
+
+func (db *MongoDatabase) GetWatchOnlyAccounts(ctx context.Context) ([]dbi.WatchOnlyAccount, error) {
+	cursor, err := db.WatchOnlyCollection.Find(ctx, bson.M{})
+	if err != nil {
+		log.WithError(err).Error("Failed to list watch-only accounts")
+		return nil, err
+	}
+	var accounts []dbi.WatchOnlyAccount
+	if err := cursor.All(ctx, &accounts); err != nil {
+		log.WithError(err).Error("Failed to decode watch-only accounts")
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (db *MongoDatabase) SaveAPIClient(ctx context.Context, client dbi.APIClient) error {
+	_, err := db.APIClientCollection.InsertOne(ctx, client)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return dbi.ErrDuplicate
+		}
+		log.WithField("key_id", client.KeyID).WithError(err).Error("Failed to save API client")
+	}
+	return err
+}
+
+func (db *MongoDatabase) UpdateAPIClient(ctx context.Context, client dbi.APIClient) error {
+	result, err := db.APIClientCollection.ReplaceOne(ctx, bson.M{"key_id": client.KeyID}, client)
+	if err != nil {
+		log.WithField("key_id", client.KeyID).WithError(err).Error("Failed to update API client")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return dbi.ErrNotFound
+	}
+	return nil
+}
+
+func (db *MongoDatabase) GetAPIClient(ctx context.Context, keyID string) (dbi.APIClient, error) {
+	var client dbi.APIClient
+	err := db.APIClientCollection.FindOne(ctx, bson.M{"key_id": keyID}).Decode(&client)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dbi.APIClient{}, dbi.ErrNotFound
+		}
+		log.WithError(err).Error("Failed to retrieve API client")
+		return dbi.APIClient{}, err
+	}
+	return client, nil
+}
+
+func (db *MongoDatabase) GetAPIClients(ctx context.Context) ([]dbi.APIClient, error) {
+	cursor, err := db.APIClientCollection.Find(ctx, bson.M{})
+	if err != nil {
+		log.WithError(err).Error("Failed to list API clients")
+		return nil, err
+	}
+	var clients []dbi.APIClient
+	if err := cursor.All(ctx, &clients); err != nil {
+		log.WithError(err).Error("Failed to decode API clients")
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (db *MongoDatabase) SaveRevealRequest(ctx context.Context, request dbi.RevealRequest) error {
+	_, err := db.RevealRequestCollection.InsertOne(ctx, request)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return dbi.ErrDuplicate
+		}
+		log.WithField("request_id", request.ID).WithError(err).Error("Failed to save reveal request")
+	}
+	return err
+}
+
+func (db *MongoDatabase) UpdateRevealRequest(ctx context.Context, request dbi.RevealRequest) (dbi.RevealRequest, error) {
+	filter := bson.M{"request_id": request.ID, "version": request.Version}
+	request.Version++
+	result, err := db.RevealRequestCollection.ReplaceOne(ctx, filter, request)
+	if err != nil {
+		log.WithField("request_id", request.ID).WithError(err).Error("Failed to update reveal request")
+		return dbi.RevealRequest{}, err
+	}
+	if result.MatchedCount == 0 {
+		return dbi.RevealRequest{}, dbi.ErrConflict
+	}
+	return request, nil
+}
+
+func (db *MongoDatabase) GetRevealRequest(ctx context.Context, id string) (dbi.RevealRequest, error) {
+	var request dbi.RevealRequest
+	err := db.RevealRequestCollection.FindOne(ctx, bson.M{"request_id": id}).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return dbi.RevealRequest{}, dbi.ErrNotFound
+		}
+		log.WithError(err).Error("Failed to retrieve reveal request")
+		return dbi.RevealRequest{}, err
+	}
+	return request, nil
+}
@@ -0,0 +1,44 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NonceStore keeps the nonces of signed requests for all replicas. The nonce
+// is the document ID, so that only one replica can insert it, and a TTL
+// index drops it once it has expired.
+type NonceStore struct {
+	Collection *mongo.Collection
+}
+
+// NewNonceStore uses the collection collectionName with a TTL index on the
+// expiry of the nonces.
+func NewNonceStore(ctx context.Context, database *mongo.Database, collectionName string) (*NonceStore, error) {
+	store := &NonceStore{Collection: database.Collection(collectionName)}
+	_, err := store.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Add inserts nonce. A nonce that expired but was not removed yet is still
+// refused, its request is outside the accepted time window anyway.
+func (s *NonceStore) Add(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	_, err := s.Collection.InsertOne(ctx, bson.M{"_id": nonce, "expires": expires})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
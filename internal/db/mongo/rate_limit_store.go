@@ -0,0 +1,77 @@
+package mongo
+
+import (
+	"context"
+	"crypto-keygen-service/internal/ratelimit"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitStore keeps token buckets shared by all replicas. Each Take is a
+// single atomic update evaluated with the database clock, so replicas with
+// skewed clocks agree on the refill. Buckets expire once they are full again.
+type RateLimitStore struct {
+	Collection *mongo.Collection
+}
+
+type rateLimitBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+// NewRateLimitStore uses the collection collectionName with a TTL index on
+// the time buckets are full.
+func NewRateLimitStore(ctx context.Context, database *mongo.Database, collectionName string) (*RateLimitStore, error) {
+	store := &RateLimitStore{Collection: database.Collection(collectionName)}
+	_, err := store.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	burst := float64(limit.Burst)
+	// Milliseconds since the last update, missing for a new bucket
+	elapsed := bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updated", "$$NOW"}}}}
+	refilled := bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", burst}},
+		bson.M{"$multiply": bson.A{elapsed, limit.Rate / 1000}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$min": bson.A{burst, refilled}}, "updated": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+		// Full again after (burst - tokens) / rate seconds
+		{{Key: "$set", Value: bson.M{"expires": bson.M{"$add": bson.A{
+			"$$NOW",
+			bson.M{"$ceil": bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{burst, "$tokens"}}, 1000 / limit.Rate}}},
+		}}}}},
+	}
+
+	var b rateLimitBucket
+	update := func() error {
+		return s.Collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline,
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&b)
+	}
+	err := update()
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica created the bucket concurrently, it is updated now
+		err = update()
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if b.Allowed {
+		return true, 0, nil
+	}
+	return false, time.Duration(math.Ceil((1 - b.Tokens) / limit.Rate * float64(time.Second))), nil
+}
@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
 	"strconv"
 
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/policy"
+	"crypto-keygen-service/internal/ratelimit"
 	"crypto-keygen-service/internal/services"
 	"crypto-keygen-service/internal/util/errors"
 
@@ -14,25 +22,428 @@ import (
 
 var validate *validator.Validate
 
+// RevealTokenHeader carries the token that authorizes a private key reveal.
+const RevealTokenHeader = "X-Reveal-Token"
+
 type KeyGenRequest struct {
-	UserID  int    `uri:"userId" validate:"required,gt=0"`
-	Network string `uri:"network" validate:"required"`
+	UserID      int    `uri:"userId" validate:"required,gt=0"`
+	Network     string `uri:"network" validate:"required"`
+	AddressType string `form:"type"`
 }
 
 type KeyGenHandler struct {
-	keyService *services.KeyGenService
+	keyService  *services.KeyGenService
+	revealToken string
+	policy      *policy.Engine
 }
 
-func NewKeyGenHandler(keyService *services.KeyGenService) *KeyGenHandler {
+// NewKeyGenHandler creates the handler. Private key reveal requests must
+// present revealToken; an empty revealToken rejects all of them.
+func NewKeyGenHandler(keyService *services.KeyGenService, revealToken string) *KeyGenHandler {
 	validate = validator.New()
-	return &KeyGenHandler{keyService: keyService}
+	return &KeyGenHandler{keyService: keyService, revealToken: revealToken}
+}
+
+// SetPolicy restricts the networks and user IDs callers may act on, without
+// a policy the scopes of the caller are the only restriction.
+func (h *KeyGenHandler) SetPolicy(engine *policy.Engine) {
+	h.policy = engine
 }
 
-func (h *KeyGenHandler) RegisterRoutes(router *gin.Engine) {
+// RegisterRoutes adds the key endpoints to router, a group carrying the
+// authentication middleware in production.
+func (h *KeyGenHandler) RegisterRoutes(router gin.IRouter) {
 	router.GET("/keygen/:userId/:network", h.handleGenerateKeyPair)
+	router.POST("/keygen/:userId/:network/export", h.handleExportPrivateKey)
+	router.POST("/keygen/:userId/:network/import", h.handleImportKey)
+	router.POST("/reveal-requests", h.handleOpenRevealRequest)
+	router.GET("/reveal-requests/:id", h.handleGetRevealRequest)
+	router.POST("/reveal-requests/:id/approve", h.handleApproveRevealRequest)
+	router.POST("/reveal-requests/:id/export", h.handleFetchExportedKey)
+	router.POST("/sign/message/:userId/:network", h.handleSignMessage)
+	router.POST("/sign/typed-data/:userId/:network", h.handleSignTypedData)
+	router.POST("/verify/message/:network", h.handleVerifyMessage)
+	router.GET("/xpub/:network", h.handleGetAccountKey)
+	router.POST("/watch-only", h.handleRegisterWatchOnlyAccount)
 }
 
 func (h *KeyGenHandler) handleGenerateKeyPair(c *gin.Context) {
+	req, ok := h.bindKeyGenRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := requestContext(c)
+	if !h.authorize(c, ctx, auth.ScopeReadPublic, audit.ActionRetrieve, req.UserID, req.Network) {
+		return
+	}
+	// Whether keys are generated is only known to the service, it asks for
+	// keys:generate when they do not exist yet
+	if err := h.policy.Authorize(policySubject(ctx), auth.ScopeGenerate, req.Network, req.UserID); err != nil {
+		ctx = auth.Restrict(ctx, auth.ScopeGenerate, err)
+	}
+
+	keyPairAndAddress, err := h.keyService.GetKeysAndAddress(ctx, req.UserID, req.Network)
+	if err != nil {
+		handleServiceError(c, err, req.UserID, req.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": req.UserID,
+		"network": req.Network,
+	}).Info("Successfully acquired keys")
+
+	c.JSON(http.StatusOK, newKeyGenResponse(keyPairAndAddress))
+}
+
+// handleExportPrivateKey returns the private key encrypted with a passphrase
+// or to an age recipient, it is authorized as a reveal.
+func (h *KeyGenHandler) handleExportPrivateKey(c *gin.Context) {
+	req, ok := h.bindKeyGenRequest(c)
+	if !ok {
+		return
+	}
+	options, ok := bindExportRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := requestContext(c)
+	if !h.authorize(c, ctx, auth.ScopeRevealPrivate, audit.ActionExportPrivateKey, req.UserID, req.Network) {
+		return
+	}
+	if !h.checkRevealToken(c, ctx, audit.ActionExportPrivateKey, req.UserID, req.Network) {
+		return
+	}
+
+	exported, err := h.keyService.ExportPrivateKey(ctx, req.UserID, req.Network, options)
+	if err != nil {
+		handleServiceError(c, err, req.UserID, req.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": req.UserID,
+		"network": req.Network,
+		"format":  exported.Format,
+	}).Warn("Exported private key")
+
+	c.JSON(http.StatusOK, newExportedKeyResponse(exported))
+}
+
+func (h *KeyGenHandler) handleImportKey(c *gin.Context) {
+	req, ok := h.bindKeyGenRequest(c)
+	if !ok {
+		return
+	}
+	var body ImportRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.WithError(err).Error("Invalid import request")
+		invalidBody(c, err)
+		return
+	}
+	if err := validate.Struct(body); err != nil {
+		log.WithError(err).Error("Validation error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
+		return
+	}
+
+	ctx := requestContext(c)
+	if !h.authorize(c, ctx, auth.ScopeImport, audit.ActionImport, req.UserID, req.Network) {
+		return
+	}
+
+	keyPairAndAddress, err := h.keyService.ImportKey(ctx, req.UserID, req.Network, services.KeyImport{
+		PrivateKey: body.PrivateKey,
+		Passphrase: body.Passphrase,
+		Address:    body.Address,
+	})
+	if err != nil {
+		handleServiceError(c, err, req.UserID, req.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": req.UserID,
+		"network": req.Network,
+	}).Warn("Imported private key")
+
+	c.JSON(http.StatusCreated, newKeyGenResponse(keyPairAndAddress))
+}
+
+func (h *KeyGenHandler) handleOpenRevealRequest(c *gin.Context) {
+	var req RevealRequestBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("Invalid reveal request")
+		invalidBody(c, err)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.WithError(err).Error("Validation error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
+		return
+	}
+	network := h.keyService.ResolveNetwork(req.Network, req.AddressType)
+
+	if !h.authorize(c, requestContext(c), auth.ScopeRevealPrivate, audit.ActionRevealRequest, req.UserID, network) {
+		return
+	}
+
+	request, err := h.keyService.OpenRevealRequest(requestContext(c), req.UserID, network)
+	if err != nil {
+		handleServiceError(c, err, req.UserID, network)
+		return
+	}
+	c.JSON(http.StatusCreated, newRevealRequestResponse(request))
+}
+
+// handleGetRevealRequest returns a request to its requester or an approver
+// the policy allows to reveal the key of the request.
+func (h *KeyGenHandler) handleGetRevealRequest(c *gin.Context) {
+	request, ok := h.lookupRevealRequest(c, requestContext(c), audit.ActionRevealRequest)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newRevealRequestResponse(request))
+}
+
+// handleApproveRevealRequest approves a request for an approver the policy
+// allows to reveal the key of the request.
+func (h *KeyGenHandler) handleApproveRevealRequest(c *gin.Context) {
+	ctx := requestContext(c)
+	request, ok := h.lookupRevealRequest(c, ctx, audit.ActionRevealApprove)
+	if !ok {
+		return
+	}
+
+	request, err := h.keyService.ApproveRevealRequest(ctx, request.ID)
+	if err != nil {
+		handleServiceError(c, err, request.UserID, request.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": request.ID,
+		"approvals":  len(request.Approvals),
+		"required":   request.Required,
+	}).Warn("Approved private key reveal request")
+
+	c.JSON(http.StatusOK, newRevealRequestResponse(request))
+}
+
+func (h *KeyGenHandler) handleSignMessage(c *gin.Context) {
+	req, ok := h.bindKeyGenRequest(c)
+	if !ok {
+		return
+	}
+	var body SignMessageRequest
+	if !bindJSON(c, &body) {
+		return
+	}
+
+	ctx := requestContext(c)
+	if !h.authorize(c, ctx, auth.ScopeSign, audit.ActionSignMessage, req.UserID, req.Network) {
+		return
+	}
+
+	signed, err := h.keyService.SignMessage(ctx, req.UserID, req.Network, []byte(body.Message))
+	if err != nil {
+		handleServiceError(c, err, req.UserID, req.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": req.UserID,
+		"network": req.Network,
+		"scheme":  signed.Scheme,
+	}).Warn("Signed message")
+
+	c.JSON(http.StatusOK, newSignMessageResponse(req.Network, signed))
+}
+
+// handleSignTypedData signs the body, EIP-712 typed data as passed to
+// eth_signTypedData_v4.
+func (h *KeyGenHandler) handleSignTypedData(c *gin.Context) {
+	req, ok := h.bindKeyGenRequest(c)
+	if !ok {
+		return
+	}
+	typedData, err := c.GetRawData()
+	if err != nil || !json.Valid(typedData) {
+		log.WithError(err).Error("Invalid typed data request")
+		invalidBody(c, err)
+		return
+	}
+
+	ctx := requestContext(c)
+	if !h.authorize(c, ctx, auth.ScopeSign, audit.ActionSignTypedData, req.UserID, req.Network) {
+		return
+	}
+
+	signed, err := h.keyService.SignTypedData(ctx, req.UserID, req.Network, typedData)
+	if err != nil {
+		handleServiceError(c, err, req.UserID, req.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": req.UserID,
+		"network": req.Network,
+		"digest":  signed.Digest,
+	}).Warn("Signed typed data")
+
+	c.JSON(http.StatusOK, newSignMessageResponse(req.Network, signed))
+}
+
+func (h *KeyGenHandler) handleVerifyMessage(c *gin.Context) {
+	var body VerifyMessageRequest
+	if !bindJSON(c, &body) {
+		return
+	}
+	network := h.keyService.ResolveNetwork(c.Param("network"), c.Query("type"))
+	if !h.authorize(c, requestContext(c), auth.ScopeReadPublic, audit.ActionVerifyMessage, 0, network) {
+		return
+	}
+
+	valid, err := h.keyService.VerifyMessage(requestContext(c), network, body.Address, []byte(body.Message), body.Signature)
+	if err != nil {
+		handleServiceError(c, err, 0, network)
+		return
+	}
+	c.JSON(http.StatusOK, VerifyMessageResponse{Valid: valid})
+}
+
+func (h *KeyGenHandler) handleGetAccountKey(c *gin.Context) {
+	network := h.keyService.ResolveNetwork(c.Param("network"), c.Query("type"))
+	if !h.authorize(c, requestContext(c), auth.ScopeReadPublic, audit.ActionExportAccountKey, 0, network) {
+		return
+	}
+
+	accountKey, err := h.keyService.GetAccountKey(requestContext(c), network)
+	if err != nil {
+		handleServiceError(c, err, 0, network)
+		return
+	}
+
+	c.JSON(http.StatusOK, AccountKeyResponse{
+		Network:           network,
+		ExtendedPublicKey: accountKey.ExtendedPublicKey,
+		DerivationPath:    accountKey.DerivationPath,
+		Fingerprint:       accountKey.MasterFingerprint,
+	})
+}
+
+func (h *KeyGenHandler) handleRegisterWatchOnlyAccount(c *gin.Context) {
+	var req WatchOnlyAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("Invalid watch-only account request")
+		invalidBody(c, err)
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.WithError(err).Error("Validation error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
+		return
+	}
+
+	if !h.authorize(c, requestContext(c), auth.ScopeManageAccounts, audit.ActionRegisterWatchOnly, 0, req.Network) {
+		return
+	}
+
+	accountKey, err := h.keyService.RegisterWatchOnlyAccount(requestContext(c), req.Network, req.BaseNetwork, req.ExtendedPublicKey, req.DerivationPath)
+	if err != nil {
+		handleServiceError(c, err, 0, req.Network)
+		return
+	}
+
+	c.JSON(http.StatusCreated, WatchOnlyAccountResponse{
+		Network:           req.Network,
+		BaseNetwork:       req.BaseNetwork,
+		ExtendedPublicKey: accountKey.ExtendedPublicKey,
+		DerivationPath:    accountKey.DerivationPath,
+	})
+}
+
+func (h *KeyGenHandler) handleFetchExportedKey(c *gin.Context) {
+	options, ok := bindExportRequest(c)
+	if !ok {
+		return
+	}
+	ctx := requestContext(c)
+	request, ok := h.lookupRevealRequest(c, ctx, audit.ActionExportPrivateKey)
+	if !ok {
+		return
+	}
+	if !h.checkRevealToken(c, ctx, audit.ActionExportPrivateKey, request.UserID, request.Network) {
+		return
+	}
+
+	exported, err := h.keyService.FetchExportedKey(ctx, request.ID, options)
+	if err != nil {
+		handleServiceError(c, err, request.UserID, request.Network)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": request.ID,
+		"user_id":    request.UserID,
+		"network":    request.Network,
+		"format":     exported.Format,
+	}).Warn("Exported private key")
+
+	c.JSON(http.StatusOK, newExportedKeyResponse(exported))
+}
+
+// bindExportRequest parses the export options of the request body. It writes
+// the error response itself.
+func bindExportRequest(c *gin.Context) (services.ExportOptions, bool) {
+	var req ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("Invalid export request")
+		invalidBody(c, err)
+		return services.ExportOptions{}, false
+	}
+	if err := validate.Struct(req); err != nil {
+		log.WithError(err).Error("Validation error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
+		return services.ExportOptions{}, false
+	}
+	return services.ExportOptions{Format: req.Format, Passphrase: req.Passphrase, Recipient: req.Recipient}, true
+}
+
+// bindJSON parses and validates the request body into req. It writes the
+// error response itself.
+func bindJSON(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		log.WithError(err).Error("Invalid request body")
+		invalidBody(c, err)
+		return false
+	}
+	if err := validate.Struct(req); err != nil {
+		log.WithError(err).Error("Validation error")
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
+		return false
+	}
+	return true
+}
+
+// checkRevealToken requires the reveal token on requests that return private
+// keys, a missing or wrong token is answered and recorded as auditAction.
+func (h *KeyGenHandler) checkRevealToken(c *gin.Context, ctx context.Context, auditAction audit.Action, userID int, network string) bool {
+	token := c.GetHeader(RevealTokenHeader)
+	if h.revealToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.revealToken)) == 1 {
+		return true
+	}
+	log.WithField("path", c.Request.URL.Path).Warn("Unauthorized private key reveal request")
+	h.keyService.RecordDenied(ctx, auditAction, userID, network, errors.ErrUnauthorized)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": errors.ErrUnauthorized.Message})
+	return false
+}
+
+// bindKeyGenRequest parses and validates the userId/network path parameters
+// and the optional address type. It writes the error response itself.
+func (h *KeyGenHandler) bindKeyGenRequest(c *gin.Context) (KeyGenRequest, bool) {
 	var req KeyGenRequest
 
 	// Validate userId manually to handle non-integer values gracefully
@@ -41,7 +452,7 @@ func (h *KeyGenHandler) handleGenerateKeyPair(c *gin.Context) {
 	if err != nil || userId <= 0 {
 		log.WithError(err).Error("Invalid userId parameter")
 		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrInvalidUserID.Message})
-		return
+		return req, false
 	}
 	req.UserID = userId
 
@@ -50,38 +461,94 @@ func (h *KeyGenHandler) handleGenerateKeyPair(c *gin.Context) {
 	if req.Network == "" {
 		log.Error("Network parameter is required")
 		c.JSON(http.StatusBadRequest, gin.H{"error": errors.ErrNetworkRequired.Message})
-		return
+		return req, false
 	}
 
 	if err := validate.Struct(req); err != nil {
 		log.WithError(err).Error("Validation error")
 		c.JSON(http.StatusBadRequest, gin.H{"error": errors.FormatValidationError(err)})
-		return
+		return req, false
+	}
+
+	// Optional address type, e.g. /keygen/1/bitcoin?type=p2wpkh is the same as /keygen/1/bitcoin-p2wpkh
+	req.AddressType = c.Query("type")
+	req.Network = h.keyService.ResolveNetwork(req.Network, req.AddressType)
+	return req, true
+}
+
+// authorize applies the policy to action, a denied request is answered and
+// recorded as auditAction.
+func (h *KeyGenHandler) authorize(c *gin.Context, ctx context.Context, action string, auditAction audit.Action, userID int, network string) bool {
+	err := h.policy.Authorize(policySubject(ctx), action, network, userID)
+	if err == nil {
+		return true
 	}
+	h.keyService.RecordDenied(ctx, auditAction, userID, network, err)
+	handleServiceError(c, err, userID, network)
+	return false
+}
 
-	keyPairAndAddress, err := h.keyService.GetKeysAndAddress(req.UserID, req.Network)
+// lookupRevealRequest returns the reveal request of the id path parameter if
+// the caller has the keys:reveal_private scope, takes part in the request
+// and the policy allows to reveal its key. The scope is checked before the
+// request is looked up, and a request the caller takes no part in is not
+// found, so that callers cannot probe request IDs. A refusal is answered and
+// recorded as auditAction.
+func (h *KeyGenHandler) lookupRevealRequest(c *gin.Context, ctx context.Context, auditAction audit.Action) (db.RevealRequest, bool) {
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		h.keyService.RecordDenied(ctx, auditAction, 0, "", err)
+		handleServiceError(c, err, 0, "")
+		return db.RevealRequest{}, false
+	}
+	request, err := h.keyService.GetRevealRequest(ctx, c.Param("id"))
 	if err != nil {
-		handleServiceError(c, err, req.UserID, req.Network)
-		return
+		handleServiceError(c, err, 0, "")
+		return db.RevealRequest{}, false
+	}
+	if !h.authorize(c, ctx, auth.ScopeRevealPrivate, auditAction, request.UserID, request.Network) {
+		return db.RevealRequest{}, false
 	}
+	return request, true
+}
 
-	log.WithFields(log.Fields{
-		"user_id": req.UserID,
-		"network": req.Network,
-	}).Info("Successfully acquired keys")
+// policySubject names the principal of ctx in policy bindings.
+func policySubject(ctx context.Context) string {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		return principal.String()
+	}
+	return policy.Anonymous
+}
 
-	c.JSON(
-		http.StatusOK,
-		KeyGenResponse{
-			Address:    keyPairAndAddress.Address,
-			PublicKey:  keyPairAndAddress.PublicKey,
-			PrivateKey: keyPairAndAddress.PrivateKey,
-		},
-	)
+// requestContext attributes the service calls of a request to its
+// authenticated principal in the audit log, or to the client IP.
+func requestContext(c *gin.Context) context.Context {
+	ctx := c.Request.Context()
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		return audit.WithActor(ctx, principal.String())
+	}
+	return audit.WithActor(ctx, "ip:"+c.ClientIP())
 }
 
 func handleServiceError(c *gin.Context, err error, userID int, network string) {
-	if apiErr, ok := err.(*errors.KeyGenError); ok {
+	if rateLimitErr, ok := err.(*errors.RateLimitError); ok {
+		log.WithFields(log.Fields{
+			"user_id": userID,
+			"network": network,
+		}).WithError(rateLimitErr).Warn("Rate limited")
+		ratelimit.Abort(c, rateLimitErr)
+	} else if policyErr, ok := err.(*errors.PolicyError); ok {
+		log.WithFields(log.Fields{
+			"user_id": userID,
+			"network": network,
+		}).WithError(policyErr).Warn("Denied by policy")
+		c.JSON(http.StatusForbidden, PolicyErrorResponse{
+			Error:   errors.ErrForbidden.Message,
+			Reason:  policyErr.Reason,
+			Action:  policyErr.Action,
+			Network: policyErr.Network,
+			UserID:  policyErr.UserID,
+		})
+	} else if apiErr, ok := err.(*errors.KeyGenError); ok {
 		log.WithFields(log.Fields{
 			"user_id": userID,
 			"network": network,
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/policy"
+	"crypto-keygen-service/internal/repositories"
+	"crypto-keygen-service/internal/services"
+	"crypto-keygen-service/internal/util/kms"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sampleMasterSeed    = "sample-master-seed"
+	sampleEncryptionKey = "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
+)
+
+// memoryDatabase keeps the keys and reveal requests the handlers under test
+// use, the other methods of db.Database are not implemented.
+type memoryDatabase struct {
+	db.Database
+	keys           map[string]db.KeyData
+	revealRequests map[string]db.RevealRequest
+}
+
+func newMemoryDatabase() *memoryDatabase {
+	return &memoryDatabase{keys: make(map[string]db.KeyData), revealRequests: make(map[string]db.RevealRequest)}
+}
+
+func keyName(userID int, network string) string {
+	return network + "/" + strconv.Itoa(userID)
+}
+
+func (m *memoryDatabase) SaveKey(ctx context.Context, keyData db.KeyData) error {
+	m.keys[keyName(keyData.UserID, keyData.Network)] = keyData
+	return nil
+}
+
+func (m *memoryDatabase) InsertKey(ctx context.Context, keyData db.KeyData) error {
+	if _, ok := m.keys[keyName(keyData.UserID, keyData.Network)]; ok {
+		return db.ErrDuplicate
+	}
+	return m.SaveKey(ctx, keyData)
+}
+
+func (m *memoryDatabase) GetKey(ctx context.Context, userID int, network string) (db.KeyData, error) {
+	keyData, ok := m.keys[keyName(userID, network)]
+	if !ok {
+		return db.KeyData{}, db.ErrNotFound
+	}
+	return keyData, nil
+}
+
+func (m *memoryDatabase) KeyExists(ctx context.Context, userID int, network string) (bool, error) {
+	_, ok := m.keys[keyName(userID, network)]
+	return ok, nil
+}
+
+func (m *memoryDatabase) SaveRevealRequest(ctx context.Context, request db.RevealRequest) error {
+	m.revealRequests[request.ID] = request
+	return nil
+}
+
+func (m *memoryDatabase) UpdateRevealRequest(ctx context.Context, request db.RevealRequest) (db.RevealRequest, error) {
+	if stored, ok := m.revealRequests[request.ID]; !ok || stored.Version != request.Version {
+		return db.RevealRequest{}, db.ErrConflict
+	}
+	request.Version++
+	m.revealRequests[request.ID] = request
+	return request, nil
+}
+
+func (m *memoryDatabase) GetRevealRequest(ctx context.Context, id string) (db.RevealRequest, error) {
+	request, ok := m.revealRequests[id]
+	if !ok {
+		return db.RevealRequest{}, db.ErrNotFound
+	}
+	return request, nil
+}
+
+func newTestService(t *testing.T) *services.KeyGenService {
+	keyManager, err := kms.NewLocalKeyManager(kms.Key{Material: sampleEncryptionKey})
+	require.NoError(t, err)
+	return services.NewKeyGenService(repositories.NewKeyGenRepository(newMemoryDatabase()), []byte(sampleMasterSeed), keyManager)
+}
+
+func newTestPolicy(t *testing.T, config policy.Config) *policy.Engine {
+	engine, err := policy.New(config)
+	require.NoError(t, err)
+	return engine
+}
+
+func principal(id string, scopes ...string) auth.Principal {
+	return auth.Principal{ID: id, Method: auth.MethodJWT, Scopes: scopes}
+}
+
+func principalContext(p auth.Principal) context.Context {
+	return auth.WithPrincipal(context.Background(), p)
+}
+
+// newTestRouter serves the routes register adds, every request is
+// authenticated as the principal its X-Test-Principal header names.
+func newTestRouter(principals map[string]auth.Principal, register func(gin.IRouter)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if p, ok := principals[c.GetHeader("X-Test-Principal")]; ok {
+			c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), p))
+		}
+	})
+	register(router)
+	return router
+}
+
+func serve(router *gin.Engine, method, path, caller string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	req.Header.Set("X-Test-Principal", caller)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func policyError(t *testing.T, w *httptest.ResponseRecorder) PolicyErrorResponse {
+	var response PolicyErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestRevealRequestsRestrictedByPolicy(t *testing.T) {
+	service := newTestService(t)
+	service.EnablePrivateKeyReveal()
+	require.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{
+		Approvers:  []string{"jwt:alice", "jwt:bob"},
+		Required:   1,
+		RequestTTL: time.Hour,
+		FetchTTL:   time.Minute,
+	}))
+	principals := map[string]auth.Principal{
+		"requester": principal("requester", auth.ScopeGenerate, auth.ScopeReadPublic, auth.ScopeRevealPrivate),
+		"alice":     principal("alice", auth.ScopeRevealPrivate),
+		"bob":       principal("bob", auth.ScopeRevealPrivate),
+	}
+	requester := principalContext(principals["requester"])
+	_, err := service.GetKeysAndAddress(requester, 1, "ethereum")
+	require.NoError(t, err)
+	request, err := service.OpenRevealRequest(requester, 1, "ethereum")
+	require.NoError(t, err)
+
+	handler := NewKeyGenHandler(service, "reveal-token")
+	handler.SetPolicy(newTestPolicy(t, policy.Config{
+		Roles: map[string][]string{"custodian": {auth.ScopeRevealPrivate}},
+		Bindings: []policy.Binding{
+			{Principals: []string{"jwt:requester", "jwt:bob"}, Roles: []string{"custodian"}},
+			{Principals: []string{"jwt:alice"}, Roles: []string{"custodian"}, Networks: []string{"bitcoin*"}},
+		},
+	}))
+	router := newTestRouter(principals, handler.RegisterRoutes)
+
+	// alice is an approver, but not allowed on the network of the request
+	w := serve(router, http.MethodGet, "/reveal-requests/"+request.ID, "alice")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, policy.ReasonNetworkNotAllowed, policyError(t, w).Reason)
+	w = serve(router, http.MethodPost, "/reveal-requests/"+request.ID+"/approve", "alice")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, auth.ScopeRevealPrivate, policyError(t, w).Action)
+
+	stored, err := service.GetRevealRequest(requester, request.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RevealPending, stored.State)
+	assert.Empty(t, stored.Approvals)
+
+	w = serve(router, http.MethodGet, "/reveal-requests/"+request.ID, "bob")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, http.MethodPost, "/reveal-requests/"+request.ID+"/approve", "bob")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"approved"`)
+}
+
+func TestRequestBodyIsLimited(t *testing.T) {
+	principals := map[string]auth.Principal{
+		"caller": principal("caller", auth.ScopeImport, auth.ScopeSign),
+	}
+	handler := NewKeyGenHandler(newTestService(t), "")
+	router := newTestRouter(principals, func(router gin.IRouter) {
+		handler.RegisterRoutes(router.Group("", LimitRequestBody()))
+	})
+	oversized := `{"private_key": "` + strings.Repeat("a", MaxRequestBodySize) + `"}`
+
+	for _, path := range []string{"/keygen/1/ethereum/import", "/sign/typed-data/1/ethereum"} {
+		// Announced by the Content-Length, before the handler runs
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(oversized))
+		req.Header.Set("X-Test-Principal", "caller")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, path)
+
+		// Without a length, while the handler reads the body
+		req = httptest.NewRequest(http.MethodPost, path, strings.NewReader(oversized))
+		req.Header.Set("X-Test-Principal", "caller")
+		req.ContentLength = -1
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, path)
+
+		// Smaller bodies are read as before
+		w = serve(router, http.MethodPost, path, "caller")
+		assert.NotEqual(t, http.StatusRequestEntityTooLarge, w.Code, path)
+	}
+}
+
+func TestRevealRequestLookupNeedsScope(t *testing.T) {
+	service := newTestService(t)
+	service.EnablePrivateKeyReveal()
+	require.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{
+		Approvers:  []string{"jwt:alice"},
+		Required:   1,
+		RequestTTL: time.Hour,
+		FetchTTL:   time.Minute,
+	}))
+	principals := map[string]auth.Principal{
+		"requester": principal("requester", auth.ScopeGenerate, auth.ScopeReadPublic, auth.ScopeRevealPrivate),
+		"outsider":  principal("outsider", auth.ScopeRevealPrivate),
+		"noscope":   principal("alice", auth.ScopeReadPublic),
+	}
+	requester := principalContext(principals["requester"])
+	_, err := service.GetKeysAndAddress(requester, 1, "ethereum")
+	require.NoError(t, err)
+	request, err := service.OpenRevealRequest(requester, 1, "ethereum")
+	require.NoError(t, err)
+	router := newTestRouter(principals, NewKeyGenHandler(service, "reveal-token").RegisterRoutes)
+
+	for _, id := range []string{request.ID, "rr_unknown"} {
+		// Without the scope, before the request is looked up
+		w := serve(router, http.MethodGet, "/reveal-requests/"+id, "noscope")
+		assert.Equal(t, http.StatusForbidden, w.Code, id)
+		w = serve(router, http.MethodPost, "/reveal-requests/"+id+"/approve", "noscope")
+		assert.Equal(t, http.StatusForbidden, w.Code, id)
+		// Requests of others are not found, like unknown ones
+		w = serve(router, http.MethodGet, "/reveal-requests/"+id, "outsider")
+		assert.Equal(t, http.StatusNotFound, w.Code, id)
+		w = serve(router, http.MethodPost, "/reveal-requests/"+id+"/approve", "outsider")
+		assert.Equal(t, http.StatusNotFound, w.Code, id)
+	}
+
+	stored, err := service.GetRevealRequest(requester, request.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RevealPending, stored.State)
+	w := serve(router, http.MethodGet, "/reveal-requests/"+request.ID, "requester")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
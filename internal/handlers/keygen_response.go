@@ -1,7 +1,167 @@
 package handlers
 
+import (
+	"time"
+
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/services"
+	. "crypto-keygen-service/internal/util/network_factory"
+)
+
 type KeyGenResponse struct {
-	Address    string `json:"address"`
-	PublicKey  string `json:"public_key"`
-	PrivateKey string `json:"private_key"`
+	Address        string `json:"address"`
+	PublicKey      string `json:"public_key"`
+	DerivationPath string `json:"derivation_path,omitempty"`
+	AddressType    string `json:"address_type,omitempty"`
+	Chain          string `json:"chain,omitempty"`
+	ChainID        uint64 `json:"chain_id,omitempty"`
+	WatchOnly      bool   `json:"watch_only,omitempty"`
+	Imported       bool   `json:"imported,omitempty"`
+}
+
+func newKeyGenResponse(keyPairAndAddress KeyPairAndAddress) KeyGenResponse {
+	return KeyGenResponse{
+		Address:        keyPairAndAddress.Address,
+		PublicKey:      keyPairAndAddress.PublicKey,
+		DerivationPath: keyPairAndAddress.DerivationPath,
+		AddressType:    keyPairAndAddress.AddressType,
+		Chain:          keyPairAndAddress.Chain,
+		ChainID:        keyPairAndAddress.ChainID,
+		WatchOnly:      keyPairAndAddress.WatchOnly,
+		Imported:       keyPairAndAddress.Imported,
+	}
+}
+
+// ExportRequest encrypts the exported key with Passphrase or, for the age
+// format, to Recipient.
+type ExportRequest struct {
+	Format     string `json:"format" validate:"required"`
+	Passphrase string `json:"passphrase"`
+	Recipient  string `json:"recipient"`
+}
+
+// ImportRequest is a private key generated elsewhere, Address has to match
+// the one derived from it.
+type ImportRequest struct {
+	PrivateKey string `json:"private_key" validate:"required"`
+	Passphrase string `json:"passphrase"`
+	Address    string `json:"address" validate:"required"`
+}
+
+type ExportedKeyResponse struct {
+	KeyGenResponse
+	Format              string `json:"format"`
+	EncryptedPrivateKey string `json:"encrypted_private_key"`
+}
+
+func newExportedKeyResponse(exported services.ExportedKey) ExportedKeyResponse {
+	return ExportedKeyResponse{
+		KeyGenResponse:      newKeyGenResponse(exported.KeyPairAndAddress),
+		Format:              exported.Format,
+		EncryptedPrivateKey: exported.EncryptedPrivateKey,
+	}
+}
+
+type SignMessageRequest struct {
+	Message string `json:"message" validate:"required"`
+}
+
+type SignMessageResponse struct {
+	Network   string `json:"network"`
+	Address   string `json:"address"`
+	Scheme    string `json:"scheme"`
+	Digest    string `json:"digest,omitempty"`
+	Signature string `json:"signature"`
+}
+
+func newSignMessageResponse(network string, signed services.SignedMessage) SignMessageResponse {
+	return SignMessageResponse{
+		Network:   network,
+		Address:   signed.Address,
+		Scheme:    signed.Scheme,
+		Digest:    signed.Digest,
+		Signature: signed.Signature,
+	}
+}
+
+type VerifyMessageRequest struct {
+	Address   string `json:"address" validate:"required"`
+	Message   string `json:"message" validate:"required"`
+	Signature string `json:"signature" validate:"required"`
+}
+
+type VerifyMessageResponse struct {
+	Valid bool `json:"valid"`
+}
+
+type AccountKeyResponse struct {
+	Network           string `json:"network"`
+	ExtendedPublicKey string `json:"extended_public_key"`
+	DerivationPath    string `json:"derivation_path"`
+	Fingerprint       string `json:"fingerprint"`
+}
+
+type WatchOnlyAccountRequest struct {
+	Network           string `json:"network" validate:"required"`
+	BaseNetwork       string `json:"base_network" validate:"required"`
+	ExtendedPublicKey string `json:"extended_public_key" validate:"required"`
+	DerivationPath    string `json:"derivation_path"`
+}
+
+type WatchOnlyAccountResponse struct {
+	Network           string `json:"network"`
+	BaseNetwork       string `json:"base_network"`
+	ExtendedPublicKey string `json:"extended_public_key"`
+	DerivationPath    string `json:"derivation_path"`
+}
+
+// PolicyErrorResponse is the 403 answer to a request the authorization
+// policy does not allow.
+type PolicyErrorResponse struct {
+	Error   string `json:"error"`
+	Reason  string `json:"reason"`
+	Action  string `json:"action"`
+	Network string `json:"network,omitempty"`
+	UserID  int    `json:"user_id,omitempty"`
+}
+
+type RevealRequestBody struct {
+	UserID      int    `json:"user_id" validate:"required,gt=0"`
+	Network     string `json:"network" validate:"required"`
+	AddressType string `json:"type"`
+}
+
+type RevealRequestResponse struct {
+	ID             string              `json:"id"`
+	UserID         int                 `json:"user_id"`
+	Network        string              `json:"network"`
+	Requester      string              `json:"requester"`
+	State          string              `json:"state"`
+	Required       int                 `json:"required"`
+	Approvals      []db.RevealApproval `json:"approvals"`
+	CreatedAt      time.Time           `json:"created_at"`
+	ExpiresAt      time.Time           `json:"expires_at"`
+	FetchExpiresAt *time.Time          `json:"fetch_expires_at,omitempty"`
+	FetchedAt      *time.Time          `json:"fetched_at,omitempty"`
+}
+
+func newRevealRequestResponse(request db.RevealRequest) RevealRequestResponse {
+	response := RevealRequestResponse{
+		ID:        request.ID,
+		UserID:    request.UserID,
+		Network:   request.Network,
+		Requester: request.Requester,
+		State:     request.State,
+		Required:  request.Required,
+		Approvals: request.Approvals,
+		CreatedAt: request.CreatedAt,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if !request.FetchExpiresAt.IsZero() {
+		response.FetchExpiresAt = &request.FetchExpiresAt
+	}
+	if !request.FetchedAt.IsZero() {
+		response.FetchedAt = &request.FetchedAt
+	}
+	return response
 }
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxRequestBodySize limits the request bodies the service reads, however
+// the request is authenticated. It is the limit of signed bodies as well.
+const MaxRequestBodySize = 1 << 20
+
+// LimitRequestBody answers 413 to requests announcing a body larger than
+// MaxRequestBodySize and makes reading past it fail, so that handlers do too.
+func LimitRequestBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > MaxRequestBodySize {
+			c.AbortWithStatusJSON(errors.ErrRequestTooLarge.Code, gin.H{"error": errors.ErrRequestTooLarge.Message})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxRequestBodySize)
+		c.Next()
+	}
+}
+
+// invalidBody answers a request whose body could not be read or parsed, err
+// is the error reading it if any.
+func invalidBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if stderrors.As(err, &tooLarge) {
+		c.JSON(errors.ErrRequestTooLarge.Code, gin.H{"error": errors.ErrRequestTooLarge.Message})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+}
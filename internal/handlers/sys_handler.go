@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/policy"
+	"crypto-keygen-service/internal/services"
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// SealTokenHeader carries the token that authorizes sealing and unsealing
+// the service.
+const SealTokenHeader = "X-Seal-Token"
+
+// SysHandler serves the operational /sys endpoints.
+type SysHandler struct {
+	keyService *services.KeyGenService
+	sealToken  string
+	policy     *policy.Engine
+}
+
+// NewSysHandler creates the handler. Seal and unseal requests must present
+// sealToken; an empty sealToken rejects all of them.
+func NewSysHandler(keyService *services.KeyGenService, sealToken string) *SysHandler {
+	return &SysHandler{keyService: keyService, sealToken: sealToken}
+}
+
+// SetPolicy restricts sealing and unsealing to the principals bound to
+// sys:manage, without a policy the scopes of the caller are the only
+// restriction.
+func (h *SysHandler) SetPolicy(engine *policy.Engine) {
+	h.policy = engine
+}
+
+func (h *SysHandler) RegisterRoutes(router gin.IRouter) {
+	router.GET("/sys/seal-status", h.handleSealStatus)
+	router.POST("/sys/seal", h.handleSeal)
+	router.POST("/sys/unseal", h.handleUnseal)
+	router.POST("/sys/unseal/reset", h.handleUnsealReset)
+}
+
+func (h *SysHandler) handleSealStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, newSealStatusResponse(h.keyService.SealStatus()))
+}
+
+// checkSealToken writes a 401 and records the denied action unless the
+// request carries the seal token.
+func (h *SysHandler) checkSealToken(c *gin.Context, action audit.Action) bool {
+	token := c.GetHeader(SealTokenHeader)
+	if h.sealToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.sealToken)) != 1 {
+		log.WithField("path", c.Request.URL.Path).Warn("Unauthorized seal request")
+		h.keyService.RecordDenied(requestContext(c), action, 0, "", errors.ErrUnauthorized)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errors.ErrUnauthorized.Message})
+		return false
+	}
+	return true
+}
+
+// authorize requires the sys:manage scope and the policy to grant it, a
+// denied request is answered and recorded as auditAction.
+func (h *SysHandler) authorize(c *gin.Context, ctx context.Context, auditAction audit.Action) bool {
+	err := auth.Authorize(ctx, auth.ScopeSys)
+	if err == nil {
+		err = h.policy.Authorize(policySubject(ctx), auth.ScopeSys, "", 0)
+	}
+	if err == nil {
+		return true
+	}
+	h.keyService.RecordDenied(ctx, auditAction, 0, "", err)
+	handleServiceError(c, err, 0, "")
+	return false
+}
+
+func (h *SysHandler) handleSeal(c *gin.Context) {
+	if !h.authorize(c, requestContext(c), audit.ActionSeal) || !h.checkSealToken(c, audit.ActionSeal) {
+		return
+	}
+
+	h.keyService.Seal(requestContext(c))
+	log.Warn("Service sealed on request")
+	c.JSON(http.StatusOK, newSealStatusResponse(h.keyService.SealStatus()))
+}
+
+func (h *SysHandler) handleUnseal(c *gin.Context) {
+	if !h.authorize(c, requestContext(c), audit.ActionUnsealShare) || !h.checkSealToken(c, audit.ActionUnsealShare) {
+		return
+	}
+	var req UnsealRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Share == "" {
+		log.WithError(err).Error("Invalid unseal request")
+		invalidBody(c, err)
+		return
+	}
+
+	status, err := h.keyService.SubmitUnsealShare(requestContext(c), req.Share)
+	if err != nil {
+		handleServiceError(c, err, 0, "")
+		return
+	}
+	c.JSON(http.StatusOK, newSealStatusResponse(status))
+}
+
+func (h *SysHandler) handleUnsealReset(c *gin.Context) {
+	if !h.authorize(c, requestContext(c), audit.ActionUnsealReset) || !h.checkSealToken(c, audit.ActionUnsealReset) {
+		return
+	}
+
+	status, err := h.keyService.ResetUnseal(requestContext(c))
+	if err != nil {
+		handleServiceError(c, err, 0, "")
+		return
+	}
+	c.JSON(http.StatusOK, newSealStatusResponse(status))
+}
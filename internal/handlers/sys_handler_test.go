@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/policy"
+	"crypto-keygen-service/internal/services"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sealToken = "seal-token"
+
+func TestSealRestrictedByPolicy(t *testing.T) {
+	service := newTestService(t)
+	handler := NewSysHandler(service, sealToken)
+	handler.SetPolicy(newTestPolicy(t, policy.Config{
+		Roles:    map[string][]string{"operator": {auth.ScopeSys}},
+		Bindings: []policy.Binding{{Principals: []string{"jwt:ops-*"}, Roles: []string{"operator"}}},
+	}))
+	router := newTestRouter(map[string]auth.Principal{
+		"alice":   principal("alice", auth.ScopeSys),
+		"ops":     principal("ops-eu", auth.ScopeSys),
+		"noscope": principal("ops-us", auth.ScopeReadPublic),
+	}, handler.RegisterRoutes)
+
+	// Not bound to sys:manage by the policy
+	w := serve(router, http.MethodPost, "/sys/seal", "alice", SealTokenHeader, sealToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, policy.ReasonActionNotGranted, policyError(t, w).Reason)
+	// Bound, but without the scope
+	w = serve(router, http.MethodPost, "/sys/seal", "noscope", SealTokenHeader, sealToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// Allowed, but without the seal token
+	w = serve(router, http.MethodPost, "/sys/seal", "ops")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, services.StateUnsealed, service.State())
+
+	w = serve(router, http.MethodPost, "/sys/seal", "ops", SealTokenHeader, sealToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, services.StateSealed, service.State())
+
+	w = serve(router, http.MethodPost, "/sys/unseal/reset", "alice", SealTokenHeader, sealToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, http.MethodPost, "/sys/unseal", "alice", SealTokenHeader, sealToken)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import "crypto-keygen-service/internal/services"
+
+type UnsealRequest struct {
+	Share string `json:"share"`
+}
+
+type SealStatusResponse struct {
+	State     services.SealState `json:"state"`
+	Sealed    bool               `json:"sealed"`
+	Progress  int                `json:"progress"`
+	Threshold int                `json:"threshold,omitempty"`
+}
+
+func newSealStatusResponse(status services.SealStatus) SealStatusResponse {
+	return SealStatusResponse{
+		State:     status.State,
+		Sealed:    status.Sealed(),
+		Progress:  status.Progress,
+		Threshold: status.Threshold,
+	}
+}
@@ -0,0 +1,212 @@
+// Package policy decides which networks and user IDs a principal may act
+// on. Roles grant actions, the auth scopes, and bindings assign roles to
+// principals, optionally restricted to networks and user ID ranges:
+//
+//	roles:
+//	  issuer: [keys:generate, keys:read_public]
+//	  custodian: [keys:reveal_private]
+//	bindings:
+//	  - principals: ["api-key:ak_0123456789abcdef"]
+//	    roles: [issuer]
+//	    networks: ["bitcoin*", ethereum]
+//	    user_ids: ["1-100000"]
+//	  - principals: ["jwt:*"]
+//	    roles: [custodian]
+//	    networks: [bitcoin-testnet]
+//
+// Anything not granted by a binding is denied.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Anonymous is the principal of unauthenticated requests, when
+// authentication is disabled.
+const Anonymous = "anonymous"
+
+// Reasons of a PolicyError, from the least to the most specific.
+const (
+	ReasonActionNotGranted  = "action_not_granted"
+	ReasonNetworkNotAllowed = "network_not_allowed"
+	ReasonUserIDNotAllowed  = "user_id_not_allowed"
+)
+
+// Config is the policy file.
+type Config struct {
+	// Roles maps role names to the actions they grant, see auth.Scopes
+	Roles    map[string][]string `yaml:"roles"`
+	Bindings []Binding           `yaml:"bindings"`
+}
+
+// Binding grants its roles to the principals matching one of Principals.
+// Principals, written like auth.Principal.String(), and Networks are
+// path.Match patterns. UserIDs are single IDs ("42") or inclusive ranges
+// ("1-1000", "5000-"); a binding with UserIDs does not grant actions that are
+// not about a single user, like exporting the account key. Empty Networks
+// or UserIDs do not restrict the binding.
+type Binding struct {
+	Principals []string `yaml:"principals"`
+	Roles      []string `yaml:"roles"`
+	Networks   []string `yaml:"networks"`
+	UserIDs    []string `yaml:"user_ids"`
+}
+
+// Engine evaluates a validated Config.
+type Engine struct {
+	rules []rule
+}
+
+type rule struct {
+	principals []string
+	actions    []string
+	networks   []string
+	userIDs    []userIDRange
+}
+
+type userIDRange struct {
+	min, max int
+}
+
+// Load reads a YAML policy file.
+func Load(filename string) (*Engine, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid policy file: %w", err)
+	}
+	return New(config)
+}
+
+// New validates config, unknown actions or roles and malformed patterns or
+// ranges are rejected rather than silently granting nothing.
+func New(config Config) (*Engine, error) {
+	for role, actions := range config.Roles {
+		for _, action := range actions {
+			if !slices.Contains(auth.Scopes, action) {
+				return nil, fmt.Errorf("role %q: unknown action %q", role, action)
+			}
+		}
+	}
+
+	engine := &Engine{}
+	for i, binding := range config.Bindings {
+		if len(binding.Principals) == 0 || len(binding.Roles) == 0 {
+			return nil, fmt.Errorf("binding %d: principals and roles are required", i)
+		}
+		r := rule{principals: binding.Principals, networks: binding.Networks}
+		for _, pattern := range slices.Concat(binding.Principals, binding.Networks) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("binding %d: invalid pattern %q", i, pattern)
+			}
+		}
+		for _, role := range binding.Roles {
+			actions, ok := config.Roles[role]
+			if !ok {
+				return nil, fmt.Errorf("binding %d: unknown role %q", i, role)
+			}
+			r.actions = append(r.actions, actions...)
+		}
+		for _, s := range binding.UserIDs {
+			userIDs, err := parseUserIDRange(s)
+			if err != nil {
+				return nil, fmt.Errorf("binding %d: %w", i, err)
+			}
+			r.userIDs = append(r.userIDs, userIDs)
+		}
+		engine.rules = append(engine.rules, r)
+	}
+	return engine, nil
+}
+
+func parseUserIDRange(s string) (userIDRange, error) {
+	low, high, isRange := strings.Cut(s, "-")
+	r := userIDRange{max: math.MaxInt}
+	var err error
+	if r.min, err = strconv.Atoi(strings.TrimSpace(low)); err != nil || r.min <= 0 {
+		return r, fmt.Errorf("invalid user ID range %q", s)
+	}
+	switch {
+	case !isRange:
+		r.max = r.min
+	case strings.TrimSpace(high) != "":
+		if r.max, err = strconv.Atoi(strings.TrimSpace(high)); err != nil || r.max < r.min {
+			return r, fmt.Errorf("invalid user ID range %q", s)
+		}
+	}
+	return r, nil
+}
+
+// Authorize returns a *errors.PolicyError unless a binding of principal
+// grants action on network for userID. A userID of 0 stands for actions on
+// the whole network. A nil Engine allows everything.
+func (e *Engine) Authorize(principal, action, network string, userID int) error {
+	if e == nil {
+		return nil
+	}
+
+	reason := ReasonActionNotGranted
+	for _, r := range e.rules {
+		if !matchAny(r.principals, principal) || !slices.Contains(r.actions, action) {
+			continue
+		}
+		if !r.allowsNetwork(network) {
+			reason = moreSpecific(reason, ReasonNetworkNotAllowed)
+			continue
+		}
+		if !r.allowsUserID(userID) {
+			reason = moreSpecific(reason, ReasonUserIDNotAllowed)
+			continue
+		}
+		return nil
+	}
+	return &errors.PolicyError{Action: action, Network: network, UserID: userID, Reason: reason}
+}
+
+func (r rule) allowsNetwork(network string) bool {
+	return len(r.networks) == 0 || matchAny(r.networks, network)
+}
+
+func (r rule) allowsUserID(userID int) bool {
+	if len(r.userIDs) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(r.userIDs, func(ids userIDRange) bool {
+		return userID >= ids.min && userID <= ids.max
+	})
+}
+
+func matchAny(patterns []string, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	})
+}
+
+var reasons = []string{ReasonActionNotGranted, ReasonNetworkNotAllowed, ReasonUserIDNotAllowed}
+
+// moreSpecific reports the reason closest to being allowed, so a denial
+// names the restriction the caller ran into.
+func moreSpecific(a, b string) string {
+	if slices.Index(reasons, b) > slices.Index(reasons, a) {
+		return b
+	}
+	return a
+}
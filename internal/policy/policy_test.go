@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+roles:
+  issuer: [keys:generate, keys:read_public]
+  reader: [keys:read_public]
+  custodian: [keys:reveal_private]
+  operator: [accounts:manage]
+bindings:
+  - principals: ["api-key:ak_billing"]
+    roles: [issuer]
+    networks: ["bitcoin*", ethereum]
+    user_ids: [42, "1000-1999", "5000-"]
+  - principals: ["jwt:*"]
+    roles: [reader]
+  - principals: ["jwt:custodian"]
+    roles: [custodian]
+    networks: [bitcoin-testnet]
+  - principals: ["jwt:ops-*"]
+    roles: [operator]
+`
+
+func loadTestPolicy(t *testing.T, policy string) (*Engine, error) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(policy), 0o600))
+	return Load(filename)
+}
+
+func TestAuthorize(t *testing.T) {
+	engine, err := loadTestPolicy(t, testPolicy)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		principal string
+		action    string
+		network   string
+		userID    int
+		reason    string
+	}{
+		{"granted", "api-key:ak_billing", "keys:generate", "bitcoin", 42, ""},
+		{"network pattern", "api-key:ak_billing", "keys:generate", "bitcoin-p2wpkh", 1500, ""},
+		{"open range", "api-key:ak_billing", "keys:read_public", "ethereum", 1 << 30, ""},
+		{"action of another role", "api-key:ak_billing", "keys:reveal_private", "bitcoin", 42, ReasonActionNotGranted},
+		{"other network", "api-key:ak_billing", "keys:generate", "litecoin", 42, ReasonNetworkNotAllowed},
+		{"outside the ranges", "api-key:ak_billing", "keys:generate", "bitcoin", 2000, ReasonUserIDNotAllowed},
+		{"whole network with user ranges", "api-key:ak_billing", "keys:read_public", "bitcoin", 0, ReasonUserIDNotAllowed},
+		{"unknown principal", "api-key:ak_other", "keys:read_public", "bitcoin", 42, ReasonActionNotGranted},
+		{"principal pattern", "jwt:alice", "keys:read_public", "litecoin", 0, ""},
+		{"not granted to the pattern", "jwt:alice", "keys:generate", "bitcoin", 42, ReasonActionNotGranted},
+		{"bindings add up", "jwt:custodian", "keys:reveal_private", "bitcoin-testnet", 7, ""},
+		{"most specific reason", "jwt:custodian", "keys:reveal_private", "bitcoin", 7, ReasonNetworkNotAllowed},
+		{"operator", "jwt:ops-eu", "accounts:manage", "acme", 0, ""},
+		{"anonymous", Anonymous, "keys:read_public", "bitcoin", 42, ReasonActionNotGranted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := engine.Authorize(tt.principal, tt.action, tt.network, tt.userID)
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, &errors.PolicyError{Action: tt.action, Network: tt.network, UserID: tt.userID, Reason: tt.reason}, err)
+		})
+	}
+
+	var none *Engine
+	assert.NoError(t, none.Authorize(Anonymous, "keys:reveal_private", "bitcoin", 1))
+}
+
+func TestLoadRejectsInvalidPolicies(t *testing.T) {
+	tests := map[string]string{
+		"unknown action":  "roles: {admin: [keys:everything]}",
+		"unknown role":    "roles: {reader: [keys:read_public]}\nbindings: [{principals: [jwt:*], roles: [admin]}]",
+		"no principals":   "roles: {reader: [keys:read_public]}\nbindings: [{roles: [reader]}]",
+		"invalid pattern": "roles: {reader: [keys:read_public]}\nbindings: [{principals: [\"jwt:[\"], roles: [reader]}]",
+		"invalid range":   "roles: {reader: [keys:read_public]}\nbindings: [{principals: [jwt:*], roles: [reader], user_ids: [\"10-1\"]}]",
+		"zero user ID":    "roles: {reader: [keys:read_public]}\nbindings: [{principals: [jwt:*], roles: [reader], user_ids: [\"0-10\"]}]",
+		"unknown field":   "roles: {reader: [keys:read_public]}\nbindings: [{principal: [jwt:*], roles: [reader]}]",
+		"empty":           "",
+	}
+	for name, policy := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadTestPolicy(t, policy)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package ratelimit limits requests with token buckets: per client IP ahead
+// of authentication, per client, per user ID and a global quota of key
+// generations. Bucket state lives in a Store,
+// in memory for a single replica or in Mongo when replicas share limits.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Limits as reported in errors.RateLimitError.
+const (
+	LimitClient     = "client"
+	LimitUser       = "user"
+	LimitGeneration = "generation"
+	LimitIP         = "ip"
+	LimitSys        = "sys"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst.
+// The zero Limit does not limit anything.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// ParseLimit parses a rate like "10/s", "600/m" or "1000/h" and the burst,
+// which defaults to the count of the rate when empty. An empty rate is the
+// zero Limit.
+func ParseLimit(rate, burst string) (Limit, error) {
+	if rate == "" {
+		return Limit{}, nil
+	}
+	count, unit, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if !ok || err != nil || n <= 0 || math.IsInf(n, 0) {
+		return Limit{}, fmt.Errorf("invalid rate %q, expected e.g. 10/s", rate)
+	}
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid rate %q, the unit is s, m or h", rate)
+	}
+
+	limit := Limit{Rate: n / per.Seconds(), Burst: int(math.Ceil(n))}
+	if burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return Limit{}, fmt.Errorf("invalid burst %q", burst)
+		}
+	}
+	return limit, nil
+}
+
+// Store keeps the buckets by key.
+type Store interface {
+	// Take removes a token from the bucket of key. If it is empty, it
+	// returns false and how long until a token is available.
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// Limiter applies the configured limits, disabled ones are skipped.
+type Limiter struct {
+	store      Store
+	client     Limit
+	user       Limit
+	generation Limit
+}
+
+func NewLimiter(store Store, client, user, generation Limit) *Limiter {
+	return &Limiter{store: store, client: client, user: user, generation: generation}
+}
+
+// Middleware limits the requests of each client, the authenticated principal
+// or the client IP, and the requests for each :userId. It must run after the
+// authentication middleware.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "ip:" + c.ClientIP()
+		if principal, ok := auth.PrincipalFrom(c.Request.Context()); ok {
+			client = principal.String()
+		}
+		if err := l.take(c.Request.Context(), LimitClient, "client:"+client, l.client); err != nil {
+			Abort(c, err)
+			return
+		}
+		// Invalid user IDs are refused by the handler
+		if userID, err := strconv.Atoi(c.Param("userId")); err == nil {
+			if err := l.take(c.Request.Context(), LimitUser, "user:"+strconv.Itoa(userID), l.user); err != nil {
+				Abort(c, err)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// IPMiddleware limits the requests of each client IP to limit, reported as
+// name. Unlike Middleware it runs before the authentication middleware, so
+// that requests failing authentication are limited as well.
+func IPMiddleware(store Store, name string, limit Limit) gin.HandlerFunc {
+	limiter := &Limiter{store: store}
+	return func(c *gin.Context) {
+		if err := limiter.take(c.Request.Context(), name, name+":"+c.ClientIP(), limit); err != nil {
+			Abort(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AllowGeneration takes a token of the global generation quota, the service
+// calls it before generating keys.
+func (l *Limiter) AllowGeneration(ctx context.Context) error {
+	if err := l.take(ctx, LimitGeneration, "generation", l.generation); err != nil {
+		return err
+	}
+	return nil
+}
+
+// take fails open when the store is unavailable, the limits protect against
+// abuse and must not take the service down with them.
+func (l *Limiter) take(ctx context.Context, name, key string, limit Limit) *errors.RateLimitError {
+	if !limit.Enabled() {
+		return nil
+	}
+	ok, retryAfter, err := l.store.Take(ctx, key, limit)
+	if err != nil {
+		log.WithField("key", key).WithError(err).Error("Rate limit store failed, allowing the request")
+		return nil
+	}
+	if !ok {
+		log.WithFields(log.Fields{"key": key, "retry_after": retryAfter}).Warn("Rate limit exceeded")
+		return &errors.RateLimitError{Limit: name, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Abort answers a request refused with err with 429 and Retry-After in
+// whole seconds.
+func Abort(c *gin.Context, err *errors.RateLimitError) {
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       errors.ErrRateLimited.Message,
+		"limit":       err.Limit,
+		"retry_after": seconds,
+	})
+}
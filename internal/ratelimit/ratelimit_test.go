@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/s", "")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Rate: 10, Burst: 10}, limit)
+
+	limit, err = ParseLimit("120/m", "5")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Rate: 2, Burst: 5}, limit)
+
+	limit, err = ParseLimit("", "")
+	require.NoError(t, err)
+	assert.False(t, limit.Enabled())
+
+	for _, rate := range []string{"10", "10/d", "0/s", "-1/s", "x/s"} {
+		_, err := ParseLimit(rate, "")
+		assert.Error(t, err, rate)
+	}
+	_, err = ParseLimit("10/s", "0")
+	assert.Error(t, err)
+}
+
+// fakeClock is a MemoryStore clock moved by the test.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestStore()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	// The burst is available at once
+	for i := 0; i < 3; i++ {
+		ok, _, err := store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, retryAfter, err := store.Take(ctx, "k", limit)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Other keys have their own bucket
+	ok, _, _ = store.Take(ctx, "other", limit)
+	assert.True(t, ok)
+
+	clock.now = clock.now.Add(250 * time.Millisecond)
+	ok, retryAfter, _ = store.Take(ctx, "k", limit)
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, retryAfter)
+	clock.now = clock.now.Add(250 * time.Millisecond)
+	ok, _, _ = store.Take(ctx, "k", limit)
+	assert.True(t, ok)
+
+	// Refills up to the burst only
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _, _ = store.Take(ctx, "k", limit)
+		assert.True(t, ok)
+	}
+	ok, _, _ = store.Take(ctx, "k", limit)
+	assert.False(t, ok)
+
+	// Full buckets are dropped
+	clock.now = clock.now.Add(sweepInterval)
+	_, _, _ = store.Take(ctx, "k", limit)
+	assert.Len(t, store.buckets, 1)
+}
+
+func newTestRouter(limiter *Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// Stands in for the authentication middleware
+	router.Use(func(c *gin.Context) {
+		if client := c.GetHeader("X-Client"); client != "" {
+			ctx := auth.WithPrincipal(c.Request.Context(), auth.Principal{ID: client, Method: auth.MethodAPIKey})
+			c.Request = c.Request.WithContext(ctx)
+		}
+	}, limiter.Middleware())
+	router.GET("/keygen/:userId/:network", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/xpub/:network", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func request(router *gin.Engine, client, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if client != "" {
+		req.Header.Set("X-Client", client)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	store, clock := newTestStore()
+	router := newTestRouter(NewLimiter(store, Limit{Rate: 1, Burst: 3}, Limit{Rate: 0.1, Burst: 2}, Limit{}))
+
+	// Per user ID, whoever asks and however the ID is written
+	assert.Equal(t, http.StatusOK, request(router, "a", "/keygen/1/bitcoin").Code)
+	assert.Equal(t, http.StatusOK, request(router, "b", "/keygen/01/ethereum").Code)
+	w := request(router, "c", "/keygen/1/bitcoin")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Rate limit exceeded", "limit": "user", "retry_after": 10}`, w.Body.String())
+
+	// Per client, the rejected request above used a token of client a
+	assert.Equal(t, http.StatusOK, request(router, "a", "/keygen/2/bitcoin").Code)
+	assert.Equal(t, http.StatusOK, request(router, "a", "/xpub/bitcoin").Code)
+	w = request(router, "a", "/xpub/bitcoin")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, request(router, "b", "/xpub/bitcoin").Code)
+
+	// Unauthenticated requests are limited by IP
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, request(router, "", "/xpub/bitcoin").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request(router, "", "/xpub/bitcoin").Code)
+
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, request(router, "a", "/xpub/bitcoin").Code)
+}
+
+func TestIPMiddleware(t *testing.T) {
+	store, clock := newTestStore()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// Stands in for the authentication middleware, which the limit runs before
+	router.Use(IPMiddleware(store, LimitSys, Limit{Rate: 1, Burst: 2}), func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+	router.POST("/sys/unseal", func(c *gin.Context) {})
+	unseal := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sys/unseal", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, unseal("192.0.2.1").Code)
+	assert.Equal(t, http.StatusUnauthorized, unseal("192.0.2.1").Code)
+	w := unseal("192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.JSONEq(t, `{"error": "Rate limit exceeded", "limit": "sys", "retry_after": 1}`, w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, unseal("192.0.2.2").Code)
+
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, unseal("192.0.2.1").Code)
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	return false, 0, context.DeadlineExceeded
+}
+
+func TestAllowGeneration(t *testing.T) {
+	store, clock := newTestStore()
+	limiter := NewLimiter(store, Limit{}, Limit{}, Limit{Rate: 1.0 / 60, Burst: 1})
+	assert.NoError(t, limiter.AllowGeneration(context.Background()))
+	assert.Equal(t, &errors.RateLimitError{Limit: LimitGeneration, RetryAfter: time.Minute}, limiter.AllowGeneration(context.Background()))
+	clock.now = clock.now.Add(time.Minute)
+	assert.NoError(t, limiter.AllowGeneration(context.Background()))
+
+	// Disabled limits are not looked up, and a failing store lets requests through
+	limiter = NewLimiter(failingStore{}, Limit{}, Limit{}, Limit{Rate: 1, Burst: 1})
+	assert.NoError(t, limiter.AllowGeneration(context.Background()))
+	assert.Equal(t, http.StatusOK, request(newTestRouter(limiter), "a", "/keygen/1/bitcoin").Code)
+}
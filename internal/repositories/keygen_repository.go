@@ -17,6 +17,10 @@ func (r *KeyGenRepository) SaveKey(ctx context.Context, keyData db.KeyData) erro
 	return r.database.SaveKey(ctx, keyData)
 }
 
+func (r *KeyGenRepository) InsertKey(ctx context.Context, keyData db.KeyData) error {
+	return r.database.InsertKey(ctx, keyData)
+}
+
 func (r *KeyGenRepository) GetKey(ctx context.Context, userID int, network string) (db.KeyData, error) {
 	return r.database.GetKey(ctx, userID, network)
 }
@@ -25,6 +29,50 @@ func (r *KeyGenRepository) KeyExists(ctx context.Context, userID int, network st
 	return r.database.KeyExists(ctx, userID, network)
 }
 
+func (r *KeyGenRepository) CountKeys(ctx context.Context) (int64, error) {
+	return r.database.CountKeys(ctx)
+}
+
+func (r *KeyGenRepository) ForEachKey(ctx context.Context, fn func(db.KeyData) error) error {
+	return r.database.ForEachKey(ctx, fn)
+}
+
+func (r *KeyGenRepository) SaveWatchOnlyAccount(ctx context.Context, account db.WatchOnlyAccount) error {
+	return r.database.SaveWatchOnlyAccount(ctx, account)
+}
+
+func (r *KeyGenRepository) GetWatchOnlyAccounts(ctx context.Context) ([]db.WatchOnlyAccount, error) {
+	return r.database.GetWatchOnlyAccounts(ctx)
+}
+
+func (r *KeyGenRepository) SaveAPIClient(ctx context.Context, client db.APIClient) error {
+	return r.database.SaveAPIClient(ctx, client)
+}
+
+func (r *KeyGenRepository) UpdateAPIClient(ctx context.Context, client db.APIClient) error {
+	return r.database.UpdateAPIClient(ctx, client)
+}
+
+func (r *KeyGenRepository) GetAPIClient(ctx context.Context, keyID string) (db.APIClient, error) {
+	return r.database.GetAPIClient(ctx, keyID)
+}
+
+func (r *KeyGenRepository) GetAPIClients(ctx context.Context) ([]db.APIClient, error) {
+	return r.database.GetAPIClients(ctx)
+}
+
+func (r *KeyGenRepository) SaveRevealRequest(ctx context.Context, request db.RevealRequest) error {
+	return r.database.SaveRevealRequest(ctx, request)
+}
+
+func (r *KeyGenRepository) UpdateRevealRequest(ctx context.Context, request db.RevealRequest) (db.RevealRequest, error) {
+	return r.database.UpdateRevealRequest(ctx, request)
+}
+
+func (r *KeyGenRepository) GetRevealRequest(ctx context.Context, id string) (db.RevealRequest, error) {
+	return r.database.GetRevealRequest(ctx, id)
+}
+
 func (r *KeyGenRepository) CreateIndexes(ctx context.Context) error {
 	return r.database.CreateIndexes(ctx)
 }
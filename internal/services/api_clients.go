@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/encryption"
+	"crypto-keygen-service/internal/util/errors"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateAPIClient registers a client that signs its requests with the
+// returned secret and is granted scopes, see auth.Scopes. The secret is
+// stored encrypted and cannot be retrieved again.
+func (s *KeyGenService) CreateAPIClient(ctx context.Context, name string, scopes []string) (client db.APIClient, secret string, err error) {
+	defer func() {
+		_ = s.record(ctx, apiClientEvent(ctx, audit.ActionCreateAPIClient, client.KeyID, err))
+	}()
+
+	for _, scope := range scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			return db.APIClient{}, "", errors.ErrInvalidScope
+		}
+	}
+
+	release, err := s.acquireKeyManager()
+	if err != nil {
+		return db.APIClient{}, "", err
+	}
+	defer release()
+
+	keyID, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return db.APIClient{}, "", err
+	}
+	secret, err = randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return db.APIClient{}, "", err
+	}
+
+	client = db.APIClient{
+		KeyID:     "ak_" + keyID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	envelope, err := encryption.EncryptEnvelope(ctx, s.currentKeyManager(), secret, apiClientAssociatedData(client.KeyID))
+	if err != nil {
+		return db.APIClient{}, "", err
+	}
+	client.EncryptedSecret = envelope.Ciphertext
+	client.WrappedDataKey = envelope.WrappedDataKey
+	client.KEKID = envelope.KEKID
+
+	if err := s.repository.SaveAPIClient(ctx, client); err != nil {
+		log.WithError(err).Error("Failed to save API client")
+		return db.APIClient{}, "", err
+	}
+	log.WithFields(log.Fields{
+		"key_id": client.KeyID,
+		"name":   name,
+	}).Info("Created API client")
+	return client, secret, nil
+}
+
+// RevokeAPIClient disables a client, its requests are rejected from then on.
+func (s *KeyGenService) RevokeAPIClient(ctx context.Context, keyID string) (err error) {
+	defer func() {
+		_ = s.record(ctx, apiClientEvent(ctx, audit.ActionRevokeAPIClient, keyID, err))
+	}()
+
+	client, err := s.repository.GetAPIClient(ctx, keyID)
+	if stderrors.Is(err, db.ErrNotFound) {
+		return errors.ErrAPIClientNotFound
+	}
+	if err != nil {
+		return err
+	}
+	client.Disabled = true
+	if err := s.repository.UpdateAPIClient(ctx, client); err != nil {
+		log.WithError(err).Error("Failed to revoke API client")
+		return err
+	}
+	log.WithField("key_id", keyID).Warn("Revoked API client")
+	return nil
+}
+
+// APIClientCredentials returns the signing secret and the scopes of an
+// enabled client. Unknown and revoked clients get ErrUnauthorized.
+func (s *KeyGenService) APIClientCredentials(ctx context.Context, keyID string) ([]byte, []string, error) {
+	release, err := s.acquireKeyManager()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	client, err := s.repository.GetAPIClient(ctx, keyID)
+	if stderrors.Is(err, db.ErrNotFound) {
+		return nil, nil, errors.ErrUnauthorized
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if client.Disabled {
+		return nil, nil, errors.ErrUnauthorized
+	}
+
+	secret, err := encryption.DecryptEnvelope(ctx, s.currentKeyManager(), apiClientEnvelope(client), apiClientAssociatedData(client.KeyID))
+	if err != nil {
+		log.WithField("key_id", keyID).WithError(err).Error("Failed to decrypt API client secret")
+		return nil, nil, errors.ErrRecordIntegrity
+	}
+	return []byte(secret), client.Scopes, nil
+}
+
+// reencryptAPIClient returns client with its data key wrapped under the
+// current KEK, or nil when it already is.
+func (s *KeyGenService) reencryptAPIClient(ctx context.Context, client db.APIClient) (*db.APIClient, error) {
+	if client.KEKID == s.currentKeyManager().KeyID() {
+		return nil, nil
+	}
+	envelope, err := encryption.RewrapEnvelope(ctx, s.currentKeyManager(), apiClientEnvelope(client), apiClientAssociatedData(client.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	client.WrappedDataKey = envelope.WrappedDataKey
+	client.KEKID = envelope.KEKID
+	return &client, nil
+}
+
+// apiClientAssociatedData binds an encrypted secret to its key ID.
+func apiClientAssociatedData(keyID string) []byte {
+	data, _ := json.Marshal(struct {
+		APIClient string `json:"api_client"`
+	}{keyID})
+	return data
+}
+
+func apiClientEnvelope(client db.APIClient) encryption.Envelope {
+	return encryption.Envelope{
+		Ciphertext:     client.EncryptedSecret,
+		WrappedDataKey: client.WrappedDataKey,
+		KEKID:          client.KEKID,
+	}
+}
+
+func apiClientEvent(ctx context.Context, action audit.Action, keyID string, err error) audit.Event {
+	event := audit.Event{
+		Actor:   audit.ActorFrom(ctx),
+		Action:  action,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  keyID,
+	}
+	if err != nil {
+		event.Outcome, event.Detail = auditOutcome(err)
+	}
+	return event
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetAuditLogger records every key and admin operation to auditLog, the
+// actor is taken from the context, see audit.WithActor.
+func (s *KeyGenService) SetAuditLogger(auditLog *audit.Logger) {
+	s.auditLog = auditLog
+}
+
+// RecordDenied records an action refused with reason before it reached the
+// service, e.g. for a missing token or by the authorization policy.
+func (s *KeyGenService) RecordDenied(ctx context.Context, action audit.Action, userID int, network string, reason error) {
+	_ = s.recordAudit(ctx, action, userID, network, reason)
+}
+
+// recordAudit records action with the outcome of err.
+func (s *KeyGenService) recordAudit(ctx context.Context, action audit.Action, userID int, network string, err error) error {
+	event := audit.Event{
+		Actor:   audit.ActorFrom(ctx),
+		Action:  action,
+		UserID:  userID,
+		Network: network,
+		Outcome: audit.OutcomeSuccess,
+	}
+	if err != nil {
+		event.Outcome, event.Detail = auditOutcome(err)
+	}
+	return s.record(ctx, event)
+}
+
+func (s *KeyGenService) record(ctx context.Context, event audit.Event) error {
+	// The entry is written even if the request was cancelled meanwhile
+	if _, err := s.auditLog.Record(context.WithoutCancel(ctx), event); err != nil {
+		log.WithFields(log.Fields{
+			"action":  event.Action,
+			"user_id": event.UserID,
+			"network": event.Network,
+		}).WithError(err).Error("Failed to write audit entry")
+		return err
+	}
+	return nil
+}
+
+func auditOutcome(err error) (audit.Outcome, string) {
+	switch err := err.(type) {
+	case *errors.PolicyError, *errors.RateLimitError:
+		return audit.OutcomeDenied, err.Error()
+	}
+	apiErr, ok := err.(*errors.KeyGenError)
+	if !ok {
+		return audit.OutcomeFailure, err.Error()
+	}
+	if apiErr.Code == 401 || apiErr.Code == 403 {
+		return audit.OutcomeDenied, apiErr.Message
+	}
+	return audit.OutcomeFailure, apiErr.Message
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/encryption"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxConcurrentKDF bounds the passphrase key derivations running at once.
+// The scrypt parameters of keystores and age take 256 MiB each.
+const maxConcurrentKDF = 2
+
+// kdfLimit names the limit of the *errors.RateLimitError of a refused
+// derivation.
+const kdfLimit = "passphrase_kdf"
+
+// ExportOptions selects how an exported private key is encrypted.
+type ExportOptions struct {
+	// Format is encryption.FormatAge, for any network, or the encoding of
+	// the network, e.g. bip38 for Bitcoin, see PrivateKeyEncrypter.
+	Format     string
+	Passphrase string
+	// Recipient is an age X25519 public key, age exports to it instead of
+	// to Passphrase.
+	Recipient string
+}
+
+// ExportedKey is a public key record with its private key encrypted as
+// Format.
+type ExportedKey struct {
+	KeyPairAndAddress
+	Format              string
+	EncryptedPrivateKey string
+}
+
+// ExportPrivateKey returns the stored record with its private key encrypted
+// as options select, so that it never leaves the service in plaintext. It
+// never generates a new key and fails unless reveal is enabled and the
+// caller has the keys:reveal_private scope. With a reveal quorum keys are
+// only exported through approved reveal requests, see FetchExportedKey. No
+// key is returned unless the export was written to the audit log.
+func (s *KeyGenService) ExportPrivateKey(ctx context.Context, userID int, network string, options ExportOptions) (exported ExportedKey, err error) {
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"network": network,
+		"format":  options.Format,
+	}).Warn("Request to export private key")
+
+	defer func() {
+		if auditErr := s.recordAudit(ctx, audit.ActionExportPrivateKey, userID, network, err); auditErr != nil && err == nil {
+			exported, err = ExportedKey{}, errors.ErrInternalServerError
+		}
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		return ExportedKey{}, err
+	}
+	if !s.revealEnabled {
+		return ExportedKey{}, errors.ErrRevealDisabled
+	}
+	if s.revealQuorum != nil {
+		return ExportedKey{}, errors.ErrRevealApprovalRequired
+	}
+	releaseSlot, err := s.acquireKDFSlot(options)
+	if err != nil {
+		return ExportedKey{}, err
+	}
+	defer releaseSlot()
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return ExportedKey{}, err
+	}
+	defer release()
+
+	if err := s.checkExport(network, options); err != nil {
+		return ExportedKey{}, err
+	}
+	keyPairAndAddress, err := s.decryptStoredKey(ctx, userID, network)
+	if err != nil {
+		return ExportedKey{}, err
+	}
+	return s.encryptExport(network, keyPairAndAddress, options)
+}
+
+// acquireKDFSlot takes a KDF slot for an export under a passphrase, exports
+// to an age recipient need none.
+func (s *KeyGenService) acquireKDFSlot(options ExportOptions) (func(), error) {
+	if options.Format == encryption.FormatAge && options.Recipient != "" {
+		return func() {}, nil
+	}
+	return s.takeKDFSlot()
+}
+
+// takeKDFSlot takes one of the maxConcurrentKDF slots. Rather than queueing
+// up, it fails with a *errors.RateLimitError when every slot is taken.
+func (s *KeyGenService) takeKDFSlot() (func(), error) {
+	select {
+	case s.kdfSlots <- struct{}{}:
+		return func() { <-s.kdfSlots }, nil
+	default:
+		log.Warn("Too many passphrase key derivations at once")
+		return nil, &errors.RateLimitError{Limit: kdfLimit, RetryAfter: time.Second}
+	}
+}
+
+// checkExport validates options for network before any key is decrypted.
+func (s *KeyGenService) checkExport(network string, options ExportOptions) error {
+	if options.Format == encryption.FormatAge {
+		if options.Recipient != "" {
+			if !encryption.ValidAgeRecipient(options.Recipient) {
+				return errors.ErrInvalidRecipient
+			}
+			return nil
+		}
+		if options.Passphrase == "" {
+			return errors.ErrPassphraseRequired
+		}
+		return nil
+	}
+
+	if _, err := s.keyEncrypter(network, options.Format); err != nil {
+		return err
+	}
+	if options.Passphrase == "" {
+		return errors.ErrPassphraseRequired
+	}
+	return nil
+}
+
+// keyEncrypter returns the generator of network if it encodes its keys as
+// format.
+func (s *KeyGenService) keyEncrypter(network, format string) (PrivateKeyEncrypter, error) {
+	generator, exists := s.generator(network)
+	if !exists {
+		return nil, errors.ErrUnsupportedNetwork
+	}
+	encrypter, ok := generator.(PrivateKeyEncrypter)
+	if !ok || encrypter.EncryptedKeyFormat() != format {
+		return nil, errors.ErrExportFormat
+	}
+	return encrypter, nil
+}
+
+func (s *KeyGenService) encryptExport(network string, keyPairAndAddress KeyPairAndAddress, options ExportOptions) (ExportedKey, error) {
+	var encrypted string
+	var err error
+	if options.Format == encryption.FormatAge {
+		encrypted, err = encryption.AgeEncrypt([]byte(keyPairAndAddress.PrivateKey), options.Recipient, options.Passphrase)
+	} else {
+		var encrypter PrivateKeyEncrypter
+		if encrypter, err = s.keyEncrypter(network, options.Format); err != nil {
+			return ExportedKey{}, err
+		}
+		encrypted, err = encrypter.EncryptPrivateKey(keyPairAndAddress.PrivateKey, options.Passphrase)
+	}
+	if err != nil {
+		log.WithField("format", options.Format).WithError(err).Error("Failed to encrypt exported private key")
+		return ExportedKey{}, errors.ErrInternalServerError
+	}
+
+	return ExportedKey{
+		KeyPairAndAddress:   keyPairAndAddress.Public(),
+		Format:              options.Format,
+		EncryptedPrivateKey: encrypted,
+	}, nil
+}
@@ -0,0 +1,14 @@
+package services
+
+// HoldKDFSlots takes every passphrase key derivation slot, as derivations
+// running for long would, until the returned function releases them.
+func (s *KeyGenService) HoldKDFSlots() (release func()) {
+	for i := 0; i < cap(s.kdfSlots); i++ {
+		s.kdfSlots <- struct{}{}
+	}
+	return func() {
+		for i := 0; i < cap(s.kdfSlots); i++ {
+			<-s.kdfSlots
+		}
+	}
+}
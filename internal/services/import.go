@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	stderrors "errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// KeyImport is a private key generated elsewhere.
+type KeyImport struct {
+	// PrivateKey is in one of the encodings of the network, see
+	// PrivateKeyImporter, e.g. WIF, hex or BIP38 for Bitcoin.
+	PrivateKey string
+	// Passphrase decrypts an encrypted PrivateKey.
+	Passphrase string
+	// Address is the address the key is known by, it has to match the one
+	// derived from PrivateKey.
+	Address string
+}
+
+// ImportKey stores a private key generated elsewhere as the key of userID on
+// network, encrypted like generated keys and marked as imported. It never
+// replaces an existing record. Only the master seed is not needed, so keys
+// can be imported while the service waits for it. The caller needs the
+// keys:import scope. The returned value never contains the private key.
+func (s *KeyGenService) ImportKey(ctx context.Context, userID int, network string, input KeyImport) (_ KeyPairAndAddress, err error) {
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"network": network,
+	}).Warn("Request to import private key")
+
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionImport, userID, network, err)
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeImport); err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	// Only the encrypted encodings, keystores and BIP38 keys, take a
+	// passphrase, and they derive their key from it
+	if input.Passphrase != "" {
+		releaseSlot, err := s.takeKDFSlot()
+		if err != nil {
+			return KeyPairAndAddress{}, err
+		}
+		defer releaseSlot()
+	}
+
+	release, err := s.acquireKeyManager()
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	defer release()
+
+	canonical, generator, ok := s.importGenerator(network)
+	if !ok {
+		return KeyPairAndAddress{}, errors.ErrUnsupportedNetwork
+	}
+	importer, ok := generator.(PrivateKeyImporter)
+	if !ok {
+		return KeyPairAndAddress{}, errors.ErrImportUnsupported
+	}
+	if chainInfo, ok := generator.(ChainInfo); ok && !s.allowMainnet && chainInfo.IsMainnet() {
+		return KeyPairAndAddress{}, errors.ErrMainnetDisabled
+	}
+
+	keyPairAndAddress, err := importer.ImportPrivateKey(input.PrivateKey, input.Passphrase)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	if !sameAddress(keyPairAndAddress.Address, input.Address) {
+		return KeyPairAndAddress{}, errors.ErrAddressMismatch
+	}
+
+	exists, err := s.repository.KeyExists(ctx, userID, canonical)
+	if err != nil {
+		log.WithError(err).Error("Failed to check if keys exist")
+		return KeyPairAndAddress{}, err
+	}
+	if exists {
+		return KeyPairAndAddress{}, errors.ErrKeyExists
+	}
+
+	keyData := db.KeyData{
+		UserID:        userID,
+		Network:       canonical,
+		Address:       keyPairAndAddress.Address,
+		PublicKey:     keyPairAndAddress.PublicKey,
+		AddressType:   keyPairAndAddress.AddressType,
+		Chain:         keyPairAndAddress.Chain,
+		ChainID:       keyPairAndAddress.ChainID,
+		SchemaVersion: db.CurrentSchemaVersion,
+		Provenance:    db.ProvenanceImported,
+	}
+	keyData, err = s.encryptPrivateKey(ctx, keyData, keyPairAndAddress.PrivateKey)
+	if err != nil {
+		log.WithError(err).Error("Failed to encrypt private key")
+		return KeyPairAndAddress{}, err
+	}
+	// The check above only gives a clear error early, the insert fails on
+	// the unique index if a record was created meanwhile.
+	err = s.repository.InsertKey(ctx, keyData)
+	if stderrors.Is(err, db.ErrDuplicate) {
+		return KeyPairAndAddress{}, errors.ErrKeyExists
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to save keys")
+		return KeyPairAndAddress{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"network": canonical,
+		"address": keyData.Address,
+	}).Info("Imported private key")
+	return toKeyPairAndAddress(keyData), nil
+}
+
+// importGenerator returns the canonical name and the generator of network.
+// The generators of the seed networks are only registered with the master
+// seed, importing needs none, so while sealed they are looked up without it.
+func (s *KeyGenService) importGenerator(network string) (string, KeyGenerator, bool) {
+	canonical := s.ResolveNetwork(network, "")
+	if generator, ok := s.generator(canonical); ok {
+		return canonical, generator, true
+	}
+	networks, aliases := seedNetworks(nil)
+	if alias, ok := aliases[network]; ok {
+		canonical = alias
+	}
+	generator, ok := networks[canonical]
+	return canonical, generator, ok
+}
+
+// sameAddress compares addresses, Ethereum ones regardless of their EIP-55
+// checksum casing.
+func sameAddress(derived, claimed string) bool {
+	claimed = strings.TrimSpace(claimed)
+	if strings.HasPrefix(derived, "0x") {
+		return strings.EqualFold(derived, claimed)
+	}
+	return derived == claimed
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/encryption"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errPrivateKeyMismatch = stderrors.New("private key does not match the record's public key")
+	errUnboundRecord      = stderrors.New("key record is not bound to its encrypted private key")
+)
+
+// addressBoundSchemaVersion is the schema version of records bound to their
+// address but not to their public key.
+const addressBoundSchemaVersion = 2
+
+// associatedData binds an encrypted private key to the record it belongs to,
+// so ciphertexts cannot be swapped between users, networks or addresses and
+// the public key served for it cannot be replaced.
+func associatedData(keyData db.KeyData) []byte {
+	if keyData.SchemaVersion == addressBoundSchemaVersion {
+		data, _ := json.Marshal(struct {
+			SchemaVersion int    `json:"schema_version"`
+			UserID        int    `json:"user_id"`
+			Network       string `json:"network"`
+			Address       string `json:"address"`
+		}{keyData.SchemaVersion, keyData.UserID, keyData.Network, keyData.Address})
+		return data
+	}
+	data, _ := json.Marshal(struct {
+		SchemaVersion int    `json:"schema_version"`
+		UserID        int    `json:"user_id"`
+		Network       string `json:"network"`
+		Address       string `json:"address"`
+		PublicKey     string `json:"public_key"`
+	}{keyData.SchemaVersion, keyData.UserID, keyData.Network, keyData.Address, keyData.PublicKey})
+	return data
+}
+
+func isBound(keyData db.KeyData) bool {
+	return keyData.SchemaVersion >= db.CurrentSchemaVersion
+}
+
+// RequireBoundRecords makes the service refuse every key record that is not
+// bound to its encrypted private key, instead of accepting it until the
+// re-encryption job migrates it. The schema version is stored in the record
+// itself, so until this is set anyone who can write records can downgrade a
+// swapped record to skip the binding check. Set it once the job has migrated
+// every record.
+func (s *KeyGenService) RequireBoundRecords() {
+	s.requireBound = true
+}
+
+// checkBound fails for unbound records once RequireBoundRecords is set.
+func (s *KeyGenService) checkBound(keyData db.KeyData) error {
+	if isBound(keyData) && keyData.WrappedDataKey != "" {
+		return nil
+	}
+	if s.requireBound {
+		return errUnboundRecord
+	}
+	log.WithFields(log.Fields{
+		"user_id": keyData.UserID,
+		"network": keyData.Network,
+	}).Warn("Key record is not bound to its ciphertext, run the re-encryption job")
+	return nil
+}
+
+// encryptPrivateKey seals privateKey into keyData, bound to the record.
+func (s *KeyGenService) encryptPrivateKey(ctx context.Context, keyData db.KeyData, privateKey string) (db.KeyData, error) {
+	keyData.SchemaVersion = db.CurrentSchemaVersion
+	envelope, err := encryption.EncryptEnvelope(ctx, s.currentKeyManager(), privateKey, associatedData(keyData))
+	if err != nil {
+		return db.KeyData{}, err
+	}
+	keyData.EncryptedPrivateKey = envelope.Ciphertext
+	keyData.WrappedDataKey = envelope.WrappedDataKey
+	keyData.KEKID = envelope.KEKID
+	return keyData, nil
+}
+
+// decryptPrivateKey opens the private key of keyData. Unless bound records
+// are required, records written before envelope encryption are opened with
+// any known key, records written before schema version 2 are opened
+// without associated data and version 2 records without their public key.
+func (s *KeyGenService) decryptPrivateKey(ctx context.Context, keyData db.KeyData) (string, error) {
+	if err := s.checkBound(keyData); err != nil {
+		return "", err
+	}
+	return s.openPrivateKey(ctx, keyData)
+}
+
+// openPrivateKey is decryptPrivateKey without checkBound.
+func (s *KeyGenService) openPrivateKey(ctx context.Context, keyData db.KeyData) (string, error) {
+	if keyData.WrappedDataKey == "" {
+		return encryption.Decrypt(ctx, s.currentKeyManager(), keyData.EncryptedPrivateKey)
+	}
+	var ad []byte
+	if keyData.SchemaVersion >= addressBoundSchemaVersion {
+		ad = associatedData(keyData)
+	}
+	return encryption.DecryptEnvelope(ctx, s.currentKeyManager(), envelopeOf(keyData), ad)
+}
+
+// verifyPrivateKeyBinding checks that the encrypted private key belongs to
+// this record. Unbound records cannot be verified, they are accepted until
+// bound records are required, see RequireBoundRecords.
+func (s *KeyGenService) verifyPrivateKeyBinding(ctx context.Context, keyData db.KeyData) error {
+	if keyData.WatchOnly {
+		return nil
+	}
+	if !isBound(keyData) || keyData.WrappedDataKey == "" {
+		return s.checkBound(keyData)
+	}
+	return encryption.VerifyEnvelope(ctx, s.currentKeyManager(), envelopeOf(keyData), associatedData(keyData))
+}
+
+func envelopeOf(keyData db.KeyData) encryption.Envelope {
+	return encryption.Envelope{
+		Ciphertext:     keyData.EncryptedPrivateKey,
+		WrappedDataKey: keyData.WrappedDataKey,
+		KEKID:          keyData.KEKID,
+	}
+}
+
+// privateKeyMatches reports whether a WIF or hex encoded private key belongs
+// to the compressed or uncompressed public key stored in keyData.
+func privateKeyMatches(keyData db.KeyData, privateKey string) bool {
+	var publicKey *btcec.PublicKey
+	if wif, err := btcutil.DecodeWIF(privateKey); err == nil {
+		publicKey = wif.PrivKey.PubKey()
+	} else if raw, err := hex.DecodeString(privateKey); err == nil && len(raw) == btcec.PrivKeyBytesLen {
+		key, _ := btcec.PrivKeyFromBytes(raw)
+		publicKey = key.PubKey()
+	} else {
+		return false
+	}
+	return keyData.PublicKey == hex.EncodeToString(publicKey.SerializeCompressed()) ||
+		keyData.PublicKey == hex.EncodeToString(publicKey.SerializeUncompressed())
+}
@@ -2,48 +2,229 @@ package services
 
 import (
 	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
 	"crypto-keygen-service/internal/db"
 	"crypto-keygen-service/internal/repositories"
-	"crypto-keygen-service/internal/util/encryption"
 	"crypto-keygen-service/internal/util/errors"
+	"crypto-keygen-service/internal/util/kms"
 	. "crypto-keygen-service/internal/util/network_factory"
 	"crypto-keygen-service/internal/util/network_factory/generators/bitcoin"
 	"crypto-keygen-service/internal/util/network_factory/generators/ethereum"
+	"crypto-keygen-service/internal/util/shamir"
+	stderrors "errors"
+	"sync"
+	"sync/atomic"
+
 	log "github.com/sirupsen/logrus"
 )
 
 type KeyGenService struct {
+	// mu guards generators, aliases and keyManager, watch-only networks are
+	// registered at runtime and sealing replaces all of them
+	mu         sync.RWMutex
 	generators map[string]KeyGenerator
+	aliases    map[string]string
 	repository *repositories.KeyGenRepository
+	keyManager kms.KeyManager
+	// wiped is set once Seal has wiped keyManager
+	wiped         bool
+	allowMainnet  bool
+	revealEnabled bool
+	// requireBound refuses unbound key records, see RequireBoundRecords
+	requireBound bool
+	auditLog     *audit.Logger
+	quota        GenerationQuota
+	revealQuorum *RevealQuorum
+	// kdfSlots bounds the passphrase key derivations running at once, see
+	// acquireKDFSlot
+	kdfSlots chan struct{}
+
+	state atomic.Value // SealState
+	// secretsMu is held for reading by every operation that uses the master
+	// seed or the key manager, Seal takes it for writing
+	secretsMu sync.RWMutex
+	// sealMu guards masterSeed, unsealShares and seal state transitions
+	sealMu           sync.Mutex
+	masterSeed       []byte
+	unsealShares     []shamir.Share
+	seedCheck        string
+	keyManagerLoader func() (kms.KeyManager, error)
 }
 
-func NewKeyGenService(repo *repositories.KeyGenRepository, masterSeed []byte) *KeyGenService {
+// NewKeyGenService registers the generators derived from masterSeed. Private
+// keys are encrypted under data keys wrapped by keyManager. Without a master
+// seed the service starts sealed, see Unseal. The service takes ownership of
+// masterSeed and zeroes it when sealed.
+func NewKeyGenService(repo *repositories.KeyGenRepository, masterSeed []byte, keyManager kms.KeyManager) *KeyGenService {
 	service := &KeyGenService{
-		generators: make(map[string]KeyGenerator),
-		repository: repo,
+		generators:   make(map[string]KeyGenerator),
+		aliases:      make(map[string]string),
+		repository:   repo,
+		keyManager:   keyManager,
+		allowMainnet: true,
+		kdfSlots:     make(chan struct{}, maxConcurrentKDF),
+	}
+	if masterSeed == nil {
+		service.state.Store(StateSealed)
+	} else {
+		service.state.Store(StateUnsealed)
+		service.masterSeed = masterSeed
+		service.registerSeedGenerators(masterSeed)
 	}
-	service.RegisterGenerator("bitcoin", &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed})
-	service.RegisterGenerator("ethereum", &ethereum.EthereumKeyGen{MasterSeed: masterSeed})
-	// Add more networks here
 	return service
 }
 
+// GenerationQuota limits how many keys are generated, see
+// ratelimit.Limiter.
+type GenerationQuota interface {
+	// AllowGeneration fails with *errors.RateLimitError once the quota is
+	// used up.
+	AllowGeneration(ctx context.Context) error
+}
+
+// SetGenerationQuota makes GetKeysAndAddress take from quota before
+// generating keys, reading existing keys is not limited.
+func (s *KeyGenService) SetGenerationQuota(quota GenerationQuota) {
+	s.quota = quota
+}
+
+func (s *KeyGenService) currentKeyManager() kms.KeyManager {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keyManager
+}
+
+func (s *KeyGenService) keyManagerWiped() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.wiped
+}
+
+func (s *KeyGenService) registerSeedGenerators(masterSeed []byte) {
+	networks, aliases := seedNetworks(masterSeed)
+	for network, generator := range networks {
+		s.RegisterGenerator(network, generator)
+	}
+	for alias, network := range aliases {
+		s.RegisterAlias(alias, network)
+	}
+}
+
+// seedNetworks returns the generators of the networks derived from
+// masterSeed and the aliases of their names.
+func seedNetworks(masterSeed []byte) (map[string]KeyGenerator, map[string]string) {
+	networks := make(map[string]KeyGenerator)
+	aliases := make(map[string]string)
+	// bitcoin, bitcoin-testnet, bitcoin-signet, bitcoin-regtest and their address types
+	for suffix, params := range bitcoin.Networks {
+		base := networkName("bitcoin", suffix)
+		for _, addressType := range bitcoin.AddressTypes {
+			network := base + "-" + string(addressType)
+			if addressType == bitcoin.DefaultAddressType {
+				network = base
+				aliases[base+"-"+string(addressType)] = base
+			}
+			networks[network] = &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: addressType, Params: params}
+		}
+	}
+	// ethereum, ethereum-sepolia, ethereum-holesky
+	for suffix, chain := range ethereum.Chains {
+		networks[networkName("ethereum", suffix)] = &ethereum.EthereumKeyGen{MasterSeed: masterSeed, Chain: chain}
+	}
+	// Add more networks here
+	return networks, aliases
+}
+
+func networkName(coin, suffix string) string {
+	if suffix == "" {
+		return coin
+	}
+	return coin + "-" + suffix
+}
+
+// DisableMainnet makes the service refuse every network whose generator
+// targets a mainnet, so non-production deployments cannot issue real addresses.
+func (s *KeyGenService) DisableMainnet() {
+	s.allowMainnet = false
+}
+
 func (s *KeyGenService) RegisterGenerator(network string, generator KeyGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.generators[network] = generator
 }
 
+func (s *KeyGenService) generator(network string) (KeyGenerator, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	generator, exists := s.generators[network]
+	return generator, exists
+}
+
+// EnablePrivateKeyReveal allows ExportPrivateKey to decrypt stored private
+// keys. Reveal is disabled by default.
+func (s *KeyGenService) EnablePrivateKeyReveal() {
+	s.revealEnabled = true
+}
+
+// RegisterAlias makes alias resolve to an already registered network, so that
+// both names share the same generator and the same persisted record.
+func (s *KeyGenService) RegisterAlias(alias, network string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aliases[alias] = network
+}
+
+// ResolveNetwork combines a network and an optional address type (e.g.
+// "bitcoin" and "p2wpkh") into the registered network identifier
+// ("bitcoin-p2wpkh") under which keys are generated and persisted.
+func (s *KeyGenService) ResolveNetwork(network, addressType string) string {
+	if addressType != "" {
+		network = network + "-" + addressType
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if canonical, ok := s.aliases[network]; ok {
+		return canonical
+	}
+	return network
+}
+
 // GetKeysAndAddress checks if a record exists for the given userID and network.
 // If the record exists, it queries the database again to retrieve the record and return it.
 // If the record does not exist, it creates a new record.
 // This approach is used to avoid relying on error handling for control flow,
 // providing clearer and more maintainable code.
-func (s *KeyGenService) GetKeysAndAddress(userID int, network string) (KeyPairAndAddress, error) {
+// The returned value never contains the private key, see ExportPrivateKey.
+// The caller needs the keys:read_public scope, and keys:generate to create a
+// record.
+func (s *KeyGenService) GetKeysAndAddress(ctx context.Context, userID int, network string) (_ KeyPairAndAddress, err error) {
 	log.WithFields(log.Fields{
 		"user_id": userID,
 		"network": network,
 	}).Info("Request to get keys and address")
 
-	ctx := context.Background()
+	action := audit.ActionRetrieve
+	defer func() {
+		_ = s.recordAudit(ctx, action, userID, network, err)
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeReadPublic); err != nil {
+		return KeyPairAndAddress{}, err
+	}
+
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	defer release()
+
+	if !s.allowMainnet && s.isMainnet(network) {
+		log.WithField("network", network).Error("Mainnet network requested while mainnet is disabled")
+		return KeyPairAndAddress{}, errors.ErrMainnetDisabled
+	}
+
 	exists, err := s.repository.KeyExists(ctx, userID, network)
 	if err != nil {
 		log.WithError(err).Error("Failed to check if keys exist")
@@ -54,31 +235,142 @@ func (s *KeyGenService) GetKeysAndAddress(userID int, network string) (KeyPairAn
 		return s.retrieveExistingKeys(ctx, userID, network)
 	}
 
-	return s.generateAndSaveKeys(ctx, userID, network)
+	action = audit.ActionGenerate
+	if err := auth.Authorize(ctx, auth.ScopeGenerate); err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	if s.quota != nil {
+		if err := s.quota.AllowGeneration(ctx); err != nil {
+			return KeyPairAndAddress{}, err
+		}
+	}
+
+	keyPairAndAddress, err := s.generateAndSaveKeys(ctx, userID, network)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	return keyPairAndAddress.Public(), nil
 }
 
-func (s *KeyGenService) retrieveExistingKeys(ctx context.Context, userID int, network string) (KeyPairAndAddress, error) {
+// decryptStoredKey returns the record of userID on network with its
+// decrypted private key, the caller holds the secrets. Like generation, it
+// refuses mainnet networks while mainnet is disabled.
+func (s *KeyGenService) decryptStoredKey(ctx context.Context, userID int, network string) (KeyPairAndAddress, error) {
+	if !s.allowMainnet && s.isMainnet(network) {
+		log.WithField("network", network).Error("Mainnet private key requested while mainnet is disabled")
+		return KeyPairAndAddress{}, errors.ErrMainnetDisabled
+	}
+
+	exists, err := s.repository.KeyExists(ctx, userID, network)
+	if err != nil {
+		log.WithError(err).Error("Failed to check if keys exist")
+		return KeyPairAndAddress{}, err
+	}
+	if !exists {
+		return KeyPairAndAddress{}, errors.ErrKeyNotFound
+	}
+
 	keyData, err := s.repository.GetKey(ctx, userID, network)
 	if err != nil {
 		log.WithError(err).Error("Failed to retrieve existing keys")
 		return KeyPairAndAddress{}, err
 	}
+	if keyData.WatchOnly {
+		return KeyPairAndAddress{}, errors.ErrWatchOnly
+	}
 
-	privateKey, err := encryption.Decrypt(keyData.EncryptedPrivateKey)
+	privateKey, err := s.decryptPrivateKey(ctx, keyData)
 	if err != nil {
 		log.WithError(err).Error("Failed to decrypt private key")
+		return KeyPairAndAddress{}, errors.ErrRecordIntegrity
+	}
+
+	keyPairAndAddress := toKeyPairAndAddress(keyData)
+	keyPairAndAddress.PrivateKey = privateKey
+	return keyPairAndAddress, nil
+}
+
+// GetAccountKey returns the account-level extended public key of network,
+// from which every address issued for that network can be derived. The
+// caller needs the keys:read_public scope.
+func (s *KeyGenService) GetAccountKey(ctx context.Context, network string) (_ AccountKey, err error) {
+	log.WithField("network", network).Info("Request to export account key")
+
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionExportAccountKey, 0, network, err)
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeReadPublic); err != nil {
+		return AccountKey{}, err
+	}
+
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return AccountKey{}, err
+	}
+	defer release()
+
+	generator, exists := s.generator(network)
+	if !exists {
+		return AccountKey{}, errors.ErrUnsupportedNetwork
+	}
+	if !s.allowMainnet && s.isMainnet(network) {
+		return AccountKey{}, errors.ErrMainnetDisabled
+	}
+	exporter, ok := generator.(AccountKeyExporter)
+	if !ok {
+		return AccountKey{}, errors.ErrAccountKeyExport
+	}
+
+	accountKey, err := exporter.AccountKey()
+	if err != nil {
+		log.WithError(err).Error("Failed to export account key")
+		return AccountKey{}, err
+	}
+	return accountKey, nil
+}
+
+func (s *KeyGenService) isMainnet(network string) bool {
+	generator, _ := s.generator(network)
+	chainInfo, ok := generator.(ChainInfo)
+	return ok && chainInfo.IsMainnet()
+}
+
+func (s *KeyGenService) retrieveExistingKeys(ctx context.Context, userID int, network string) (KeyPairAndAddress, error) {
+	keyData, err := s.repository.GetKey(ctx, userID, network)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve existing keys")
 		return KeyPairAndAddress{}, err
 	}
+	if keyData.UserID != userID || keyData.Network != network {
+		return KeyPairAndAddress{}, errors.ErrRecordIntegrity
+	}
+	if err := s.verifyPrivateKeyBinding(ctx, keyData); err != nil {
+		log.WithFields(log.Fields{
+			"user_id": userID,
+			"network": network,
+		}).WithError(err).Error("Stored key record failed integrity verification")
+		return KeyPairAndAddress{}, errors.ErrRecordIntegrity
+	}
+
+	return toKeyPairAndAddress(keyData), nil
+}
 
+func toKeyPairAndAddress(keyData db.KeyData) KeyPairAndAddress {
 	return KeyPairAndAddress{
-		Address:    keyData.Address,
-		PublicKey:  keyData.PublicKey,
-		PrivateKey: privateKey,
-	}, nil
+		Address:        keyData.Address,
+		PublicKey:      keyData.PublicKey,
+		DerivationPath: keyData.DerivationPath,
+		AddressType:    keyData.AddressType,
+		Chain:          keyData.Chain,
+		ChainID:        keyData.ChainID,
+		WatchOnly:      keyData.WatchOnly,
+		Imported:       keyData.Provenance == db.ProvenanceImported,
+	}
 }
 
 func (s *KeyGenService) generateAndSaveKeys(ctx context.Context, userID int, network string) (KeyPairAndAddress, error) {
-	generator, exists := s.generators[network]
+	generator, exists := s.generator(network)
 	if !exists {
 		log.WithFields(log.Fields{
 			"network": network,
@@ -92,21 +384,32 @@ func (s *KeyGenService) generateAndSaveKeys(ctx context.Context, userID int, net
 		return KeyPairAndAddress{}, err
 	}
 
-	encryptedPrivateKey, err := encryption.Encrypt(keyPairAndAddress.PrivateKey)
-	if err != nil {
-		log.WithError(err).Error("Failed to encrypt private key")
-		return KeyPairAndAddress{}, err
-	}
-
 	keyData := db.KeyData{
-		UserID:              userID,
-		Network:             network,
-		Address:             keyPairAndAddress.Address,
-		PublicKey:           keyPairAndAddress.PublicKey,
-		EncryptedPrivateKey: encryptedPrivateKey,
+		UserID:         userID,
+		Network:        network,
+		Address:        keyPairAndAddress.Address,
+		PublicKey:      keyPairAndAddress.PublicKey,
+		DerivationPath: keyPairAndAddress.DerivationPath,
+		AddressType:    keyPairAndAddress.AddressType,
+		Chain:          keyPairAndAddress.Chain,
+		ChainID:        keyPairAndAddress.ChainID,
+		WatchOnly:      keyPairAndAddress.WatchOnly,
+		SchemaVersion:  db.CurrentSchemaVersion,
+		Provenance:     db.ProvenanceDerived,
+	}
+	if !keyPairAndAddress.WatchOnly {
+		keyData, err = s.encryptPrivateKey(ctx, keyData, keyPairAndAddress.PrivateKey)
+		if err != nil {
+			log.WithError(err).Error("Failed to encrypt private key")
+			return KeyPairAndAddress{}, err
+		}
 	}
 
-	err = s.repository.SaveKey(ctx, keyData)
+	err = s.repository.InsertKey(ctx, keyData)
+	if stderrors.Is(err, db.ErrDuplicate) {
+		// A concurrent request created the record first, it is kept.
+		return s.retrieveExistingKeys(ctx, userID, network)
+	}
 	if err != nil {
 		log.WithError(err).Error("Failed to save keys")
 		return KeyPairAndAddress{}, err
@@ -2,20 +2,83 @@ package services_test
 
 import (
 	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
 	"crypto-keygen-service/internal/db"
 	dbi "crypto-keygen-service/internal/db"
 	"crypto-keygen-service/internal/repositories"
 	"crypto-keygen-service/internal/services"
 	"crypto-keygen-service/internal/util/encryption"
+	apperrors "crypto-keygen-service/internal/util/errors"
+	"crypto-keygen-service/internal/util/kms"
+	"crypto-keygen-service/internal/util/network_factory/generators/bitcoin"
+	"crypto-keygen-service/internal/util/network_factory/generators/ethereum"
+	"crypto-keygen-service/internal/util/shamir"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"filippo.io/age"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
-const sampleMasterSeed = "sample-master-seed"
+const (
+	sampleMasterSeed    = "sample-master-seed"
+	sampleEncryptionKey = "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
+)
+
+func newKeyManager(t *testing.T, current kms.Key, retired ...kms.Key) kms.KeyManager {
+	keyManager, err := kms.NewLocalKeyManager(current, retired...)
+	assert.NoError(t, err)
+	return keyManager
+}
+
+// legacyEncrypt seals privateKey the way records were stored before envelope
+// encryption, directly under the key-encryption key.
+func legacyEncrypt(t *testing.T, keyManager kms.KeyManager, privateKey string) string {
+	sealed, _, err := keyManager.Wrap(context.Background(), []byte(privateKey), nil)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+// exportedPrivateKey exports the private key of userID on network to an age
+// identity of the test and returns it decrypted.
+func exportedPrivateKey(t *testing.T, service *services.KeyGenService, ctx context.Context, userID int, network string) (string, error) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	exported, err := service.ExportPrivateKey(ctx, userID, network, services.ExportOptions{Format: encryption.FormatAge, Recipient: identity.Recipient().String()})
+	if err != nil {
+		return "", err
+	}
+	decrypted, err := encryption.AgeDecrypt(exported.EncryptedPrivateKey, identity.String(), "")
+	assert.NoError(t, err)
+	return string(decrypted), nil
+}
+
+// fetchedPrivateKey fetches the private key of the approved reveal request
+// id to an age identity of the test and returns it decrypted.
+func fetchedPrivateKey(t *testing.T, service *services.KeyGenService, ctx context.Context, id string) (string, error) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	exported, err := service.FetchExportedKey(ctx, id, services.ExportOptions{Format: encryption.FormatAge, Recipient: identity.Recipient().String()})
+	if err != nil {
+		return "", err
+	}
+	decrypted, err := encryption.AgeDecrypt(exported.EncryptedPrivateKey, identity.String(), "")
+	assert.NoError(t, err)
+	return string(decrypted), nil
+}
 
 type InMemoryDatabase struct {
-	data map[int]map[string]db.KeyData
+	data              map[int]map[string]db.KeyData
+	watchOnlyAccounts []db.WatchOnlyAccount
+	apiClients        map[string]db.APIClient
+	revealRequests    map[string]db.RevealRequest
 }
 
 func (db *InMemoryDatabase) CreateIndexes(ctx context.Context) error {
@@ -24,7 +87,9 @@ func (db *InMemoryDatabase) CreateIndexes(ctx context.Context) error {
 
 func NewInMemoryDatabase() *InMemoryDatabase {
 	return &InMemoryDatabase{
-		data: make(map[int]map[string]db.KeyData),
+		data:           make(map[int]map[string]db.KeyData),
+		apiClients:     make(map[string]db.APIClient),
+		revealRequests: make(map[string]db.RevealRequest),
 	}
 }
 
@@ -36,6 +101,13 @@ func (db *InMemoryDatabase) SaveKey(ctx context.Context, keyData db.KeyData) err
 	return nil
 }
 
+func (db *InMemoryDatabase) InsertKey(ctx context.Context, keyData db.KeyData) error {
+	if _, exists := db.data[keyData.UserID][keyData.Network]; exists {
+		return dbi.ErrDuplicate
+	}
+	return db.SaveKey(ctx, keyData)
+}
+
 func (db *InMemoryDatabase) GetKey(ctx context.Context, userID int, network string) (db.KeyData, error) {
 	if userKeys, ok := db.data[userID]; ok {
 		if keyData, ok := userKeys[network]; ok {
@@ -45,6 +117,96 @@ func (db *InMemoryDatabase) GetKey(ctx context.Context, userID int, network stri
 	return dbi.KeyData{}, errors.New("key not found")
 }
 
+func (db *InMemoryDatabase) CountKeys(ctx context.Context) (int64, error) {
+	var count int64
+	for _, userKeys := range db.data {
+		count += int64(len(userKeys))
+	}
+	return count, nil
+}
+
+func (db *InMemoryDatabase) ForEachKey(ctx context.Context, fn func(dbi.KeyData) error) error {
+	for _, userKeys := range db.data {
+		for _, keyData := range userKeys {
+			if err := fn(keyData); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *InMemoryDatabase) SaveWatchOnlyAccount(ctx context.Context, account dbi.WatchOnlyAccount) error {
+	for _, existing := range db.watchOnlyAccounts {
+		if existing.Network == account.Network {
+			return dbi.ErrDuplicate
+		}
+	}
+	db.watchOnlyAccounts = append(db.watchOnlyAccounts, account)
+	return nil
+}
+
+func (db *InMemoryDatabase) GetWatchOnlyAccounts(ctx context.Context) ([]dbi.WatchOnlyAccount, error) {
+	return db.watchOnlyAccounts, nil
+}
+
+func (db *InMemoryDatabase) SaveAPIClient(ctx context.Context, client dbi.APIClient) error {
+	if _, exists := db.apiClients[client.KeyID]; exists {
+		return dbi.ErrDuplicate
+	}
+	db.apiClients[client.KeyID] = client
+	return nil
+}
+
+func (db *InMemoryDatabase) UpdateAPIClient(ctx context.Context, client dbi.APIClient) error {
+	if _, exists := db.apiClients[client.KeyID]; !exists {
+		return dbi.ErrNotFound
+	}
+	db.apiClients[client.KeyID] = client
+	return nil
+}
+
+func (db *InMemoryDatabase) GetAPIClient(ctx context.Context, keyID string) (dbi.APIClient, error) {
+	client, exists := db.apiClients[keyID]
+	if !exists {
+		return dbi.APIClient{}, dbi.ErrNotFound
+	}
+	return client, nil
+}
+
+func (db *InMemoryDatabase) GetAPIClients(ctx context.Context) ([]dbi.APIClient, error) {
+	var clients []dbi.APIClient
+	for _, client := range db.apiClients {
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func (db *InMemoryDatabase) SaveRevealRequest(ctx context.Context, request dbi.RevealRequest) error {
+	if _, exists := db.revealRequests[request.ID]; exists {
+		return dbi.ErrDuplicate
+	}
+	db.revealRequests[request.ID] = request
+	return nil
+}
+
+func (db *InMemoryDatabase) UpdateRevealRequest(ctx context.Context, request dbi.RevealRequest) (dbi.RevealRequest, error) {
+	if stored, exists := db.revealRequests[request.ID]; !exists || stored.Version != request.Version {
+		return dbi.RevealRequest{}, dbi.ErrConflict
+	}
+	request.Version++
+	db.revealRequests[request.ID] = request
+	return request, nil
+}
+
+func (db *InMemoryDatabase) GetRevealRequest(ctx context.Context, id string) (dbi.RevealRequest, error) {
+	request, exists := db.revealRequests[id]
+	if !exists {
+		return dbi.RevealRequest{}, dbi.ErrNotFound
+	}
+	return request, nil
+}
+
 func (db *InMemoryDatabase) KeyExists(ctx context.Context, userID int, network string) (bool, error) {
 	if userKeys, ok := db.data[userID]; ok {
 		if _, ok := userKeys[network]; ok {
@@ -55,26 +217,1373 @@ func (db *InMemoryDatabase) KeyExists(ctx context.Context, userID int, network s
 }
 
 func TestGenerateAndRetrieveKeys(t *testing.T) {
-	encryptionKey := "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
-	err := encryption.Setup(encryptionKey)
-	assert.NoError(t, err)
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
 
 	inMemoryDB := NewInMemoryDatabase()
 	repo := repositories.NewKeyGenRepository(inMemoryDB)
-	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed))
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
 
 	userID := 12345
 	network := "bitcoin"
 
 	// Generate keys
-	result1, err := service.GetKeysAndAddress(userID, network)
+	result1, err := service.GetKeysAndAddress(context.Background(), userID, network)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, result1.Address)
 	assert.NotEmpty(t, result1.PublicKey)
-	assert.NotEmpty(t, result1.PrivateKey)
+	assert.Empty(t, result1.PrivateKey)
 
 	// Retrieve keys
-	result2, err := service.GetKeysAndAddress(userID, network)
+	result2, err := service.GetKeysAndAddress(context.Background(), userID, network)
 	assert.NoError(t, err)
 	assert.Equal(t, result1, result2)
 }
+
+func TestExportDecryptsStoredKeys(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+
+	_, err := service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+
+	// Disabled by default
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRevealDisabled, err)
+
+	service.EnablePrivateKeyReveal()
+	privateKey, err := exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+	expected, err := (&ethereum.EthereumKeyGen{MasterSeed: []byte(sampleMasterSeed)}).GenerateKeyPairAndAddress(1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.PrivateKey, privateKey)
+
+	stored := inMemoryDB.data[1]["ethereum"]
+	assert.NotEmpty(t, stored.WrappedDataKey)
+	assert.Equal(t, keyManager.KeyID(), stored.KEKID)
+
+	// Records written before envelope encryption are still readable
+	legacyCiphertext := legacyEncrypt(t, keyManager, privateKey)
+	inMemoryDB.data[1]["ethereum"] = dbi.KeyData{
+		UserID:              1,
+		Network:             "ethereum",
+		Address:             stored.Address,
+		PublicKey:           stored.PublicKey,
+		EncryptedPrivateKey: legacyCiphertext,
+	}
+	legacy, err := exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, legacy)
+
+	// Exports never generate keys
+	_, err = exportedPrivateKey(t, service, context.Background(), 2, "ethereum")
+	assert.Equal(t, apperrors.ErrKeyNotFound, err)
+}
+
+func TestAddressTypesArePersistedSeparately(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+
+	assert.Equal(t, "bitcoin", service.ResolveNetwork("bitcoin", ""))
+	assert.Equal(t, "bitcoin", service.ResolveNetwork("bitcoin", "p2pkh"))
+	assert.Equal(t, "bitcoin-p2wpkh", service.ResolveNetwork("bitcoin", "p2wpkh"))
+
+	legacy, err := service.GetKeysAndAddress(context.Background(), 1, service.ResolveNetwork("bitcoin", "p2pkh"))
+	assert.NoError(t, err)
+	segwit, err := service.GetKeysAndAddress(context.Background(), 1, service.ResolveNetwork("bitcoin", "p2wpkh"))
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, legacy.Address, segwit.Address)
+	assert.Equal(t, "p2wpkh", segwit.AddressType)
+	assert.Equal(t, "m/84'/0'/0'/0/1", segwit.DerivationPath)
+	assert.Len(t, inMemoryDB.data[1], 2)
+}
+
+func TestDisableMainnet(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	service.DisableMainnet()
+
+	for _, network := range []string{"bitcoin", "bitcoin-p2tr", "ethereum"} {
+		_, err := service.GetKeysAndAddress(context.Background(), 1, network)
+		assert.Equal(t, apperrors.ErrMainnetDisabled, err, network)
+	}
+
+	testnet, err := service.GetKeysAndAddress(context.Background(), 1, service.ResolveNetwork("bitcoin-testnet", "p2wpkh"))
+	assert.NoError(t, err)
+	assert.Equal(t, "testnet3", testnet.Chain)
+
+	sepolia, err := service.GetKeysAndAddress(context.Background(), 1, "ethereum-sepolia")
+	assert.NoError(t, err)
+	assert.Equal(t, "sepolia", sepolia.Chain)
+	assert.Equal(t, uint64(11155111), sepolia.ChainID)
+
+	// Mainnet keys stored before mainnet was disabled are not revealed either
+	inMemoryDB.data[1]["ethereum"] = inMemoryDB.data[1]["ethereum-sepolia"]
+	service.EnablePrivateKeyReveal()
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrMainnetDisabled, err)
+	_, err = service.ExportPrivateKey(context.Background(), 1, "ethereum", services.ExportOptions{Format: ethereum.FormatKeystore, Passphrase: "passphrase"})
+	assert.Equal(t, apperrors.ErrMainnetDisabled, err)
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum-sepolia")
+	assert.NoError(t, err)
+}
+
+func TestWatchOnlyAccount(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+
+	// The tenant's seed is not the service's seed
+	tenant := services.NewKeyGenService(repo, []byte("tenant-master-seed"), keyManager)
+	tenantAccount, err := tenant.GetAccountKey(context.Background(), "bitcoin-p2wpkh")
+	assert.NoError(t, err)
+	expected, err := tenant.GetKeysAndAddress(context.Background(), 7, "bitcoin-p2wpkh")
+	assert.NoError(t, err)
+
+	_, err = service.RegisterWatchOnlyAccount(context.Background(), "bitcoin", "bitcoin-p2wpkh", tenantAccount.ExtendedPublicKey, "")
+	assert.Equal(t, apperrors.ErrNetworkExists, err)
+	_, err = service.RegisterWatchOnlyAccount(context.Background(), "Acme BTC", "bitcoin-p2wpkh", tenantAccount.ExtendedPublicKey, "")
+	assert.Equal(t, apperrors.ErrInvalidNetworkName, err)
+
+	_, err = service.RegisterWatchOnlyAccount(context.Background(), "acme-btc", "bitcoin-p2wpkh", tenantAccount.ExtendedPublicKey, "")
+	assert.NoError(t, err)
+
+	watched, err := service.GetKeysAndAddress(context.Background(), 7, "acme-btc")
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Address, watched.Address)
+	assert.True(t, watched.WatchOnly)
+	assert.Empty(t, inMemoryDB.data[7]["acme-btc"].EncryptedPrivateKey)
+
+	_, err = exportedPrivateKey(t, service, context.Background(), 7, "acme-btc")
+	assert.Equal(t, apperrors.ErrWatchOnly, err)
+
+	// Registrations survive a restart
+	restarted := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	assert.NoError(t, restarted.LoadWatchOnlyAccounts(context.Background()))
+	watched, err = restarted.GetKeysAndAddress(context.Background(), 8, "acme-btc")
+	assert.NoError(t, err)
+	assert.True(t, watched.WatchOnly)
+}
+
+func TestReencryptAll(t *testing.T) {
+	const rotatedEncryptionKey = "qF3tHDT0VvDOFJVbHa3AZ4vxW2KDwVblQkGAq0pfUEs="
+	oldKey := kms.Key{ID: "v1", Material: sampleEncryptionKey}
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), newKeyManager(t, oldKey))
+	service.EnablePrivateKeyReveal()
+
+	for userID := 1; userID <= 3; userID++ {
+		_, err := service.GetKeysAndAddress(context.Background(), userID, "bitcoin")
+		assert.NoError(t, err)
+	}
+	before, err := exportedPrivateKey(t, service, context.Background(), 1, "bitcoin")
+	assert.NoError(t, err)
+
+	// Rotate and re-encrypt
+	service = services.NewKeyGenService(repo, []byte(sampleMasterSeed), newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}, oldKey))
+	var reports int
+	progress, err := service.ReencryptAll(context.Background(), func(services.ReencryptionProgress) { reports++ })
+	assert.NoError(t, err)
+	assert.Equal(t, services.ReencryptionProgress{Total: 3, Processed: 3, Reencrypted: 3}, progress)
+	assert.Equal(t, 3, reports)
+
+	// v1 is no longer needed
+	service = services.NewKeyGenService(repo, []byte(sampleMasterSeed), newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}))
+	service.EnablePrivateKeyReveal()
+	after, err := exportedPrivateKey(t, service, context.Background(), 1, "bitcoin")
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, "v2", inMemoryDB.data[1]["bitcoin"].KEKID)
+
+	// Running again is a no-op
+	progress, err = service.ReencryptAll(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), progress.Skipped)
+}
+
+func TestSealStopsReencryption(t *testing.T) {
+	const rotatedEncryptionKey = "qF3tHDT0VvDOFJVbHa3AZ4vxW2KDwVblQkGAq0pfUEs="
+	oldKey := kms.Key{ID: "v1", Material: sampleEncryptionKey}
+	ctx := context.Background()
+
+	repo := repositories.NewKeyGenRepository(NewInMemoryDatabase())
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), newKeyManager(t, oldKey))
+	for userID := 1; userID <= 150; userID++ {
+		_, err := service.GetKeysAndAddress(ctx, userID, "ethereum")
+		assert.NoError(t, err)
+	}
+
+	// Seal while the first batch is running, it goes through before the next one
+	service = services.NewKeyGenService(repo, []byte(sampleMasterSeed), newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}, oldKey))
+	sealed := make(chan struct{})
+	progress, err := service.ReencryptAll(ctx, func(p services.ReencryptionProgress) {
+		if p.Processed == 50 {
+			go func() {
+				service.Seal(ctx)
+				close(sealed)
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	<-sealed
+	assert.Equal(t, apperrors.ErrSealed, err)
+	assert.Equal(t, int64(100), progress.Processed)
+	assert.Equal(t, int64(100), progress.Reencrypted)
+}
+
+func TestSwappedCiphertextIsRejected(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+
+	_, err := service.GetKeysAndAddress(context.Background(), 1, "bitcoin")
+	assert.NoError(t, err)
+	_, err = service.GetKeysAndAddress(context.Background(), 2, "bitcoin")
+	assert.NoError(t, err)
+	assert.Equal(t, db.CurrentSchemaVersion, inMemoryDB.data[1]["bitcoin"].SchemaVersion)
+
+	// Move user 2's ciphertext into user 1's row
+	victim, attacker := inMemoryDB.data[1]["bitcoin"], inMemoryDB.data[2]["bitcoin"]
+	victim.EncryptedPrivateKey = attacker.EncryptedPrivateKey
+	victim.WrappedDataKey = attacker.WrappedDataKey
+	inMemoryDB.data[1]["bitcoin"] = victim
+
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "bitcoin")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "bitcoin")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+
+	// Rewriting the address breaks the binding as well
+	record := inMemoryDB.data[2]["bitcoin"]
+	record.Address = "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"
+	inMemoryDB.data[2]["bitcoin"] = record
+	_, err = service.GetKeysAndAddress(context.Background(), 2, "bitcoin")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+}
+
+func TestDowngradedRecordIsRejected(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+	service.RequireBoundRecords()
+
+	_, err := service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+	attacker, err := service.GetKeysAndAddress(context.Background(), 2, "ethereum")
+	assert.NoError(t, err)
+	attackerKey, err := exportedPrivateKey(t, service, context.Background(), 2, "ethereum")
+	assert.NoError(t, err)
+
+	// Move user 2's private key into user 1's row as an unbound envelope
+	envelope, err := encryption.EncryptEnvelope(context.Background(), keyManager, attackerKey, nil)
+	assert.NoError(t, err)
+	victim := inMemoryDB.data[1]["ethereum"]
+	victim.EncryptedPrivateKey, victim.WrappedDataKey, victim.KEKID, victim.SchemaVersion = envelope.Ciphertext, envelope.WrappedDataKey, envelope.KEKID, 0
+	inMemoryDB.data[1]["ethereum"] = victim
+
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+
+	// Nor as a legacy ciphertext, even with the attacker's address
+	victim.EncryptedPrivateKey, victim.WrappedDataKey, victim.KEKID = legacyEncrypt(t, keyManager, attackerKey), "", ""
+	victim.Address, victim.PublicKey = attacker.Address, attacker.PublicKey
+	inMemoryDB.data[1]["ethereum"] = victim
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+
+	// and the re-encryption job does not bind it either
+	progress, err := service.ReencryptAll(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), progress.Failed)
+	assert.Equal(t, 0, inMemoryDB.data[1]["ethereum"].SchemaVersion)
+}
+
+func TestReencryptAllBindsUnboundRecords(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	service := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+
+	revealed := make(map[int]string)
+	for userID := 1; userID <= 3; userID++ {
+		_, err := service.GetKeysAndAddress(context.Background(), userID, "ethereum")
+		assert.NoError(t, err)
+		key, err := exportedPrivateKey(t, service, context.Background(), userID, "ethereum")
+		assert.NoError(t, err)
+		revealed[userID] = key
+	}
+
+	// Rewrite the records as they were stored before schema version 2:
+	// user 1 as a legacy ciphertext, users 2 and 3 as unbound envelopes
+	// with user 3 holding user 2's private key.
+	legacyCiphertext := legacyEncrypt(t, keyManager, revealed[1])
+	legacy := inMemoryDB.data[1]["ethereum"]
+	legacy.EncryptedPrivateKey, legacy.WrappedDataKey, legacy.KEKID, legacy.SchemaVersion = legacyCiphertext, "", "", 0
+	inMemoryDB.data[1]["ethereum"] = legacy
+	for userID, privateKey := range map[int]string{2: revealed[2], 3: revealed[2]} {
+		envelope, err := encryption.EncryptEnvelope(context.Background(), keyManager, privateKey, nil)
+		assert.NoError(t, err)
+		record := inMemoryDB.data[userID]["ethereum"]
+		record.EncryptedPrivateKey, record.WrappedDataKey, record.KEKID, record.SchemaVersion = envelope.Ciphertext, envelope.WrappedDataKey, envelope.KEKID, 0
+		inMemoryDB.data[userID]["ethereum"] = record
+	}
+
+	// Unbound records stay readable until they are migrated
+	_, err := service.GetKeysAndAddress(context.Background(), 2, "ethereum")
+	assert.NoError(t, err)
+
+	progress, err := service.ReencryptAll(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, services.ReencryptionProgress{Total: 3, Processed: 3, Reencrypted: 2, Failed: 1}, progress)
+
+	for userID := 1; userID <= 2; userID++ {
+		assert.Equal(t, db.CurrentSchemaVersion, inMemoryDB.data[userID]["ethereum"].SchemaVersion)
+		key, err := exportedPrivateKey(t, service, context.Background(), userID, "ethereum")
+		assert.NoError(t, err)
+		assert.Equal(t, revealed[userID], key)
+	}
+	// The mismatched record is left for an operator to investigate
+	assert.Equal(t, 0, inMemoryDB.data[3]["ethereum"].SchemaVersion)
+}
+
+func TestPublicKeyIsBound(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	inMemoryDB := NewInMemoryDatabase()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(inMemoryDB), []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+	service.RequireBoundRecords()
+
+	_, err := service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	other, err := service.GetKeysAndAddress(ctx, 2, "ethereum")
+	assert.NoError(t, err)
+	privateKey, err := exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	stored := inMemoryDB.data[1]["ethereum"]
+
+	// Rewriting the public key breaks the binding
+	swapped := stored
+	swapped.PublicKey = other.PublicKey
+	inMemoryDB.data[1]["ethereum"] = swapped
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+	_, err = exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+
+	// Records of schema version 2 did not bind their public key, they are
+	// refused until the re-encryption job binds it
+	addressBound := func(record dbi.KeyData) dbi.KeyData {
+		ad := fmt.Sprintf(`{"schema_version":2,"user_id":%d,"network":%q,"address":%q}`, record.UserID, record.Network, record.Address)
+		envelope, err := encryption.EncryptEnvelope(ctx, keyManager, privateKey, []byte(ad))
+		assert.NoError(t, err)
+		record.EncryptedPrivateKey, record.WrappedDataKey, record.KEKID, record.SchemaVersion = envelope.Ciphertext, envelope.WrappedDataKey, envelope.KEKID, 2
+		return record
+	}
+	inMemoryDB.data[1]["ethereum"] = addressBound(stored)
+	_, err = exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+	// A rewritten public key is found when the job checks it
+	swapped.Network = "ethereum-holesky"
+	inMemoryDB.data[1]["ethereum-holesky"] = addressBound(swapped)
+
+	progress, err := service.ReencryptAll(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, services.ReencryptionProgress{Total: 3, Processed: 3, Reencrypted: 1, Skipped: 1, Failed: 1}, progress)
+	assert.Equal(t, db.CurrentSchemaVersion, inMemoryDB.data[1]["ethereum"].SchemaVersion)
+	exported, err := exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, exported)
+	assert.Equal(t, 2, inMemoryDB.data[1]["ethereum-holesky"].SchemaVersion)
+}
+
+func TestShamirUnseal(t *testing.T) {
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	ctx := context.Background()
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	unsealed := services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
+	expected, err := unsealed.GetKeysAndAddress(context.Background(), 1, "bitcoin-p2wpkh")
+	assert.NoError(t, err)
+
+	service := services.NewKeyGenService(repo, nil, keyManager)
+	assert.True(t, service.Sealed())
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "bitcoin-p2wpkh")
+	assert.Equal(t, apperrors.ErrSealed, err)
+	_, err = service.GetAccountKey(context.Background(), "bitcoin")
+	assert.Equal(t, apperrors.ErrSealed, err)
+
+	shares, err := shamir.Split([]byte(sampleMasterSeed), 3, 2)
+	assert.NoError(t, err)
+	other, err := shamir.Split([]byte("other-master-seed"), 3, 2)
+	assert.NoError(t, err)
+
+	_, err = service.SubmitUnsealShare(ctx, "not a share")
+	assert.Equal(t, apperrors.ErrInvalidShare, err)
+
+	status, err := service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.NoError(t, err)
+	assert.Equal(t, services.SealStatus{State: services.StateUnsealing, Progress: 1, Threshold: 2}, status)
+
+	// Duplicates and shares of another seed are rejected without losing progress
+	_, err = service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.Equal(t, apperrors.ErrInvalidShare, err)
+	status, err = service.SubmitUnsealShare(ctx, other[1].String())
+	assert.Equal(t, apperrors.ErrInvalidShare, err)
+	assert.Equal(t, 1, status.Progress)
+
+	// A corrupted share fails the combination and resets progress
+	corrupted := shares[1]
+	corrupted.Y = append([]byte{}, corrupted.Y...)
+	corrupted.Y[0] ^= 1
+	status, err = service.SubmitUnsealShare(ctx, corrupted.String())
+	assert.Equal(t, apperrors.ErrUnsealFailed, err)
+	assert.Equal(t, services.SealStatus{State: services.StateSealed}, status)
+
+	_, err = service.SubmitUnsealShare(ctx, shares[2].String())
+	assert.NoError(t, err)
+	status, err = service.SubmitUnsealShare(ctx, shares[1].String())
+	assert.NoError(t, err)
+	assert.Equal(t, services.StateUnsealed, status.State)
+	assert.False(t, service.Sealed())
+
+	keys, err := service.GetKeysAndAddress(context.Background(), 1, "bitcoin-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, keys)
+
+	_, err = service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.Equal(t, apperrors.ErrNotSealed, err)
+}
+
+func TestUnsealSeedCheck(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), nil, keyManager)
+	service.SetSeedCheck(shamir.CheckValue([]byte(sampleMasterSeed)))
+
+	// A consistent set of shares of another seed is refused
+	other, err := shamir.Split([]byte("other-master-seed"), 2, 2)
+	assert.NoError(t, err)
+	_, err = service.SubmitUnsealShare(ctx, other[0].String())
+	assert.NoError(t, err)
+	status, err := service.SubmitUnsealShare(ctx, other[1].String())
+	assert.Equal(t, apperrors.ErrUnsealFailed, err)
+	assert.Equal(t, services.SealStatus{State: services.StateSealed}, status)
+	assert.Equal(t, apperrors.ErrUnsealFailed, service.Unseal(ctx, []byte("other-master-seed")))
+
+	// A first share announcing an unreachable threshold can be discarded
+	unreachable, err := shamir.Split([]byte(sampleMasterSeed), 255, 255)
+	assert.NoError(t, err)
+	status, err = service.SubmitUnsealShare(ctx, unreachable[0].String())
+	assert.NoError(t, err)
+	assert.Equal(t, 255, status.Threshold)
+	status, err = service.ResetUnseal(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, services.SealStatus{State: services.StateSealed}, status)
+
+	shares, err := shamir.Split([]byte(sampleMasterSeed), 3, 2)
+	assert.NoError(t, err)
+	_, err = service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.NoError(t, err)
+	status, err = service.SubmitUnsealShare(ctx, shares[2].String())
+	assert.NoError(t, err)
+	assert.Equal(t, services.StateUnsealed, status.State)
+
+	_, err = service.ResetUnseal(ctx)
+	assert.Equal(t, apperrors.ErrNotSealed, err)
+}
+
+func TestSeal(t *testing.T) {
+	ctx := context.Background()
+	keyManager, err := kms.NewLocalKeyManager(kms.Key{Material: sampleEncryptionKey})
+	assert.NoError(t, err)
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	masterSeed := []byte(sampleMasterSeed)
+	service := services.NewKeyGenService(repo, masterSeed, keyManager)
+	service.EnablePrivateKeyReveal()
+	assert.Equal(t, services.StateUnsealed, service.State())
+
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+	before, err := exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+
+	service.Seal(context.Background())
+	assert.Equal(t, services.StateSealed, service.State())
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrSealed, err)
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrSealed, err)
+	_, err = service.ReencryptAll(ctx, nil)
+	assert.Equal(t, apperrors.ErrSealed, err)
+
+	// Seed and key material are wiped
+	assert.Equal(t, make([]byte, len(sampleMasterSeed)), masterSeed)
+	_, _, err = keyManager.Wrap(ctx, []byte("data key"), nil)
+	assert.ErrorIs(t, err, kms.ErrWiped)
+
+	// Sealing twice is harmless
+	service.Seal(context.Background())
+
+	// Unsealing needs a way to load the key manager again
+	shares, err := shamir.Split([]byte(sampleMasterSeed), 2, 2)
+	assert.NoError(t, err)
+	_, err = service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.NoError(t, err)
+	status, err := service.SubmitUnsealShare(ctx, shares[1].String())
+	assert.Error(t, err)
+	assert.Equal(t, services.StateSealed, status.State)
+
+	service.SetKeyManagerLoader(func() (kms.KeyManager, error) {
+		return kms.NewLocalKeyManager(kms.Key{Material: sampleEncryptionKey})
+	})
+	assert.NoError(t, service.Unseal(ctx, []byte(sampleMasterSeed)))
+	assert.Equal(t, services.StateUnsealed, service.State())
+
+	after, err := exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, apperrors.ErrNotSealed, service.Unseal(ctx, []byte(sampleMasterSeed)))
+}
+
+func TestSealKeepsSeedCheck(t *testing.T) {
+	ctx := context.Background()
+	// As at startup, the check value is taken before Seal wipes the seed
+	masterSeed := []byte(sampleMasterSeed)
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), masterSeed, newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetSeedCheck(shamir.CheckValue(masterSeed))
+	service.SetKeyManagerLoader(func() (kms.KeyManager, error) {
+		return kms.NewLocalKeyManager(kms.Key{Material: sampleEncryptionKey})
+	})
+	service.Seal(ctx)
+
+	// Shares of another seed do not unseal the service
+	other, err := shamir.Split([]byte("other-master-seed"), 2, 2)
+	assert.NoError(t, err)
+	_, err = service.SubmitUnsealShare(ctx, other[0].String())
+	assert.NoError(t, err)
+	status, err := service.SubmitUnsealShare(ctx, other[1].String())
+	assert.Equal(t, apperrors.ErrUnsealFailed, err)
+	assert.Equal(t, services.StateSealed, status.State)
+
+	shares, err := shamir.Split([]byte(sampleMasterSeed), 2, 2)
+	assert.NoError(t, err)
+	_, err = service.SubmitUnsealShare(ctx, shares[0].String())
+	assert.NoError(t, err)
+	status, err = service.SubmitUnsealShare(ctx, shares[1].String())
+	assert.NoError(t, err)
+	assert.Equal(t, services.StateUnsealed, status.State)
+}
+
+// failingAuditSink is an empty log that rejects every entry.
+type failingAuditSink struct{}
+
+func (failingAuditSink) Append(ctx context.Context, entry audit.Entry) error {
+	return errors.New("audit sink unavailable")
+}
+
+func (failingAuditSink) Last(ctx context.Context) (audit.Entry, bool, error) {
+	return audit.Entry{}, false, nil
+}
+
+func (failingAuditSink) Head(ctx context.Context) (audit.Anchor, bool, error) {
+	return audit.Anchor{}, false, nil
+}
+
+func (failingAuditSink) ForEach(ctx context.Context, fn func(audit.Entry) error) error {
+	return nil
+}
+
+func TestAuditLog(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	ctx := audit.WithActor(context.Background(), "ip:10.0.0.1")
+
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRevealDisabled, err)
+	service.EnablePrivateKeyReveal()
+	_, err = exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.GetAccountKey(ctx, "dogecoin")
+	assert.Equal(t, apperrors.ErrUnsupportedNetwork, err)
+	service.Seal(ctx)
+
+	var entries []audit.Entry
+	assert.NoError(t, sink.ForEach(ctx, func(entry audit.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	expected := []struct {
+		action  audit.Action
+		outcome audit.Outcome
+	}{
+		{audit.ActionGenerate, audit.OutcomeSuccess},
+		{audit.ActionRetrieve, audit.OutcomeSuccess},
+		{audit.ActionExportPrivateKey, audit.OutcomeDenied},
+		{audit.ActionExportPrivateKey, audit.OutcomeSuccess},
+		{audit.ActionExportAccountKey, audit.OutcomeFailure},
+		{audit.ActionSeal, audit.OutcomeSuccess},
+	}
+	if assert.Len(t, entries, len(expected)) {
+		for i, e := range expected {
+			assert.Equal(t, e.action, entries[i].Action)
+			assert.Equal(t, e.outcome, entries[i].Outcome)
+			assert.Equal(t, "ip:10.0.0.1", entries[i].Actor)
+		}
+		assert.Equal(t, 1, entries[0].UserID)
+		assert.Equal(t, "ethereum", entries[0].Network)
+		assert.Equal(t, apperrors.ErrUnsupportedNetwork.Message, entries[4].Detail)
+	}
+	_, err = audit.Verify(ctx, sink)
+	assert.NoError(t, err)
+}
+
+func TestExportFailsWithoutAuditEntry(t *testing.T) {
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.EnablePrivateKeyReveal()
+	service.SetAuditLogger(audit.NewLogger(failingAuditSink{}))
+
+	// Other operations do not depend on the audit log
+	_, err := service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+
+	privateKey, err := exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrInternalServerError, err)
+	assert.Empty(t, privateKey)
+}
+
+func TestAPIClients(t *testing.T) {
+	const rotatedEncryptionKey = "qF3tHDT0VvDOFJVbHa3AZ4vxW2KDwVblQkGAq0pfUEs="
+	ctx := context.Background()
+	oldKey := kms.Key{ID: "v1", Material: sampleEncryptionKey}
+
+	inMemoryDB := NewInMemoryDatabase()
+	repo := repositories.NewKeyGenRepository(inMemoryDB)
+	// The master seed is not needed
+	service := services.NewKeyGenService(repo, nil, newKeyManager(t, oldKey))
+
+	_, _, err := service.CreateAPIClient(ctx, "billing", []string{"keys:everything"})
+	assert.Equal(t, apperrors.ErrInvalidScope, err)
+
+	client, secret, err := service.CreateAPIClient(ctx, "billing", []string{auth.ScopeReadPublic})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, secret)
+	assert.NotContains(t, inMemoryDB.apiClients[client.KeyID].EncryptedSecret, secret)
+
+	resolved, scopes, err := service.APIClientCredentials(ctx, client.KeyID)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, string(resolved))
+	assert.Equal(t, []string{auth.ScopeReadPublic}, scopes)
+	_, _, err = service.APIClientCredentials(ctx, "ak_unknown")
+	assert.Equal(t, apperrors.ErrUnauthorized, err)
+
+	// A secret moved to another client does not decrypt
+	other, _, err := service.CreateAPIClient(ctx, "other", nil)
+	assert.NoError(t, err)
+	swapped := inMemoryDB.apiClients[other.KeyID]
+	swapped.EncryptedSecret = client.EncryptedSecret
+	swapped.WrappedDataKey = client.WrappedDataKey
+	inMemoryDB.apiClients[other.KeyID] = swapped
+	_, _, err = service.APIClientCredentials(ctx, other.KeyID)
+	assert.Equal(t, apperrors.ErrRecordIntegrity, err)
+
+	// Secrets are re-encrypted with the private keys
+	service = services.NewKeyGenService(repo, nil, newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}, oldKey))
+	progress, err := service.ReencryptAll(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, services.ReencryptionProgress{Total: 2, Processed: 2, Reencrypted: 1, Failed: 1}, progress)
+	service = services.NewKeyGenService(repo, nil, newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}))
+	resolved, _, err = service.APIClientCredentials(ctx, client.KeyID)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, string(resolved))
+
+	assert.NoError(t, service.RevokeAPIClient(ctx, client.KeyID))
+	_, _, err = service.APIClientCredentials(ctx, client.KeyID)
+	assert.Equal(t, apperrors.ErrUnauthorized, err)
+	assert.Equal(t, apperrors.ErrAPIClientNotFound, service.RevokeAPIClient(ctx, "ak_unknown"))
+
+	// Sealing wipes the key manager, clients cannot be resolved until unsealed
+	service.Seal(ctx)
+	_, _, err = service.APIClientCredentials(ctx, other.KeyID)
+	assert.Equal(t, apperrors.ErrSealed, err)
+}
+
+func TestScopes(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	service.EnablePrivateKeyReveal()
+	reader := auth.WithPrincipal(context.Background(), auth.Principal{ID: "reader", Method: auth.MethodJWT, Scopes: []string{auth.ScopeReadPublic}})
+	generator := auth.WithPrincipal(context.Background(), auth.Principal{ID: "generator", Method: auth.MethodJWT, Scopes: []string{auth.ScopeReadPublic, auth.ScopeGenerate}})
+
+	// Reading keys that do not exist yet requires keys:generate
+	_, err = service.GetKeysAndAddress(reader, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	_, err = service.GetKeysAndAddress(generator, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.GetKeysAndAddress(reader, 1, "ethereum")
+	assert.NoError(t, err)
+
+	_, err = exportedPrivateKey(t, service, generator, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	_, err = service.RegisterWatchOnlyAccount(reader, "acme", "bitcoin", "xpub", "")
+	assert.Equal(t, apperrors.ErrForbidden, err)
+
+	// Calls without a principal are not restricted
+	_, err = exportedPrivateKey(t, service, context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+
+	var outcomes []audit.Outcome
+	assert.NoError(t, sink.ForEach(context.Background(), func(entry audit.Entry) error {
+		outcomes = append(outcomes, entry.Outcome)
+		return nil
+	}))
+	assert.Equal(t, []audit.Outcome{audit.OutcomeDenied, audit.OutcomeSuccess, audit.OutcomeSuccess, audit.OutcomeDenied, audit.OutcomeDenied, audit.OutcomeSuccess}, outcomes)
+}
+
+func TestGenerateRestrictedByPolicy(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	_, err = service.GetKeysAndAddress(context.Background(), 1, "ethereum")
+	assert.NoError(t, err)
+
+	denied := &apperrors.PolicyError{Action: auth.ScopeGenerate, Network: "ethereum", UserID: 2, Reason: "user_id_not_allowed"}
+	ctx := auth.Restrict(context.Background(), auth.ScopeGenerate, denied)
+	// Existing keys can still be read
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.GetKeysAndAddress(ctx, 2, "ethereum")
+	assert.Equal(t, denied, err)
+
+	last, _, err := sink.Last(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, audit.ActionGenerate, last.Action)
+	assert.Equal(t, audit.OutcomeDenied, last.Outcome)
+	assert.Equal(t, denied.Error(), last.Detail)
+}
+
+// quotaOf allows n generations.
+type quotaOf int
+
+func (q *quotaOf) AllowGeneration(ctx context.Context) error {
+	if *q == 0 {
+		return &apperrors.RateLimitError{Limit: "generation", RetryAfter: time.Minute}
+	}
+	*q--
+	return nil
+}
+
+func TestGenerationQuota(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	quota := quotaOf(1)
+	service.SetGenerationQuota(&quota)
+
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.GetKeysAndAddress(ctx, 2, "ethereum")
+	assert.Equal(t, &apperrors.RateLimitError{Limit: "generation", RetryAfter: time.Minute}, err)
+	// Existing keys are not limited
+	_, err = service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+
+	var outcomes []audit.Outcome
+	assert.NoError(t, sink.ForEach(ctx, func(entry audit.Entry) error {
+		outcomes = append(outcomes, entry.Outcome)
+		return nil
+	}))
+	assert.Equal(t, []audit.Outcome{audit.OutcomeSuccess, audit.OutcomeDenied, audit.OutcomeSuccess}, outcomes)
+}
+
+func TestRevealQuorum(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	inMemoryDB := NewInMemoryDatabase()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(inMemoryDB), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	service.EnablePrivateKeyReveal()
+	principal := func(id string, scopes ...string) context.Context {
+		ctx := auth.WithPrincipal(context.Background(), auth.Principal{ID: id, Method: auth.MethodJWT, Scopes: scopes})
+		return audit.WithActor(ctx, "jwt:"+id)
+	}
+	requester := principal("requester", auth.ScopeRevealPrivate, auth.ScopeGenerate, auth.ScopeReadPublic)
+	alice, bob, carol := principal("alice", auth.ScopeRevealPrivate), principal("bob", auth.ScopeRevealPrivate), principal("carol", auth.ScopeRevealPrivate)
+	outsider := principal("outsider", auth.ScopeRevealPrivate)
+
+	_, err = service.GetKeysAndAddress(requester, 1, "ethereum")
+	assert.NoError(t, err)
+
+	_, err = service.OpenRevealRequest(requester, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRevealQuorumDisabled, err)
+	assert.Error(t, service.EnableRevealQuorum(services.RevealQuorum{Approvers: []string{"jwt:alice"}, Required: 2, RequestTTL: time.Hour, FetchTTL: time.Minute}))
+	assert.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{
+		Approvers:  []string{"jwt:alice", "jwt:bob", "jwt:carol", "jwt:requester"},
+		Required:   2,
+		RequestTTL: time.Hour,
+		FetchTTL:   time.Minute,
+	}))
+
+	// Keys are only revealed through approved requests
+	_, err = exportedPrivateKey(t, service, requester, 1, "ethereum")
+	assert.Equal(t, apperrors.ErrRevealApprovalRequired, err)
+	_, err = service.OpenRevealRequest(principal("dave"), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	_, err = service.OpenRevealRequest(context.Background(), 1, "ethereum")
+	assert.Equal(t, apperrors.ErrUnauthorized, err)
+	_, err = service.OpenRevealRequest(requester, 2, "ethereum")
+	assert.Equal(t, apperrors.ErrKeyNotFound, err)
+
+	request, err := service.OpenRevealRequest(requester, 1, "ethereum")
+	assert.NoError(t, err)
+	assert.Equal(t, dbi.RevealPending, request.State)
+	assert.Equal(t, "jwt:requester", request.Requester)
+
+	// Callers taking no part in a request cannot tell it exists
+	_, err = service.GetRevealRequest(outsider, request.ID)
+	assert.Equal(t, apperrors.ErrRevealRequestNotFound, err)
+	_, err = service.GetRevealRequest(alice, "rr_unknown")
+	assert.Equal(t, apperrors.ErrRevealRequestNotFound, err)
+
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Equal(t, apperrors.ErrRevealNotApproved, err)
+	_, err = service.ApproveRevealRequest(outsider, request.ID)
+	assert.Equal(t, apperrors.ErrNotRevealApprover, err)
+	// Being an approver does not replace the scope
+	_, err = service.GetRevealRequest(principal("bob"), request.ID)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	_, err = service.ApproveRevealRequest(principal("bob"), request.ID)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	_, err = service.ApproveRevealRequest(requester, request.ID)
+	assert.Equal(t, apperrors.ErrSelfApproval, err)
+
+	request, err = service.ApproveRevealRequest(alice, request.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dbi.RevealPending, request.State)
+	_, err = service.ApproveRevealRequest(alice, request.ID)
+	assert.Equal(t, apperrors.ErrAlreadyApproved, err)
+	request, err = service.ApproveRevealRequest(bob, request.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dbi.RevealApproved, request.State)
+	assert.Len(t, request.Approvals, 2)
+	_, err = service.ApproveRevealRequest(carol, request.ID)
+	assert.Equal(t, apperrors.ErrAlreadyApproved, err)
+
+	// Only the requester fetches the key, and only once
+	_, err = fetchedPrivateKey(t, service, alice, request.ID)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	revealed, err := fetchedPrivateKey(t, service, requester, request.ID)
+	assert.NoError(t, err)
+	expected, err := (&ethereum.EthereumKeyGen{MasterSeed: []byte(sampleMasterSeed)}).GenerateKeyPairAndAddress(1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.PrivateKey, revealed)
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Equal(t, apperrors.ErrRevealAlreadyFetched, err)
+	assert.Equal(t, dbi.RevealFetched, inMemoryDB.revealRequests[request.ID].State)
+
+	// Requests expire while pending and once the fetch window closes
+	assert.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{
+		Approvers:  []string{"jwt:alice"},
+		Required:   1,
+		RequestTTL: time.Hour,
+		FetchTTL:   time.Millisecond,
+	}))
+	request, err = service.OpenRevealRequest(requester, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.ApproveRevealRequest(alice, request.ID)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Equal(t, apperrors.ErrRevealRequestExpired, err)
+	assert.Equal(t, dbi.RevealExpired, inMemoryDB.revealRequests[request.ID].State)
+
+	assert.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{
+		Approvers:  []string{"jwt:alice"},
+		Required:   1,
+		RequestTTL: time.Millisecond,
+		FetchTTL:   time.Minute,
+	}))
+	expiring, err := service.OpenRevealRequest(requester, 1, "ethereum")
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = service.ApproveRevealRequest(alice, expiring.ID)
+	assert.Equal(t, apperrors.ErrRevealRequestExpired, err)
+
+	var fetches, expiries int
+	assert.NoError(t, sink.ForEach(context.Background(), func(entry audit.Entry) error {
+		switch {
+		case entry.Action == audit.ActionExportPrivateKey && entry.Outcome == audit.OutcomeSuccess:
+			fetches++
+			assert.Equal(t, "jwt:requester", entry.Actor)
+			assert.Equal(t, 1, entry.UserID)
+		case entry.Action == audit.ActionRevealExpire:
+			expiries++
+		}
+		return nil
+	}))
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, 2, expiries)
+}
+
+func TestFailedFetchKeepsRevealRequest(t *testing.T) {
+	inMemoryDB := NewInMemoryDatabase()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(inMemoryDB), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.EnablePrivateKeyReveal()
+	assert.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{Approvers: []string{"jwt:alice"}, Required: 1, RequestTTL: time.Hour, FetchTTL: time.Minute}))
+	requester := auth.WithPrincipal(context.Background(), auth.Principal{ID: "requester", Method: auth.MethodJWT, Scopes: []string{auth.ScopeGenerate, auth.ScopeReadPublic, auth.ScopeRevealPrivate}})
+	alice := auth.WithPrincipal(context.Background(), auth.Principal{ID: "alice", Method: auth.MethodJWT, Scopes: []string{auth.ScopeRevealPrivate}})
+
+	_, err := service.GetKeysAndAddress(requester, 1, "ethereum")
+	assert.NoError(t, err)
+	request, err := service.OpenRevealRequest(requester, 1, "ethereum")
+	assert.NoError(t, err)
+	_, err = service.ApproveRevealRequest(alice, request.ID)
+	assert.NoError(t, err)
+
+	// The stored key does not decrypt
+	keyData := inMemoryDB.data[1]["ethereum"]
+	stored := keyData
+	keyData.EncryptedPrivateKey = inMemoryDB.data[1]["ethereum"].EncryptedPrivateKey[:8]
+	inMemoryDB.data[1]["ethereum"] = keyData
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Error(t, err)
+	assert.Equal(t, dbi.RevealApproved, inMemoryDB.revealRequests[request.ID].State)
+	inMemoryDB.data[1]["ethereum"] = stored
+
+	// The reveal cannot be audited
+	service.SetAuditLogger(audit.NewLogger(failingAuditSink{}))
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Equal(t, apperrors.ErrInternalServerError, err)
+	assert.Equal(t, dbi.RevealApproved, inMemoryDB.revealRequests[request.ID].State)
+
+	service.SetAuditLogger(nil)
+	revealed, err := fetchedPrivateKey(t, service, requester, request.ID)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, revealed)
+	assert.Equal(t, dbi.RevealFetched, inMemoryDB.revealRequests[request.ID].State)
+}
+
+func TestExportPrivateKey(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	toAge := services.ExportOptions{Format: encryption.FormatAge, Recipient: identity.Recipient().String()}
+
+	public, err := service.GetKeysAndAddress(ctx, 1, "bitcoin-testnet")
+	assert.NoError(t, err)
+	_, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", toAge)
+	assert.Equal(t, apperrors.ErrRevealDisabled, err)
+
+	service.EnablePrivateKeyReveal()
+	derived, err := (&bitcoin.BitcoinKeyGen{MasterSeed: []byte(sampleMasterSeed), Params: bitcoin.Networks["testnet"]}).GenerateKeyPairAndAddress(1)
+	assert.NoError(t, err)
+
+	exported, err := service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", toAge)
+	assert.NoError(t, err)
+	assert.Equal(t, public, exported.KeyPairAndAddress)
+	assert.NotContains(t, exported.EncryptedPrivateKey, derived.PrivateKey)
+	decrypted, err := encryption.AgeDecrypt(exported.EncryptedPrivateKey, identity.String(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, derived.PrivateKey, string(decrypted))
+
+	exported, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", services.ExportOptions{Format: bitcoin.FormatBIP38, Passphrase: "passphrase"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(exported.EncryptedPrivateKey, "6P"))
+	assert.Empty(t, exported.PrivateKey)
+
+	// Options are checked before any key is decrypted
+	for _, options := range []services.ExportOptions{
+		{Format: ethereum.FormatKeystore, Passphrase: "passphrase"},
+		{Format: "pem", Passphrase: "passphrase"},
+	} {
+		_, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", options)
+		assert.Equal(t, apperrors.ErrExportFormat, err)
+	}
+	_, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", services.ExportOptions{Format: bitcoin.FormatBIP38})
+	assert.Equal(t, apperrors.ErrPassphraseRequired, err)
+	_, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", services.ExportOptions{Format: encryption.FormatAge, Recipient: "age1invalid"})
+	assert.Equal(t, apperrors.ErrInvalidRecipient, err)
+	_, err = service.ExportPrivateKey(ctx, 2, "bitcoin-testnet", toAge)
+	assert.Equal(t, apperrors.ErrKeyNotFound, err)
+
+	// Exports need the reveal scope and, with a quorum, an approved request
+	reader := auth.WithPrincipal(ctx, auth.Principal{ID: "reader", Method: auth.MethodJWT, Scopes: []string{auth.ScopeReadPublic}})
+	_, err = service.ExportPrivateKey(reader, 1, "bitcoin-testnet", toAge)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+
+	assert.NoError(t, service.EnableRevealQuorum(services.RevealQuorum{Approvers: []string{"jwt:alice"}, Required: 1, RequestTTL: time.Hour, FetchTTL: time.Minute}))
+	_, err = service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", toAge)
+	assert.Equal(t, apperrors.ErrRevealApprovalRequired, err)
+	requester := auth.WithPrincipal(ctx, auth.Principal{ID: "requester", Method: auth.MethodJWT, Scopes: []string{auth.ScopeRevealPrivate}})
+	alice := auth.WithPrincipal(ctx, auth.Principal{ID: "alice", Method: auth.MethodJWT, Scopes: []string{auth.ScopeRevealPrivate}})
+	request, err := service.OpenRevealRequest(requester, 1, "bitcoin-testnet")
+	assert.NoError(t, err)
+	_, err = service.ApproveRevealRequest(alice, request.ID)
+	assert.NoError(t, err)
+
+	// Invalid options do not use up the request
+	_, err = service.FetchExportedKey(requester, request.ID, services.ExportOptions{Format: ethereum.FormatKeystore, Passphrase: "passphrase"})
+	assert.Equal(t, apperrors.ErrExportFormat, err)
+	exported, err = service.FetchExportedKey(requester, request.ID, toAge)
+	assert.NoError(t, err)
+	decrypted, err = encryption.AgeDecrypt(exported.EncryptedPrivateKey, identity.String(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, derived.PrivateKey, string(decrypted))
+	_, err = fetchedPrivateKey(t, service, requester, request.ID)
+	assert.Equal(t, apperrors.ErrRevealAlreadyFetched, err)
+
+	var exports int
+	assert.NoError(t, sink.ForEach(ctx, func(entry audit.Entry) error {
+		if entry.Action == audit.ActionExportPrivateKey && entry.Outcome == audit.OutcomeSuccess {
+			exports++
+		}
+		return nil
+	}))
+	assert.Equal(t, 3, exports)
+}
+
+func TestPassphraseExportsAreBounded(t *testing.T) {
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.EnablePrivateKeyReveal()
+	_, err := service.GetKeysAndAddress(ctx, 1, "ethereum")
+	assert.NoError(t, err)
+	limited := &apperrors.RateLimitError{Limit: "passphrase_kdf", RetryAfter: time.Second}
+
+	// Exports under a passphrase fail at once while every slot is taken
+	release := service.HoldKDFSlots()
+	for _, options := range []services.ExportOptions{
+		{Format: encryption.FormatAge, Passphrase: "passphrase"},
+		{Format: ethereum.FormatKeystore, Passphrase: "passphrase"},
+	} {
+		_, err = service.ExportPrivateKey(ctx, 1, "ethereum", options)
+		assert.Equal(t, limited, err, options.Format)
+	}
+	// Exports to a recipient derive no key from a passphrase
+	_, err = exportedPrivateKey(t, service, ctx, 1, "ethereum")
+	assert.NoError(t, err)
+
+	release()
+	_, err = service.ExportPrivateKey(ctx, 1, "ethereum", services.ExportOptions{Format: encryption.FormatAge, Passphrase: "passphrase"})
+	assert.NoError(t, err)
+}
+
+func TestPassphraseImportsAreBounded(t *testing.T) {
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.EnablePrivateKeyReveal()
+	_, err := service.GetKeysAndAddress(ctx, 1, "bitcoin-testnet")
+	assert.NoError(t, err)
+	exported, err := service.ExportPrivateKey(ctx, 1, "bitcoin-testnet", services.ExportOptions{Format: bitcoin.FormatBIP38, Passphrase: "passphrase"})
+	assert.NoError(t, err)
+	encrypted := services.KeyImport{PrivateKey: exported.EncryptedPrivateKey, Passphrase: "passphrase", Address: exported.Address}
+
+	// Encrypted keys fail at once while every slot is taken
+	release := service.HoldKDFSlots()
+	_, err = service.ImportKey(ctx, 2, "bitcoin-testnet", encrypted)
+	assert.Equal(t, &apperrors.RateLimitError{Limit: "passphrase_kdf", RetryAfter: time.Second}, err)
+	// Plain keys derive no key from a passphrase
+	plain, err := exportedPrivateKey(t, service, ctx, 1, "bitcoin-testnet")
+	assert.NoError(t, err)
+	_, err = service.ImportKey(ctx, 3, "bitcoin-testnet", services.KeyImport{PrivateKey: plain, Address: exported.Address})
+	assert.NoError(t, err)
+
+	release()
+	imported, err := service.ImportKey(ctx, 2, "bitcoin-testnet", encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, exported.Address, imported.Address)
+}
+
+func TestImportKey(t *testing.T) {
+	ctx := context.Background()
+	inMemoryDB := NewInMemoryDatabase()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	external := &bitcoin.BitcoinKeyGen{MasterSeed: []byte("another-master-seed"), AddressType: bitcoin.P2WPKH, Params: bitcoin.Networks["testnet"]}
+	keyPair, err := external.GenerateKeyPairAndAddress(7)
+	assert.NoError(t, err)
+	input := services.KeyImport{PrivateKey: keyPair.PrivateKey, Address: keyPair.Address}
+
+	// Imports work while the service waits for its master seed
+	sealed := services.NewKeyGenService(repositories.NewKeyGenRepository(inMemoryDB), nil, keyManager)
+	imported, err := sealed.ImportKey(ctx, 1, "bitcoin-testnet-p2wpkh", input)
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.Address, imported.Address)
+	assert.True(t, imported.Imported)
+	assert.Empty(t, imported.PrivateKey)
+	stored, err := inMemoryDB.GetKey(ctx, 1, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, dbi.ProvenanceImported, stored.Provenance)
+	assert.NotContains(t, stored.EncryptedPrivateKey, keyPair.PrivateKey)
+
+	_, err = sealed.ImportKey(ctx, 1, "bitcoin-testnet-p2wpkh", input)
+	assert.Equal(t, apperrors.ErrKeyExists, err)
+
+	// The imported key is served instead of a derived one
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(inMemoryDB), []byte(sampleMasterSeed), keyManager)
+	service.EnablePrivateKeyReveal()
+	public, err := service.GetKeysAndAddress(ctx, 1, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, imported, public)
+	revealed, err := exportedPrivateKey(t, service, ctx, 1, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.PrivateKey, revealed)
+	derived, err := service.GetKeysAndAddress(ctx, 2, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.False(t, derived.Imported)
+
+	// Networks registered on the service are imported with its generators
+	service.RegisterGenerator("bitcoin-vault", &bitcoin.BitcoinKeyGen{AddressType: bitcoin.P2WPKH, Params: bitcoin.Networks["testnet"]})
+	_, err = service.ImportKey(ctx, 1, "bitcoin-vault", input)
+	assert.NoError(t, err)
+	exists, err := inMemoryDB.KeyExists(ctx, 1, "bitcoin-vault")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	_, err = sealed.ImportKey(ctx, 1, "bitcoin-vault", input)
+	assert.Equal(t, apperrors.ErrUnsupportedNetwork, err)
+
+	// The address is derived with the address type of the network, aliases
+	// of the default address type share the record of the network
+	_, err = sealed.ImportKey(ctx, 2, "bitcoin-testnet-p2pkh", input)
+	assert.Equal(t, apperrors.ErrAddressMismatch, err)
+	publicKey, err := hex.DecodeString(keyPair.PublicKey)
+	assert.NoError(t, err)
+	p2pkh, err := (&bitcoin.BitcoinKeyGen{AddressType: bitcoin.P2PKH, Params: bitcoin.Networks["testnet"]}).DescribePublicKey(publicKey)
+	assert.NoError(t, err)
+	_, err = sealed.ImportKey(ctx, 2, "bitcoin-testnet-p2pkh", services.KeyImport{PrivateKey: keyPair.PrivateKey, Address: p2pkh.Address})
+	assert.NoError(t, err)
+	exists, err = inMemoryDB.KeyExists(ctx, 2, "bitcoin-testnet")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Ethereum addresses match regardless of their checksum casing
+	ethereumKey := &ethereum.EthereumKeyGen{MasterSeed: []byte("another-master-seed")}
+	ethereumPair, err := ethereumKey.GenerateKeyPairAndAddress(7)
+	assert.NoError(t, err)
+	_, err = sealed.ImportKey(ctx, 1, "ethereum", services.KeyImport{PrivateKey: ethereumPair.PrivateKey, Address: strings.ToLower(ethereumPair.Address)})
+	assert.NoError(t, err)
+
+	_, err = sealed.ImportKey(ctx, 3, "bitcoin", input)
+	assert.Error(t, err)
+	_, err = sealed.ImportKey(ctx, 3, "dogecoin", input)
+	assert.Equal(t, apperrors.ErrUnsupportedNetwork, err)
+	sealed.DisableMainnet()
+	_, err = sealed.ImportKey(ctx, 3, "ethereum", services.KeyImport{PrivateKey: ethereumPair.PrivateKey, Address: ethereumPair.Address})
+	assert.Equal(t, apperrors.ErrMainnetDisabled, err)
+
+	reader := auth.WithPrincipal(ctx, auth.Principal{ID: "reader", Method: auth.MethodJWT, Scopes: []string{auth.ScopeGenerate, auth.ScopeReadPublic}})
+	_, err = sealed.ImportKey(reader, 3, "bitcoin-testnet-p2wpkh", input)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+}
+
+// racingDatabase stores the record of another request right after a key was
+// checked to be missing.
+type racingDatabase struct {
+	*InMemoryDatabase
+	record dbi.KeyData
+}
+
+func (db *racingDatabase) KeyExists(ctx context.Context, userID int, network string) (bool, error) {
+	exists, err := db.InMemoryDatabase.KeyExists(ctx, userID, network)
+	if err == nil && !exists {
+		err = db.InMemoryDatabase.InsertKey(ctx, db.record)
+	}
+	return exists, err
+}
+
+func TestSaveKeyKeepsConcurrentRecord(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	racing := &racingDatabase{InMemoryDatabase: NewInMemoryDatabase()}
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(racing), []byte(sampleMasterSeed), keyManager)
+	external := &bitcoin.BitcoinKeyGen{MasterSeed: []byte("another-master-seed"), AddressType: bitcoin.P2WPKH, Params: bitcoin.Networks["testnet"]}
+	keyPair, err := external.GenerateKeyPairAndAddress(7)
+	assert.NoError(t, err)
+
+	// A derived record created meanwhile is not replaced by an import
+	derived, err := (&bitcoin.BitcoinKeyGen{MasterSeed: []byte(sampleMasterSeed), AddressType: bitcoin.P2WPKH, Params: bitcoin.Networks["testnet"]}).GenerateKeyPairAndAddress(1)
+	assert.NoError(t, err)
+	racing.record = dbi.KeyData{UserID: 1, Network: "bitcoin-testnet-p2wpkh", Address: derived.Address, Provenance: dbi.ProvenanceDerived}
+	_, err = service.ImportKey(ctx, 1, "bitcoin-testnet-p2wpkh", services.KeyImport{PrivateKey: keyPair.PrivateKey, Address: keyPair.Address})
+	assert.Equal(t, apperrors.ErrKeyExists, err)
+	stored, err := racing.GetKey(ctx, 1, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, racing.record, stored)
+
+	// An imported record created meanwhile is served instead of a derived one
+	racing.record = dbi.KeyData{UserID: 2, Network: "bitcoin-testnet-p2wpkh", Address: keyPair.Address, Provenance: dbi.ProvenanceImported}
+	public, err := service.GetKeysAndAddress(ctx, 2, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, keyPair.Address, public.Address)
+	assert.True(t, public.Imported)
+	stored, err = racing.GetKey(ctx, 2, "bitcoin-testnet-p2wpkh")
+	assert.NoError(t, err)
+	assert.Equal(t, racing.record, stored)
+}
+
+func TestSignMessage(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	message := []byte("Prove ownership: 8f14e45f")
+
+	_, err = service.SignMessage(ctx, 1, "ethereum-sepolia", message)
+	assert.Equal(t, apperrors.ErrKeyNotFound, err)
+
+	for _, network := range []string{"ethereum-sepolia", "bitcoin-testnet-p2wpkh", "bitcoin-testnet-p2tr"} {
+		public, err := service.GetKeysAndAddress(ctx, 1, network)
+		assert.NoError(t, err)
+		signed, err := service.SignMessage(ctx, 1, network, message)
+		assert.NoError(t, err)
+		assert.Equal(t, public.Address, signed.Address)
+		assert.NotEmpty(t, signed.Signature)
+
+		valid, err := service.VerifyMessage(ctx, network, public.Address, message, signed.Signature)
+		assert.NoError(t, err)
+		assert.True(t, valid, network)
+		valid, err = service.VerifyMessage(ctx, network, public.Address, []byte("another message"), signed.Signature)
+		assert.NoError(t, err)
+		assert.False(t, valid, network)
+	}
+
+	// Signing needs keys:sign, verifying keys:read_public
+	reader := auth.WithPrincipal(ctx, auth.Principal{ID: "reader", Method: auth.MethodJWT, Scopes: []string{auth.ScopeReadPublic}})
+	_, err = service.SignMessage(reader, 1, "ethereum-sepolia", message)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+	signer := auth.WithPrincipal(ctx, auth.Principal{ID: "signer", Method: auth.MethodJWT, Scopes: []string{auth.ScopeSign}})
+	signed, err := service.SignMessage(signer, 1, "ethereum-sepolia", message)
+	assert.NoError(t, err)
+	_, err = service.VerifyMessage(signer, "ethereum-sepolia", signed.Address, message, signed.Signature)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+
+	service.DisableMainnet()
+	_, err = service.SignMessage(ctx, 1, "ethereum", message)
+	assert.Equal(t, apperrors.ErrMainnetDisabled, err)
+	_, err = service.SignMessage(ctx, 1, "dogecoin", message)
+	assert.Equal(t, apperrors.ErrUnsupportedNetwork, err)
+
+	// The audit log records what was signed, not the message
+	var digests []string
+	assert.NoError(t, sink.ForEach(ctx, func(entry audit.Entry) error {
+		if entry.Action == audit.ActionSignMessage && entry.Outcome == audit.OutcomeSuccess {
+			digests = append(digests, entry.Detail)
+		}
+		return nil
+	}))
+	assert.Len(t, digests, 4)
+	digest := sha256.Sum256(message)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(digest[:]), digests[0])
+}
+
+func TestSignTypedData(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	ctx := context.Background()
+	service := services.NewKeyGenService(repositories.NewKeyGenRepository(NewInMemoryDatabase()), []byte(sampleMasterSeed), newKeyManager(t, kms.Key{Material: sampleEncryptionKey}))
+	service.SetAuditLogger(audit.NewLogger(sink))
+	permit := `{
+		"types": {
+			"EIP712Domain": [{"name": "name", "type": "string"}, {"name": "chainId", "type": "uint256"}],
+			"Permit": [{"name": "spender", "type": "address"}, {"name": "value", "type": "uint256"}]
+		},
+		"primaryType": "Permit",
+		"domain": {"name": "Token", "chainId": %d},
+		"message": {"spender": "0x0000000000000000000000000000000000000001", "value": "1000000000000000000"}
+	}`
+	typedData := []byte(fmt.Sprintf(permit, ethereum.Sepolia.ID))
+
+	public, err := service.GetKeysAndAddress(ctx, 1, "ethereum-sepolia")
+	assert.NoError(t, err)
+	signed, err := service.SignTypedData(ctx, 1, "ethereum-sepolia", typedData)
+	assert.NoError(t, err)
+	assert.Equal(t, public.Address, signed.Address)
+	assert.Equal(t, ethereum.SchemeEIP712, signed.Scheme)
+	digest, err := (&ethereum.EthereumKeyGen{Chain: ethereum.Sepolia}).HashTypedData(typedData)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x"+hex.EncodeToString(digest), signed.Digest)
+
+	// Typed data of another chain is refused
+	_, err = service.SignTypedData(ctx, 1, "ethereum-sepolia", []byte(fmt.Sprintf(permit, ethereum.Mainnet.ID)))
+	var apiErr *apperrors.KeyGenError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 400, apiErr.Code)
+
+	_, err = service.GetKeysAndAddress(ctx, 1, "bitcoin-testnet")
+	assert.NoError(t, err)
+	_, err = service.SignTypedData(ctx, 1, "bitcoin-testnet", typedData)
+	assert.Equal(t, apperrors.ErrSigningUnsupported, err)
+	_, err = service.SignTypedData(ctx, 2, "ethereum-sepolia", typedData)
+	assert.Equal(t, apperrors.ErrKeyNotFound, err)
+	reader := auth.WithPrincipal(ctx, auth.Principal{ID: "reader", Method: auth.MethodJWT, Scopes: []string{auth.ScopeReadPublic}})
+	_, err = service.SignTypedData(reader, 1, "ethereum-sepolia", typedData)
+	assert.Equal(t, apperrors.ErrForbidden, err)
+
+	var details []string
+	assert.NoError(t, sink.ForEach(ctx, func(entry audit.Entry) error {
+		if entry.Action == audit.ActionSignTypedData && entry.Outcome == audit.OutcomeSuccess {
+			details = append(details, entry.Detail)
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"digest:" + signed.Digest}, details)
+}
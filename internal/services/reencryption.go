@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/encryption"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reencryptionBatchSize is how many records ReencryptAll re-encrypts before
+// it lets a pending Seal through.
+const reencryptionBatchSize = 100
+
+// ReencryptionProgress reports on a ReencryptAll run.
+type ReencryptionProgress struct {
+	Total       int64
+	Processed   int64
+	Reencrypted int64
+	Skipped     int64
+	Failed      int64
+}
+
+// ReencryptAll moves every stored private key and API client secret to the
+// current key-encryption key. Bound envelope records get their data key re-wrapped, older records
+// are converted to envelopes bound to their row (schema version 3). Records that fail
+// are logged and counted, the run continues. report, if set, is called after
+// every record. A Seal stops the run with ErrSealed between two batches.
+func (s *KeyGenService) ReencryptAll(ctx context.Context, report func(ReencryptionProgress)) (progress ReencryptionProgress, err error) {
+	defer func() {
+		event := audit.Event{
+			Actor:   audit.ActorFrom(ctx),
+			Action:  audit.ActionReencrypt,
+			Outcome: audit.OutcomeSuccess,
+			Detail: fmt.Sprintf("processed %d of %d, re-encrypted %d, skipped %d, failed %d",
+				progress.Processed, progress.Total, progress.Reencrypted, progress.Skipped, progress.Failed),
+		}
+		if err != nil {
+			event.Outcome, event.Detail = auditOutcome(err)
+		}
+		_ = s.record(ctx, event)
+	}()
+
+	// Re-encryption does not need the master seed, only the key manager. It
+	// is acquired per batch so that Seal does not wait for the whole run.
+	release, err := s.acquireKeyManager()
+	if err != nil {
+		return progress, err
+	}
+	defer func() {
+		release()
+	}()
+	nextBatch := func() error {
+		if progress.Processed == 0 || progress.Processed%reencryptionBatchSize != 0 {
+			return nil
+		}
+		release()
+		release = func() {}
+		next, err := s.acquireKeyManager()
+		if err != nil {
+			log.WithField("processed", progress.Processed).Warn("Service sealed, re-encryption stopped")
+			return err
+		}
+		release = next
+		return nil
+	}
+
+	total, err := s.repository.CountKeys(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to count keys")
+		return progress, err
+	}
+	clients, err := s.repository.GetAPIClients(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to list API clients")
+		return progress, err
+	}
+	progress.Total = total + int64(len(clients))
+
+	log.WithFields(log.Fields{
+		"total":  total,
+		"kek_id": s.currentKeyManager().KeyID(),
+	}).Info("Starting re-encryption")
+
+	err = s.repository.ForEachKey(ctx, func(keyData db.KeyData) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := nextBatch(); err != nil {
+			return err
+		}
+
+		progress.Processed++
+		switch updated, err := s.reencrypt(ctx, keyData); {
+		case err != nil:
+			progress.Failed++
+			log.WithFields(log.Fields{
+				"user_id": keyData.UserID,
+				"network": keyData.Network,
+			}).WithError(err).Error("Failed to re-encrypt key")
+		case updated == nil:
+			progress.Skipped++
+		default:
+			if err := s.repository.SaveKey(ctx, *updated); err != nil {
+				progress.Failed++
+				log.WithError(err).Error("Failed to save re-encrypted key")
+			} else {
+				progress.Reencrypted++
+			}
+		}
+
+		if report != nil {
+			report(progress)
+		}
+		return nil
+	})
+	if err != nil {
+		return progress, err
+	}
+
+	for _, client := range clients {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		if err := nextBatch(); err != nil {
+			return progress, err
+		}
+
+		progress.Processed++
+		switch updated, err := s.reencryptAPIClient(ctx, client); {
+		case err != nil:
+			progress.Failed++
+			log.WithField("key_id", client.KeyID).WithError(err).Error("Failed to re-encrypt API client secret")
+		case updated == nil:
+			progress.Skipped++
+		default:
+			if err := s.repository.UpdateAPIClient(ctx, *updated); err != nil {
+				progress.Failed++
+				log.WithError(err).Error("Failed to save re-encrypted API client")
+			} else {
+				progress.Reencrypted++
+			}
+		}
+
+		if report != nil {
+			report(progress)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"processed":   progress.Processed,
+		"reencrypted": progress.Reencrypted,
+		"skipped":     progress.Skipped,
+		"failed":      progress.Failed,
+	}).Info("Re-encryption finished")
+	return progress, nil
+}
+
+// reencrypt returns the record sealed under the current KEK and bound to its
+// row, or nil when there is nothing to do. Unbound records are decrypted and
+// only re-encrypted if the private key matches the record's public key, so a
+// ciphertext swapped before the migration is not silently bound to the wrong
+// row. Once bound records are required they are no longer migrated.
+func (s *KeyGenService) reencrypt(ctx context.Context, keyData db.KeyData) (*db.KeyData, error) {
+	if keyData.WatchOnly || (isBound(keyData) && keyData.KEKID == s.currentKeyManager().KeyID()) {
+		return nil, nil
+	}
+
+	if isBound(keyData) {
+		envelope, err := encryption.RewrapEnvelope(ctx, s.currentKeyManager(), envelopeOf(keyData), associatedData(keyData))
+		if err != nil {
+			return nil, err
+		}
+		keyData.WrappedDataKey = envelope.WrappedDataKey
+		keyData.KEKID = envelope.KEKID
+		return &keyData, nil
+	}
+
+	var privateKey string
+	var err error
+	if keyData.SchemaVersion == addressBoundSchemaVersion && keyData.WrappedDataKey != "" {
+		// Only the public key of version 2 records is not authenticated, it
+		// is checked below, so they are migrated even if bound records are
+		// required
+		privateKey, err = s.openPrivateKey(ctx, keyData)
+	} else {
+		privateKey, err = s.decryptPrivateKey(ctx, keyData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !privateKeyMatches(keyData, privateKey) {
+		return nil, errPrivateKeyMismatch
+	}
+	keyData, err = s.encryptPrivateKey(ctx, keyData, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keyData, nil
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRevealUpdateAttempts bounds the retries of a reveal request update that
+// lost a race with a concurrent update.
+const maxRevealUpdateAttempts = 5
+
+// RevealQuorum requires Required of the Approvers, principals as in
+// auth.Principal.String, to approve a reveal request before its requester
+// can fetch the private key.
+type RevealQuorum struct {
+	Approvers []string
+	Required  int
+	// RequestTTL is how long a request waits for its approvals.
+	RequestTTL time.Duration
+	// FetchTTL is how long the key can be fetched once approved.
+	FetchTTL time.Duration
+}
+
+// EnableRevealQuorum makes private keys revealable only through reveal
+// requests approved by quorum, ExportPrivateKey fails from then on. Reveal
+// must be enabled as well, see EnablePrivateKeyReveal.
+func (s *KeyGenService) EnableRevealQuorum(quorum RevealQuorum) error {
+	if quorum.Required < 1 || quorum.Required > len(quorum.Approvers) {
+		return fmt.Errorf("reveal quorum of %d is not between 1 and the %d approvers", quorum.Required, len(quorum.Approvers))
+	}
+	if quorum.RequestTTL <= 0 || quorum.FetchTTL <= 0 {
+		return stderrors.New("reveal request and fetch TTLs must be positive")
+	}
+	s.revealQuorum = &quorum
+	return nil
+}
+
+// OpenRevealRequest asks for the private key of userID on network. The
+// caller needs the keys:reveal_private scope and becomes the requester, the
+// only one who can fetch the key once the request is approved.
+func (s *KeyGenService) OpenRevealRequest(ctx context.Context, userID int, network string) (request db.RevealRequest, err error) {
+	defer func() {
+		_ = s.record(ctx, revealRequestEvent(ctx, audit.ActionRevealRequest, db.RevealRequest{ID: request.ID, UserID: userID, Network: network}, err))
+	}()
+
+	requester, err := s.revealCaller(ctx)
+	if err != nil {
+		return db.RevealRequest{}, err
+	}
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		return db.RevealRequest{}, err
+	}
+	if !s.revealEnabled {
+		return db.RevealRequest{}, errors.ErrRevealDisabled
+	}
+	if !s.allowMainnet && s.isMainnet(network) {
+		return db.RevealRequest{}, errors.ErrMainnetDisabled
+	}
+
+	exists, err := s.repository.KeyExists(ctx, userID, network)
+	if err != nil {
+		log.WithError(err).Error("Failed to check if keys exist")
+		return db.RevealRequest{}, err
+	}
+	if !exists {
+		return db.RevealRequest{}, errors.ErrKeyNotFound
+	}
+	keyData, err := s.repository.GetKey(ctx, userID, network)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve existing keys")
+		return db.RevealRequest{}, err
+	}
+	if keyData.WatchOnly {
+		return db.RevealRequest{}, errors.ErrWatchOnly
+	}
+
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return db.RevealRequest{}, err
+	}
+	now := time.Now().UTC()
+	request = db.RevealRequest{
+		ID:        "rr_" + id,
+		UserID:    userID,
+		Network:   network,
+		Requester: requester,
+		State:     db.RevealPending,
+		Required:  s.revealQuorum.Required,
+		Approvals: []db.RevealApproval{},
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.revealQuorum.RequestTTL),
+	}
+	if err := s.repository.SaveRevealRequest(ctx, request); err != nil {
+		log.WithError(err).Error("Failed to save reveal request")
+		return db.RevealRequest{}, err
+	}
+	log.WithFields(log.Fields{
+		"request_id": request.ID,
+		"user_id":    userID,
+		"network":    network,
+		"requester":  requester,
+	}).Warn("Opened private key reveal request")
+	return request, nil
+}
+
+// GetRevealRequest returns a request to its requester or an approver, both
+// need the keys:reveal_private scope. To anyone else the request is not
+// found, whether it exists or not.
+func (s *KeyGenService) GetRevealRequest(ctx context.Context, id string) (db.RevealRequest, error) {
+	caller, err := s.revealCaller(ctx)
+	if err != nil {
+		return db.RevealRequest{}, err
+	}
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		return db.RevealRequest{}, err
+	}
+	request, err := s.loadRevealRequest(ctx, id)
+	if err != nil {
+		return db.RevealRequest{}, err
+	}
+	if caller != request.Requester && !slices.Contains(s.revealQuorum.Approvers, caller) {
+		return db.RevealRequest{}, errors.ErrRevealRequestNotFound
+	}
+	return request, nil
+}
+
+// ApproveRevealRequest adds the approval of the caller, a designated
+// approver other than the requester with the keys:reveal_private scope. The
+// request is approved, and the fetch
+// window opens, with the last approval the quorum requires.
+func (s *KeyGenService) ApproveRevealRequest(ctx context.Context, id string) (request db.RevealRequest, err error) {
+	defer func() {
+		_ = s.record(ctx, revealRequestEvent(ctx, audit.ActionRevealApprove, request, err))
+	}()
+
+	approver, err := s.revealCaller(ctx)
+	if err != nil {
+		return db.RevealRequest{ID: id}, err
+	}
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		return db.RevealRequest{ID: id}, err
+	}
+	if !slices.Contains(s.revealQuorum.Approvers, approver) {
+		return db.RevealRequest{ID: id}, errors.ErrNotRevealApprover
+	}
+
+	return s.updateRevealRequest(ctx, id, func(request *db.RevealRequest) error {
+		if request.Requester == approver {
+			return errors.ErrSelfApproval
+		}
+		if err := revealRequestState(*request, db.RevealPending); err != nil {
+			return err
+		}
+		if slices.ContainsFunc(request.Approvals, func(approval db.RevealApproval) bool {
+			return approval.Approver == approver
+		}) {
+			return errors.ErrAlreadyApproved
+		}
+
+		now := time.Now().UTC()
+		request.Approvals = append(request.Approvals, db.RevealApproval{Approver: approver, ApprovedAt: now})
+		if len(request.Approvals) >= request.Required {
+			request.State = db.RevealApproved
+			request.FetchExpiresAt = now.Add(s.revealQuorum.FetchTTL)
+		}
+		return nil
+	})
+}
+
+// FetchExportedKey returns the stored record of an approved request with its
+// private key encrypted as options select, see ExportPrivateKey. Only the
+// requester can fetch it, only once and only before the fetch window closes.
+// Options that do not suit the network of the request are refused before
+// the request is used up, and no key is returned unless the export was
+// written to the audit log.
+func (s *KeyGenService) FetchExportedKey(ctx context.Context, id string, options ExportOptions) (ExportedKey, error) {
+	release, err := s.acquireKDFSlot(options)
+	if err != nil {
+		_ = s.record(ctx, revealRequestEvent(ctx, audit.ActionExportPrivateKey, db.RevealRequest{ID: id}, err))
+		return ExportedKey{}, err
+	}
+	defer release()
+
+	var exported ExportedKey
+	err = s.fetchRevealedKey(ctx, id, func(request db.RevealRequest) error {
+		return s.checkExport(request.Network, options)
+	}, func(request db.RevealRequest, keyPairAndAddress KeyPairAndAddress) (err error) {
+		exported, err = s.encryptExport(request.Network, keyPairAndAddress, options)
+		return err
+	})
+	if err != nil {
+		return ExportedKey{}, err
+	}
+	return exported, nil
+}
+
+// fetchRevealedKey decrypts the key of the approved request of the caller
+// and passes it to reveal, which prepares what is handed out. check can
+// refuse the request before. The request is only marked fetched once reveal
+// and the audit entry succeeded, so that a failure leaves it to be fetched
+// again; if another fetch marked it first, the key is not handed out.
+func (s *KeyGenService) fetchRevealedKey(ctx context.Context, id string, check func(db.RevealRequest) error, reveal func(db.RevealRequest, KeyPairAndAddress) error) (err error) {
+	const action = audit.ActionExportPrivateKey
+	request := db.RevealRequest{ID: id}
+	recorded := false
+	defer func() {
+		if !recorded {
+			_ = s.record(ctx, revealRequestEvent(ctx, action, request, err))
+		}
+	}()
+
+	requester, err := s.revealCaller(ctx)
+	if err != nil {
+		return err
+	}
+	if err := auth.Authorize(ctx, auth.ScopeRevealPrivate); err != nil {
+		return err
+	}
+	if !s.revealEnabled {
+		return errors.ErrRevealDisabled
+	}
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fetchable := func(request db.RevealRequest) error {
+		if request.Requester != requester {
+			return errors.ErrForbidden
+		}
+		return revealRequestState(request, db.RevealApproved)
+	}
+	loaded, err := s.loadRevealRequest(ctx, id)
+	if err != nil {
+		return err
+	}
+	request = loaded
+	if err := fetchable(request); err != nil {
+		return err
+	}
+	if err := check(request); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"request_id": request.ID,
+		"user_id":    request.UserID,
+		"network":    request.Network,
+	}).Warn("Fetching private key of approved reveal request")
+	keyPairAndAddress, err := s.decryptStoredKey(ctx, request.UserID, request.Network)
+	if err != nil {
+		return err
+	}
+	if err := reveal(request, keyPairAndAddress); err != nil {
+		return err
+	}
+
+	recorded = true
+	if err := s.record(ctx, revealRequestEvent(ctx, action, request, nil)); err != nil {
+		return errors.ErrInternalServerError
+	}
+	request, err = s.updateRevealRequest(ctx, id, func(request *db.RevealRequest) error {
+		if err := fetchable(*request); err != nil {
+			return err
+		}
+		request.State = db.RevealFetched
+		request.FetchedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		log.WithField("request_id", id).WithError(err).Error("Failed to mark reveal request fetched, withholding the key")
+		_ = s.record(ctx, revealRequestEvent(ctx, action, request, err))
+		return err
+	}
+	return nil
+}
+
+// revealCaller identifies the caller of a reveal request operation. Requests
+// are bound to authenticated principals, so they need a principal even with
+// authentication disabled.
+func (s *KeyGenService) revealCaller(ctx context.Context) (string, error) {
+	if s.revealQuorum == nil {
+		return "", errors.ErrRevealQuorumDisabled
+	}
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return "", errors.ErrUnauthorized
+	}
+	return principal.String(), nil
+}
+
+// loadRevealRequest returns the request with id, first expiring it if its
+// approval or fetch window has closed.
+func (s *KeyGenService) loadRevealRequest(ctx context.Context, id string) (db.RevealRequest, error) {
+	for attempt := 1; ; attempt++ {
+		request, err := s.repository.GetRevealRequest(ctx, id)
+		if stderrors.Is(err, db.ErrNotFound) {
+			return db.RevealRequest{}, errors.ErrRevealRequestNotFound
+		}
+		if err != nil {
+			return db.RevealRequest{}, err
+		}
+		if !revealRequestDue(request, time.Now()) {
+			return request, nil
+		}
+
+		request.State = db.RevealExpired
+		updated, err := s.repository.UpdateRevealRequest(ctx, request)
+		if stderrors.Is(err, db.ErrConflict) && attempt < maxRevealUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to expire reveal request")
+			return db.RevealRequest{}, err
+		}
+		log.WithField("request_id", id).Info("Reveal request expired")
+		_ = s.record(ctx, revealRequestEvent(ctx, audit.ActionRevealExpire, updated, nil))
+		return updated, nil
+	}
+}
+
+// updateRevealRequest applies update to the current request and stores it,
+// starting over when the request changed concurrently. The returned request
+// is the stored one, or the one update refused.
+func (s *KeyGenService) updateRevealRequest(ctx context.Context, id string, update func(*db.RevealRequest) error) (db.RevealRequest, error) {
+	for attempt := 1; ; attempt++ {
+		request, err := s.loadRevealRequest(ctx, id)
+		if err != nil {
+			return db.RevealRequest{ID: id}, err
+		}
+		if err := update(&request); err != nil {
+			return request, err
+		}
+		updated, err := s.repository.UpdateRevealRequest(ctx, request)
+		if stderrors.Is(err, db.ErrConflict) && attempt < maxRevealUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to update reveal request")
+			return request, err
+		}
+		return updated, nil
+	}
+}
+
+// revealRequestDue reports whether the open window of request has closed.
+func revealRequestDue(request db.RevealRequest, now time.Time) bool {
+	switch request.State {
+	case db.RevealPending:
+		return now.After(request.ExpiresAt)
+	case db.RevealApproved:
+		return now.After(request.FetchExpiresAt)
+	}
+	return false
+}
+
+// revealRequestState fails with the error explaining why request is not in
+// state.
+func revealRequestState(request db.RevealRequest, state string) error {
+	if request.State == state {
+		return nil
+	}
+	switch request.State {
+	case db.RevealExpired:
+		return errors.ErrRevealRequestExpired
+	case db.RevealFetched:
+		return errors.ErrRevealAlreadyFetched
+	case db.RevealPending:
+		return errors.ErrRevealNotApproved
+	case db.RevealApproved:
+		return errors.ErrAlreadyApproved
+	}
+	return errors.ErrInternalServerError
+}
+
+func revealRequestEvent(ctx context.Context, action audit.Action, request db.RevealRequest, err error) audit.Event {
+	event := audit.Event{
+		Actor:   audit.ActorFrom(ctx),
+		Action:  action,
+		UserID:  request.UserID,
+		Network: request.Network,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  request.ID,
+	}
+	if err != nil {
+		event.Outcome, event.Detail = auditOutcome(err)
+		if request.ID != "" {
+			event.Detail = request.ID + ": " + event.Detail
+		}
+	}
+	return event
+}
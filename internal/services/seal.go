@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/util/errors"
+	"crypto-keygen-service/internal/util/kms"
+	"crypto-keygen-service/internal/util/shamir"
+	"crypto/subtle"
+
+	. "crypto-keygen-service/internal/util/network_factory"
+	log "github.com/sirupsen/logrus"
+)
+
+// SealState is the lifecycle state of the service's secrets:
+//
+//	sealed    -> unsealing  the first unseal share is accepted
+//	unsealing -> sealed     the shares do not combine, or Seal
+//	unsealing -> unsealed   the threshold is reached
+//	sealed    -> unsealed   Unseal with the master seed
+//	unsealed  -> sealed     Seal wipes the master seed and key material
+type SealState string
+
+const (
+	StateSealed    SealState = "sealed"
+	StateUnsealing SealState = "unsealing"
+	StateUnsealed  SealState = "unsealed"
+)
+
+// SealStatus reports the seal state and how many of the required shares have
+// been submitted.
+type SealStatus struct {
+	State     SealState
+	Progress  int
+	Threshold int
+}
+
+func (s SealStatus) Sealed() bool {
+	return s.State != StateUnsealed
+}
+
+// State returns the current seal state.
+func (s *KeyGenService) State() SealState {
+	return s.state.Load().(SealState)
+}
+
+// Sealed reports whether the service still waits for its master seed. A
+// sealed service refuses every request that needs a generator.
+func (s *KeyGenService) Sealed() bool {
+	return s.State() != StateUnsealed
+}
+
+// SetKeyManagerLoader sets how the key manager is created again when the
+// service is unsealed after Seal has wiped it.
+func (s *KeyGenService) SetKeyManagerLoader(loader func() (kms.KeyManager, error)) {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	s.keyManagerLoader = loader
+}
+
+// SetSeedCheck makes unsealing refuse every master seed whose
+// shamir.CheckValue is not check. Without it shares of any seed unseal the
+// service, as long as they combine, so a service started with its master
+// seed sets the check value of that seed.
+func (s *KeyGenService) SetSeedCheck(check string) {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	s.seedCheck = check
+}
+
+// SealStatus returns the current seal state and unseal progress.
+func (s *KeyGenService) SealStatus() SealStatus {
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	return s.sealStatus()
+}
+
+func (s *KeyGenService) sealStatus() SealStatus {
+	status := SealStatus{State: s.State(), Progress: len(s.unsealShares)}
+	if len(s.unsealShares) > 0 {
+		status.Threshold = s.unsealShares[0].Threshold
+	}
+	return status
+}
+
+// setState must be called with sealMu held.
+func (s *KeyGenService) setState(state SealState) {
+	from := s.State()
+	s.state.Store(state)
+	if from != state {
+		log.WithFields(log.Fields{
+			"from": from,
+			"to":   state,
+		}).Warn("Seal state changed")
+	}
+}
+
+// SubmitUnsealShare collects a Shamir share of the master seed, see
+// cmd/shamir. Once the threshold of the first share is reached the shares
+// are combined and the service is unsealed. If they do not combine to the
+// seed they were split from, or to a seed that does not match the seed
+// check, progress is reset.
+func (s *KeyGenService) SubmitUnsealShare(ctx context.Context, encodedShare string) (_ SealStatus, err error) {
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionUnsealShare, 0, "", err)
+	}()
+
+	share, err := shamir.ParseShare(encodedShare)
+	if err != nil {
+		return s.SealStatus(), errors.ErrInvalidShare
+	}
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
+	if !s.Sealed() {
+		return s.sealStatus(), errors.ErrNotSealed
+	}
+	for _, submitted := range s.unsealShares {
+		if share.SetID != submitted.SetID || share.Threshold != submitted.Threshold || share.X == submitted.X {
+			log.WithField("progress", len(s.unsealShares)).Warn("Rejected unseal share")
+			return s.sealStatus(), errors.ErrInvalidShare
+		}
+	}
+	s.unsealShares = append(s.unsealShares, share)
+	s.setState(StateUnsealing)
+	log.WithFields(log.Fields{
+		"progress":  len(s.unsealShares),
+		"threshold": share.Threshold,
+	}).Info("Accepted unseal share")
+
+	if len(s.unsealShares) < share.Threshold {
+		return s.sealStatus(), nil
+	}
+
+	masterSeed, err := shamir.Combine(s.unsealShares)
+	s.resetUnsealShares()
+	if err != nil {
+		log.WithError(err).Error("Failed to combine unseal shares")
+		s.setState(StateSealed)
+		return s.sealStatus(), errors.ErrUnsealFailed
+	}
+	if err := s.unseal(ctx, masterSeed); err != nil {
+		zero(masterSeed)
+		s.setState(StateSealed)
+		return s.sealStatus(), err
+	}
+	_ = s.recordAudit(ctx, audit.ActionUnseal, 0, "", nil)
+	return s.sealStatus(), nil
+}
+
+// ResetUnseal discards the shares submitted so far, e.g. when the first
+// share announced a threshold the share holders cannot reach.
+func (s *KeyGenService) ResetUnseal(ctx context.Context) (_ SealStatus, err error) {
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionUnsealReset, 0, "", err)
+	}()
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	if !s.Sealed() {
+		return s.sealStatus(), errors.ErrNotSealed
+	}
+	log.WithField("progress", len(s.unsealShares)).Warn("Unseal progress reset")
+	s.resetUnsealShares()
+	s.setState(StateSealed)
+	return s.sealStatus(), nil
+}
+
+// Unseal provides the master seed to a sealed service, which takes
+// ownership of it.
+func (s *KeyGenService) Unseal(ctx context.Context, masterSeed []byte) (err error) {
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionUnseal, 0, "", err)
+	}()
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	if !s.Sealed() {
+		return errors.ErrNotSealed
+	}
+	s.resetUnsealShares()
+	if err := s.unseal(ctx, masterSeed); err != nil {
+		s.setState(StateSealed)
+		return err
+	}
+	return nil
+}
+
+// unseal must be called with sealMu held.
+func (s *KeyGenService) unseal(ctx context.Context, masterSeed []byte) error {
+	if s.seedCheck != "" && subtle.ConstantTimeCompare([]byte(shamir.CheckValue(masterSeed)), []byte(s.seedCheck)) != 1 {
+		log.Error("Master seed does not match the seed check")
+		return errors.ErrUnsealFailed
+	}
+	if s.keyManagerWiped() {
+		if s.keyManagerLoader == nil {
+			log.Error("Key manager was wiped and cannot be loaded again")
+			return errors.ErrInternalServerError
+		}
+		keyManager, err := s.keyManagerLoader()
+		if err != nil {
+			log.WithError(err).Error("Failed to load key manager")
+			return err
+		}
+		s.mu.Lock()
+		s.keyManager, s.wiped = keyManager, false
+		s.mu.Unlock()
+	}
+
+	s.masterSeed = masterSeed
+	s.registerSeedGenerators(masterSeed)
+	// Watch-only accounts are based on the seed-derived networks
+	if err := s.LoadWatchOnlyAccounts(ctx); err != nil {
+		log.WithError(err).Error("Failed to load watch-only accounts")
+		s.dropGenerators()
+		return err
+	}
+	s.setState(StateUnsealed)
+	return nil
+}
+
+// acquireSecrets fails with ErrSealed unless the service is unsealed and
+// otherwise holds off Seal until release is called.
+func (s *KeyGenService) acquireSecrets() (release func(), err error) {
+	s.secretsMu.RLock()
+	if s.Sealed() {
+		s.secretsMu.RUnlock()
+		return nil, errors.ErrSealed
+	}
+	return s.secretsMu.RUnlock, nil
+}
+
+// acquireKeyManager is acquireSecrets for operations that only need the key
+// manager, they also work while the service waits for its master seed.
+func (s *KeyGenService) acquireKeyManager() (release func(), err error) {
+	s.secretsMu.RLock()
+	if s.keyManagerWiped() {
+		s.secretsMu.RUnlock()
+		return nil, errors.ErrSealed
+	}
+	return s.secretsMu.RUnlock, nil
+}
+
+// Seal wipes the master seed and the key material from memory and drops
+// every generator, so that nothing can be generated, revealed or decrypted
+// until the service is unsealed again with shares of the master seed. It
+// waits for in-flight requests to finish.
+func (s *KeyGenService) Seal(ctx context.Context) {
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionSeal, 0, "", nil)
+	}()
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+	s.secretsMu.Lock()
+	defer s.secretsMu.Unlock()
+
+	s.resetUnsealShares()
+	s.dropGenerators()
+
+	s.mu.Lock()
+	if wiper, ok := s.keyManager.(kms.Wiper); ok && !s.wiped {
+		wiper.Wipe()
+		s.wiped = true
+	}
+	s.mu.Unlock()
+
+	s.setState(StateSealed)
+}
+
+// dropGenerators must be called with sealMu held.
+func (s *KeyGenService) dropGenerators() {
+	s.mu.Lock()
+	s.generators = make(map[string]KeyGenerator)
+	s.aliases = make(map[string]string)
+	s.mu.Unlock()
+
+	zero(s.masterSeed)
+	s.masterSeed = nil
+}
+
+func (s *KeyGenService) resetUnsealShares() {
+	for _, share := range s.unsealShares {
+		zero(share.Y)
+	}
+	s.unsealShares = nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
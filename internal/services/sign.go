@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"crypto-keygen-service/internal/util/network_factory/generators"
+	"crypto/sha256"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SignedMessage is a signature of a message by the key of Address.
+type SignedMessage struct {
+	Address string
+	MessageSignature
+}
+
+// SignMessage signs message with the stored key of userID on network, e.g.
+// to prove the ownership of its address to a third party. It never generates
+// a key and never returns the private key. The caller needs the keys:sign
+// scope. No signature is returned unless the signing was written to the
+// audit log, along with the SHA-256 of message.
+func (s *KeyGenService) SignMessage(ctx context.Context, userID int, network string, message []byte) (signed SignedMessage, err error) {
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"network": network,
+	}).Warn("Request to sign message")
+
+	defer func() {
+		if auditErr := s.recordSigning(ctx, audit.ActionSignMessage, userID, network, "sha256:"+messageDigest(message), err); auditErr != nil && err == nil {
+			signed, err = SignedMessage{}, errors.ErrInternalServerError
+		}
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeSign); err != nil {
+		return SignedMessage{}, err
+	}
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	defer release()
+
+	generator, err := s.signingGenerator(network)
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	signer, ok := generator.(MessageSigner)
+	if !ok {
+		return SignedMessage{}, errors.ErrSigningUnsupported
+	}
+
+	keyPairAndAddress, err := s.decryptStoredKey(ctx, userID, network)
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	signature, err := signer.SignMessage(keyPairAndAddress.PrivateKey, message)
+	if err != nil {
+		log.WithError(err).Error("Failed to sign message")
+		return SignedMessage{}, err
+	}
+	return SignedMessage{Address: keyPairAndAddress.Address, MessageSignature: signature}, nil
+}
+
+// VerifyMessage reports whether signature, in one of the schemes SignMessage
+// produces for network, is a signature of message by the key of address. Any
+// address of network can be verified, whether its key is stored or not. The
+// caller needs the keys:read_public scope.
+func (s *KeyGenService) VerifyMessage(ctx context.Context, network, address string, message []byte, signature string) (valid bool, err error) {
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionVerifyMessage, 0, network, err)
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeReadPublic); err != nil {
+		return false, err
+	}
+
+	// Verifying needs no key, so it works without the master seed
+	generator, err := generators.GetKeyGenerator(network)
+	if err != nil {
+		return false, errors.ErrUnsupportedNetwork
+	}
+	signer, ok := generator.(MessageSigner)
+	if !ok {
+		return false, errors.ErrSigningUnsupported
+	}
+	return signer.VerifyMessage(address, message, signature)
+}
+
+// SignTypedData signs typed structured data, e.g. an EIP-712 permit, with
+// the stored key of userID on network, like SignMessage. The returned
+// signature carries the digest that was signed, which is also written to the
+// audit log.
+func (s *KeyGenService) SignTypedData(ctx context.Context, userID int, network string, typedData []byte) (signed SignedMessage, err error) {
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"network": network,
+	}).Warn("Request to sign typed data")
+
+	defer func() {
+		if auditErr := s.recordSigning(ctx, audit.ActionSignTypedData, userID, network, "digest:"+signed.Digest, err); auditErr != nil && err == nil {
+			signed, err = SignedMessage{}, errors.ErrInternalServerError
+		}
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeSign); err != nil {
+		return SignedMessage{}, err
+	}
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	defer release()
+
+	generator, err := s.signingGenerator(network)
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	signer, ok := generator.(TypedDataSigner)
+	if !ok {
+		return SignedMessage{}, errors.ErrSigningUnsupported
+	}
+
+	keyPairAndAddress, err := s.decryptStoredKey(ctx, userID, network)
+	if err != nil {
+		return SignedMessage{}, err
+	}
+	signature, err := signer.SignTypedData(keyPairAndAddress.PrivateKey, typedData)
+	if err != nil {
+		log.WithError(err).Error("Failed to sign typed data")
+		return SignedMessage{}, err
+	}
+	return SignedMessage{Address: keyPairAndAddress.Address, MessageSignature: signature}, nil
+}
+
+// signingGenerator returns the generator of network if keys of network may
+// be used.
+func (s *KeyGenService) signingGenerator(network string) (KeyGenerator, error) {
+	generator, exists := s.generator(network)
+	if !exists {
+		return nil, errors.ErrUnsupportedNetwork
+	}
+	if !s.allowMainnet && s.isMainnet(network) {
+		return nil, errors.ErrMainnetDisabled
+	}
+	return generator, nil
+}
+
+// recordSigning records a signing with detail, a digest of what was signed,
+// or with the outcome of err.
+func (s *KeyGenService) recordSigning(ctx context.Context, action audit.Action, userID int, network, detail string, err error) error {
+	event := audit.Event{
+		Actor:   audit.ActorFrom(ctx),
+		Action:  action,
+		UserID:  userID,
+		Network: network,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  detail,
+	}
+	if err != nil {
+		event.Outcome, event.Detail = auditOutcome(err)
+	}
+	return s.record(ctx, event)
+}
+
+func messageDigest(message []byte) string {
+	digest := sha256.Sum256(message)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/db"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"crypto-keygen-service/internal/util/network_factory/generators/watchonly"
+	stderrors "errors"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var networkNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// RegisterWatchOnlyAccount validates a tenant's extended public key, persists
+// it and serves addresses derived from it under network. baseNetwork selects
+// the address format, e.g. "bitcoin-p2wpkh" or "ethereum-sepolia". The caller
+// needs the accounts:manage scope.
+func (s *KeyGenService) RegisterWatchOnlyAccount(ctx context.Context, network, baseNetwork, extendedPublicKey, derivationPath string) (_ AccountKey, err error) {
+	log.WithFields(log.Fields{
+		"network":      network,
+		"base_network": baseNetwork,
+	}).Info("Request to register watch-only account")
+
+	defer func() {
+		_ = s.recordAudit(ctx, audit.ActionRegisterWatchOnly, 0, network, err)
+	}()
+
+	if err := auth.Authorize(ctx, auth.ScopeManageAccounts); err != nil {
+		return AccountKey{}, err
+	}
+
+	release, err := s.acquireSecrets()
+	if err != nil {
+		return AccountKey{}, err
+	}
+	defer release()
+
+	if !networkNamePattern.MatchString(network) {
+		return AccountKey{}, errors.ErrInvalidNetworkName
+	}
+	if _, exists := s.generator(s.ResolveNetwork(network, "")); exists {
+		return AccountKey{}, errors.ErrNetworkExists
+	}
+
+	generator, err := s.newWatchOnlyKeyGen(db.WatchOnlyAccount{
+		Network:           network,
+		BaseNetwork:       baseNetwork,
+		ExtendedPublicKey: extendedPublicKey,
+		DerivationPath:    derivationPath,
+	})
+	if err != nil {
+		return AccountKey{}, err
+	}
+	if !s.allowMainnet && generator.IsMainnet() {
+		return AccountKey{}, errors.ErrMainnetDisabled
+	}
+	accountKey, _ := generator.AccountKey()
+
+	err = s.repository.SaveWatchOnlyAccount(ctx, db.WatchOnlyAccount{
+		Network:           network,
+		BaseNetwork:       baseNetwork,
+		ExtendedPublicKey: accountKey.ExtendedPublicKey,
+		DerivationPath:    accountKey.DerivationPath,
+		CreatedAt:         time.Now().UTC(),
+	})
+	if stderrors.Is(err, db.ErrDuplicate) {
+		return AccountKey{}, errors.ErrNetworkExists
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to save watch-only account")
+		return AccountKey{}, err
+	}
+
+	s.RegisterGenerator(network, generator)
+	return accountKey, nil
+}
+
+// LoadWatchOnlyAccounts registers every persisted watch-only account. It is
+// called once at startup, or by Unseal when the service starts sealed.
+func (s *KeyGenService) LoadWatchOnlyAccounts(ctx context.Context) error {
+	accounts, err := s.repository.GetWatchOnlyAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		generator, err := s.newWatchOnlyKeyGen(account)
+		if err != nil {
+			log.WithField("network", account.Network).WithError(err).Error("Skipping invalid watch-only account")
+			continue
+		}
+		s.RegisterGenerator(account.Network, generator)
+	}
+	log.WithField("count", len(accounts)).Info("Loaded watch-only accounts")
+	return nil
+}
+
+func (s *KeyGenService) newWatchOnlyKeyGen(account db.WatchOnlyAccount) (*watchonly.WatchOnlyKeyGen, error) {
+	base, exists := s.generator(account.BaseNetwork)
+	if !exists {
+		return nil, errors.ErrUnsupportedNetwork
+	}
+	// Watch-only accounts can only be based on seed-derived networks
+	describer, ok := base.(PublicKeyDescriber)
+	if _, isWatchOnly := base.(*watchonly.WatchOnlyKeyGen); !ok || isWatchOnly {
+		return nil, errors.ErrUnsupportedNetwork
+	}
+	return watchonly.NewWatchOnlyKeyGen(describer, account.ExtendedPublicKey, account.DerivationPath)
+}
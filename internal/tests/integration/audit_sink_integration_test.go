@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"context"
+	"crypto-keygen-service/internal/audit"
+	mongoDB "crypto-keygen-service/internal/db/mongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestAuditSink(t *testing.T) {
+	ctx := context.Background()
+	database := setupDatabase().(*mongoDB.MongoDatabase)
+	sink, err := mongoDB.NewAuditSink(ctx, database.Client.Database("crypto-keygen-service-test"), "audit")
+	require.NoError(t, err)
+	_, err = sink.Entries.DeleteMany(ctx, bson.M{})
+	require.NoError(t, err)
+	_, err = sink.Heads.DeleteMany(ctx, bson.M{})
+	require.NoError(t, err)
+
+	_, ok, err := sink.Last(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	logger := audit.NewLogger(sink)
+	for _, action := range []audit.Action{audit.ActionGenerate, audit.ActionRetrieve, audit.ActionExportPrivateKey} {
+		_, err := logger.Record(ctx, audit.Event{Actor: "client:a", Action: action, UserID: 1, Network: "bitcoin", Outcome: audit.OutcomeSuccess})
+		require.NoError(t, err)
+	}
+
+	last, ok, err := sink.Last(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), last.Seq)
+	head, err := audit.Verify(ctx, sink)
+	require.NoError(t, err)
+	assert.Equal(t, audit.Anchor{Seq: 3, Hash: last.Hash}, head)
+
+	// Another replica cannot append a second entry 3
+	forked := last
+	forked.Detail = "forked"
+	assert.ErrorIs(t, sink.Append(ctx, forked), audit.ErrConflict)
+
+	// Editing an entry breaks the chain
+	_, err = sink.Entries.UpdateOne(ctx, bson.M{"seq": 2}, bson.M{"$set": bson.M{"actor": "client:b"}})
+	require.NoError(t, err)
+	_, err = audit.Verify(ctx, sink)
+	assert.ErrorIs(t, err, audit.ErrTampered)
+
+	// Dropping the last entry is caught by the head checkpoint
+	_, err = sink.Entries.UpdateOne(ctx, bson.M{"seq": 2}, bson.M{"$set": bson.M{"actor": "client:a"}})
+	require.NoError(t, err)
+	_, err = sink.Entries.DeleteOne(ctx, bson.M{"seq": 3})
+	require.NoError(t, err)
+	_, err = audit.Verify(ctx, sink)
+	assert.ErrorIs(t, err, audit.ErrTruncated)
+}
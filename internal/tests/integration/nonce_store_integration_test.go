@@ -0,0 +1,36 @@
+package integration
+
+import (
+	"context"
+	mongoDB "crypto-keygen-service/internal/db/mongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestNonceStore(t *testing.T) {
+	ctx := context.Background()
+	database := setupDatabase().(*mongoDB.MongoDatabase)
+	store, err := mongoDB.NewNonceStore(ctx, database.Client.Database("crypto-keygen-service-test"), "nonces")
+	require.NoError(t, err)
+	_, err = store.Collection.DeleteMany(ctx, bson.M{})
+	require.NoError(t, err)
+
+	expires := time.Now().Add(5 * time.Minute)
+	fresh, err := store.Add(ctx, "ak_a:nonce-1", expires)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	// A second replica sharing the collection refuses the replay
+	replica, err := mongoDB.NewNonceStore(ctx, database.Client.Database("crypto-keygen-service-test"), "nonces")
+	require.NoError(t, err)
+	fresh, err = replica.Add(ctx, "ak_a:nonce-1", expires)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	fresh, err = replica.Add(ctx, "ak_b:nonce-1", expires)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+}
@@ -0,0 +1,41 @@
+package integration
+
+import (
+	"context"
+	mongoDB "crypto-keygen-service/internal/db/mongo"
+	"crypto-keygen-service/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	database := setupDatabase().(*mongoDB.MongoDatabase)
+	store, err := mongoDB.NewRateLimitStore(ctx, database.Client.Database("crypto-keygen-service-test"), "rate_limits")
+	require.NoError(t, err)
+	_, err = store.Collection.DeleteMany(ctx, bson.M{})
+	require.NoError(t, err)
+
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	for i := 0; i < 2; i++ {
+		ok, _, err := store.Take(ctx, "client:a", limit)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, retryAfter, err := store.Take(ctx, "client:a", limit)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, retryAfter, float64(100*time.Millisecond))
+
+	ok, _, err = store.Take(ctx, "client:b", limit)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	time.Sleep(retryAfter)
+	ok, _, err = store.Take(ctx, "client:a", limit)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -6,7 +6,7 @@ import (
 	mongoDB "crypto-keygen-service/internal/db/mongo"
 	"crypto-keygen-service/internal/repositories"
 	"crypto-keygen-service/internal/services"
-	"crypto-keygen-service/internal/util/encryption"
+	"crypto-keygen-service/internal/util/kms"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -19,8 +19,11 @@ import (
 const sampleEncryptionKey = "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
 const sampleMasterSeed = "sample-master-seed"
 
+var keyManager kms.KeyManager
+
 func TestMain(m *testing.M) {
-	err := encryption.Setup(sampleEncryptionKey)
+	var err error
+	keyManager, err = kms.NewLocalKeyManager(kms.Key{Material: sampleEncryptionKey})
 	if err != nil {
 		panic("Failed to set up encryption: " + err.Error())
 	}
@@ -52,7 +55,7 @@ func setupDatabase() db.Database {
 
 func setupKeyGenService(database db.Database) *services.KeyGenService {
 	repo := repositories.NewKeyGenRepository(database)
-	return services.NewKeyGenService(repo, []byte(sampleMasterSeed))
+	return services.NewKeyGenService(repo, []byte(sampleMasterSeed), keyManager)
 }
 
 func TestServiceIntegration(t *testing.T) {
@@ -67,24 +70,24 @@ func TestServiceIntegration(t *testing.T) {
 	_, _ = database.(*mongoDB.MongoDatabase).Collection.DeleteMany(context.Background(), bson.M{"user_id": userID})
 
 	// Test Bitcoin key generation and retrieval
-	btcResult1, err := service.GetKeysAndAddress(userID, bitcoinNetwork)
+	btcResult1, err := service.GetKeysAndAddress(context.Background(), userID, bitcoinNetwork)
 	assert.NoError(t, err, "Expected no error for Bitcoin key generation")
 	assert.NotEmpty(t, btcResult1.Address, "Expected non-empty Bitcoin address")
 	assert.NotEmpty(t, btcResult1.PublicKey, "Expected non-empty Bitcoin public key")
-	assert.NotEmpty(t, btcResult1.PrivateKey, "Expected non-empty Bitcoin private key")
+	assert.Empty(t, btcResult1.PrivateKey, "Expected no Bitcoin private key")
 
-	btcResult2, err := service.GetKeysAndAddress(userID, bitcoinNetwork)
+	btcResult2, err := service.GetKeysAndAddress(context.Background(), userID, bitcoinNetwork)
 	assert.NoError(t, err, "Expected no error for Bitcoin key generation")
 	assert.Equal(t, btcResult1, btcResult2, "Expected same keys for repeated Bitcoin key generation")
 
 	// Test Ethereum key generation and retrieval
-	ethResult1, err := service.GetKeysAndAddress(userID, ethereumNetwork)
+	ethResult1, err := service.GetKeysAndAddress(context.Background(), userID, ethereumNetwork)
 	assert.NoError(t, err, "Expected no error for Ethereum key generation")
 	assert.NotEmpty(t, ethResult1.Address, "Expected non-empty Ethereum address")
 	assert.NotEmpty(t, ethResult1.PublicKey, "Expected non-empty Ethereum public key")
-	assert.NotEmpty(t, ethResult1.PrivateKey, "Expected non-empty Ethereum private key")
+	assert.Empty(t, ethResult1.PrivateKey, "Expected no Ethereum private key")
 
-	ethResult2, err := service.GetKeysAndAddress(userID, ethereumNetwork)
+	ethResult2, err := service.GetKeysAndAddress(context.Background(), userID, ethereumNetwork)
 	assert.NoError(t, err, "Expected no error for Ethereum key generation")
 	assert.Equal(t, ethResult1, ethResult2, "Expected same keys for repeated Ethereum key generation")
 
@@ -0,0 +1,85 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// FormatAge is the age encrypted export of a private key of any network.
+const FormatAge = "age"
+
+// ErrInvalidRecipient is returned for a recipient that is not an age X25519
+// public key.
+var ErrInvalidRecipient = errors.New("invalid age recipient")
+
+// ValidAgeRecipient reports whether recipient is an age X25519 public key.
+func ValidAgeRecipient(recipient string) bool {
+	_, err := age.ParseX25519Recipient(recipient)
+	return err == nil
+}
+
+// AgeEncrypt encrypts plaintext to recipient, an age X25519 public key
+// (age1...), or with an empty recipient to passphrase. The result is ASCII
+// armored.
+func AgeEncrypt(plaintext []byte, recipient, passphrase string) (string, error) {
+	var r age.Recipient
+	if recipient != "" {
+		x25519, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return "", ErrInvalidRecipient
+		}
+		r = x25519
+	} else {
+		scrypt, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return "", err
+		}
+		r = scrypt
+	}
+
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// AgeDecrypt opens an armored age file with an X25519 identity
+// (AGE-SECRET-KEY-1...) or, with an empty identity, with passphrase.
+func AgeDecrypt(ciphertext, identity, passphrase string) ([]byte, error) {
+	var id age.Identity
+	if identity != "" {
+		x25519, err := age.ParseX25519Identity(identity)
+		if err != nil {
+			return nil, err
+		}
+		id = x25519
+	} else {
+		scrypt, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		id = scrypt
+	}
+
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader([]byte(ciphertext))), id)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
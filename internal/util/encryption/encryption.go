@@ -1,84 +1,188 @@
 package encryption
 
 import (
+	"context"
+	"crypto-keygen-service/internal/util/kms"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"io"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
-var key []byte
+// Envelope is a plaintext sealed under a random per-record data key. The data
+// key itself is wrapped by the key-encryption key (KEK) identified by KEKID,
+// so a KEK can be rotated by re-wrapping data keys only.
+type Envelope struct {
+	Ciphertext     string
+	WrappedDataKey string
+	KEKID          string
+}
 
-func Setup(keyString string) error {
-	if keyString == "" {
-		log.Error("ENCRYPTION_KEY not set")
-		return errors.New("ENCRYPTION_KEY not set")
+// Decrypt opens a record written before envelope encryption, which was sealed
+// directly under a key-encryption key without naming it.
+func Decrypt(ctx context.Context, keyManager kms.KeyManager, ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		log.WithError(err).Error("Failed to decode base64 ciphertext")
+		return "", err
 	}
 
-	var err error
-	key, err = base64.StdEncoding.DecodeString(keyString)
+	plaintext, err := keyManager.Unwrap(ctx, "", data, nil)
 	if err != nil {
-		log.WithError(err).Error("Invalid ENCRYPTION_KEY")
-		return fmt.Errorf("invalid ENCRYPTION_KEY: %w", err)
+		log.WithError(err).Error("Failed to decrypt ciphertext")
+		return "", err
 	}
+	defer zero(plaintext)
 
-	if len(key) != chacha20poly1305.KeySize {
-		log.Errorf("ENCRYPTION_KEY must be %d bytes long", chacha20poly1305.KeySize)
-		return fmt.Errorf("ENCRYPTION_KEY must be %d bytes long", chacha20poly1305.KeySize)
+	log.Debug("Decryption successful")
+	return string(plaintext), nil
+}
+
+// EncryptEnvelope seals plaintext under a fresh random data key and wraps the
+// data key with the current KEK. associatedData is authenticated by both the
+// ciphertext and the wrapped data key, it must be presented again to decrypt.
+func EncryptEnvelope(ctx context.Context, keyManager kms.KeyManager, plaintext string, associatedData []byte) (Envelope, error) {
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		log.WithError(err).Error("Failed to generate data key")
+		return Envelope{}, err
+	}
+	defer zero(dataKey)
+
+	ciphertext, err := seal(dataKey, []byte(plaintext), associatedData)
+	if err != nil {
+		log.WithError(err).Error("Failed to encrypt with data key")
+		return Envelope{}, err
+	}
+	wrappedDataKey, kekID, err := keyManager.Wrap(ctx, dataKey, associatedData)
+	if err != nil {
+		log.WithError(err).Error("Failed to wrap data key")
+		return Envelope{}, err
 	}
 
-	log.Info("Encryption setup successful")
-	return nil
+	log.Debug("Envelope encryption successful")
+	return Envelope{
+		Ciphertext:     base64.StdEncoding.EncodeToString(ciphertext),
+		WrappedDataKey: base64.StdEncoding.EncodeToString(wrappedDataKey),
+		KEKID:          kekID,
+	}, nil
 }
 
-func Encrypt(plaintext string) (string, error) {
-	aead, err := chacha20poly1305.NewX(key)
+// DecryptEnvelope unwraps the data key with the KEK named by the envelope and
+// opens the ciphertext. It fails if associatedData differs from the one used
+// for encryption.
+func DecryptEnvelope(ctx context.Context, keyManager kms.KeyManager, envelope Envelope, associatedData []byte) (string, error) {
+	plaintext, err := openEnvelope(ctx, keyManager, envelope, associatedData)
 	if err != nil {
-		log.WithError(err).Error("Failed to create AEAD instance for encryption")
 		return "", err
 	}
+	defer zero(plaintext)
 
-	nonce := make([]byte, aead.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		log.WithError(err).Error("Failed to generate nonce for encryption")
-		return "", err
-	}
+	log.Debug("Envelope decryption successful")
+	return string(plaintext), nil
+}
 
-	ciphertext := aead.Seal(nonce, nonce, []byte(plaintext), nil)
-	encryptedText := base64.StdEncoding.EncodeToString(ciphertext)
-	log.Debug("Encryption successful")
-	return encryptedText, nil
+// VerifyEnvelope checks that envelope opens with associatedData without
+// returning the plaintext.
+func VerifyEnvelope(ctx context.Context, keyManager kms.KeyManager, envelope Envelope, associatedData []byte) error {
+	plaintext, err := openEnvelope(ctx, keyManager, envelope, associatedData)
+	zero(plaintext)
+	return err
 }
 
-func Decrypt(ciphertext string) (string, error) {
-	aead, err := chacha20poly1305.NewX(key)
+func openEnvelope(ctx context.Context, keyManager kms.KeyManager, envelope Envelope, associatedData []byte) ([]byte, error) {
+	dataKey, err := unwrapDataKey(ctx, keyManager, envelope, associatedData)
 	if err != nil {
-		log.WithError(err).Error("Failed to create AEAD instance for decryption")
-		return "", err
+		return nil, err
 	}
+	defer zero(dataKey)
 
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
 	if err != nil {
 		log.WithError(err).Error("Failed to decode base64 ciphertext")
-		return "", err
+		return nil, err
+	}
+	plaintext, err := open(dataKey, ciphertext, associatedData)
+	if err != nil {
+		log.WithError(err).Error("Failed to decrypt ciphertext")
+		return nil, err
 	}
+	return plaintext, nil
+}
 
-	if len(data) < aead.NonceSize() {
-		log.Error("Ciphertext too short")
-		return "", errors.New("ciphertext too short")
+// RewrapEnvelope re-wraps the data key of envelope under the current KEK. The
+// ciphertext itself is left untouched.
+func RewrapEnvelope(ctx context.Context, keyManager kms.KeyManager, envelope Envelope, associatedData []byte) (Envelope, error) {
+	dataKey, err := unwrapDataKey(ctx, keyManager, envelope, associatedData)
+	if err != nil {
+		return Envelope{}, err
 	}
+	defer zero(dataKey)
 
-	nonce, ciphertextBytes := data[:aead.NonceSize()], data[aead.NonceSize():]
-	plaintext, err := aead.Open(nil, nonce, ciphertextBytes, nil)
+	wrappedDataKey, kekID, err := keyManager.Wrap(ctx, dataKey, associatedData)
 	if err != nil {
-		log.WithError(err).Error("Failed to decrypt ciphertext")
-		return "", err
+		log.WithError(err).Error("Failed to wrap data key")
+		return Envelope{}, err
 	}
 
-	log.Debug("Decryption successful")
-	return string(plaintext), nil
+	return Envelope{
+		Ciphertext:     envelope.Ciphertext,
+		WrappedDataKey: base64.StdEncoding.EncodeToString(wrappedDataKey),
+		KEKID:          kekID,
+	}, nil
+}
+
+func unwrapDataKey(ctx context.Context, keyManager kms.KeyManager, envelope Envelope, associatedData []byte) ([]byte, error) {
+	if envelope.KEKID == "" {
+		return nil, kms.ErrUnknownKey
+	}
+	wrappedDataKey, err := base64.StdEncoding.DecodeString(envelope.WrappedDataKey)
+	if err != nil {
+		log.WithError(err).Error("Failed to decode wrapped data key")
+		return nil, err
+	}
+	dataKey, err := keyManager.Unwrap(ctx, envelope.KEKID, wrappedDataKey, associatedData)
+	if err != nil {
+		log.WithField("kek_id", envelope.KEKID).WithError(err).Error("Failed to unwrap data key")
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// seal encrypts with XChaCha20-Poly1305 and prepends the random nonce.
+func seal(k, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(k, data, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
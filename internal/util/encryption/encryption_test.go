@@ -1,30 +1,151 @@
 package encryption
 
 import (
+	"context"
+	"crypto-keygen-service/internal/util/kms"
+	"encoding/base64"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/stretchr/testify/assert"
 )
 
 const sampleEncryptionKey = "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
 
-func TestMain(m *testing.M) {
-	err := Setup(sampleEncryptionKey)
-	if err != nil {
-		panic("Failed to set up encryption: " + err.Error())
-	}
+func newKeyManager(t *testing.T, current kms.Key, retired ...kms.Key) kms.KeyManager {
+	keyManager, err := kms.NewLocalKeyManager(current, retired...)
+	assert.NoError(t, err)
+	return keyManager
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	originalText := "cRwricgLMcpyF4nXqJS8gDdfrtpfqjPmkq9K7EdUkJf79yPffY6N"
+
+	// Records written before envelope encryption were sealed directly under the key
+	sealed, _, err := keyManager.Wrap(ctx, []byte(originalText), nil)
+	assert.NoError(t, err)
+
+	decryptedText, err := Decrypt(ctx, keyManager, base64.StdEncoding.EncodeToString(sealed))
+	assert.NoError(t, err)
+	assert.Equal(t, originalText, decryptedText)
+}
+
+func TestEnvelopeEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
+	originalText := "cRwricgLMcpyF4nXqJS8gDdfrtpfqjPmkq9K7EdUkJf79yPffY6N"
+
+	envelope1, err := EncryptEnvelope(ctx, keyManager, originalText, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, keyManager.KeyID(), envelope1.KEKID)
+	assert.NotEmpty(t, envelope1.WrappedDataKey)
+
+	// Every record gets its own data key
+	envelope2, err := EncryptEnvelope(ctx, keyManager, originalText, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, envelope1.WrappedDataKey, envelope2.WrappedDataKey)
+
+	decryptedText, err := DecryptEnvelope(ctx, keyManager, envelope1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, originalText, decryptedText)
+
+	// A data key cannot open another record
+	swapped := envelope1
+	swapped.WrappedDataKey = envelope2.WrappedDataKey
+	_, err = DecryptEnvelope(ctx, keyManager, swapped, nil)
+	assert.Error(t, err)
+
+	unknown := envelope1
+	unknown.KEKID = "local:0000000000000000"
+	_, err = DecryptEnvelope(ctx, keyManager, unknown, nil)
+	assert.ErrorIs(t, err, kms.ErrUnknownKey)
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	const rotatedEncryptionKey = "qF3tHDT0VvDOFJVbHa3AZ4vxW2KDwVblQkGAq0pfUEs="
+	originalText := "cRwricgLMcpyF4nXqJS8gDdfrtpfqjPmkq9K7EdUkJf79yPffY6N"
+
+	v1 := newKeyManager(t, kms.Key{ID: "v1", Material: sampleEncryptionKey})
+	legacy, _, err := v1.Wrap(ctx, []byte(originalText), nil)
+	assert.NoError(t, err)
+	envelope, err := EncryptEnvelope(ctx, v1, originalText, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", envelope.KEKID)
 
-	m.Run()
+	// Rotate to v2, v1 stays available for decryption
+	v2 := newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey}, kms.Key{ID: "v1", Material: sampleEncryptionKey})
+	assert.Equal(t, "v2", v2.KeyID())
+
+	decryptedText, err := Decrypt(ctx, v2, base64.StdEncoding.EncodeToString(legacy))
+	assert.NoError(t, err)
+	assert.Equal(t, originalText, decryptedText)
+	decryptedText, err = DecryptEnvelope(ctx, v2, envelope, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, originalText, decryptedText)
+
+	rewrapped, err := RewrapEnvelope(ctx, v2, envelope, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", rewrapped.KEKID)
+	assert.Equal(t, envelope.Ciphertext, rewrapped.Ciphertext)
+
+	// Once v1 is retired for good only re-wrapped records remain readable
+	v2only := newKeyManager(t, kms.Key{ID: "v2", Material: rotatedEncryptionKey})
+	_, err = DecryptEnvelope(ctx, v2only, envelope, nil)
+	assert.ErrorIs(t, err, kms.ErrUnknownKey)
+	decryptedText, err = DecryptEnvelope(ctx, v2only, rewrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, originalText, decryptedText)
 }
 
-func TestEncryptDecrypt(t *testing.T) {
+func TestEnvelopeAssociatedData(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newKeyManager(t, kms.Key{Material: sampleEncryptionKey})
 	originalText := "cRwricgLMcpyF4nXqJS8gDdfrtpfqjPmkq9K7EdUkJf79yPffY6N"
+	associatedData := []byte(`{"user_id":1,"network":"bitcoin"}`)
 
-	encryptedText, err := Encrypt(originalText)
+	envelope, err := EncryptEnvelope(ctx, keyManager, originalText, associatedData)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, encryptedText)
 
-	decryptedText, err := Decrypt(encryptedText)
+	decryptedText, err := DecryptEnvelope(ctx, keyManager, envelope, associatedData)
 	assert.NoError(t, err)
 	assert.Equal(t, originalText, decryptedText)
+	assert.NoError(t, VerifyEnvelope(ctx, keyManager, envelope, associatedData))
+
+	_, err = DecryptEnvelope(ctx, keyManager, envelope, []byte(`{"user_id":2,"network":"bitcoin"}`))
+	assert.Error(t, err)
+	assert.Error(t, VerifyEnvelope(ctx, keyManager, envelope, nil))
+	_, err = RewrapEnvelope(ctx, keyManager, envelope, nil)
+	assert.Error(t, err)
+}
+
+func TestAgeEncrypt(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	privateKey := "cRwricgLMcpyF4nXqJS8gDdfrtpfqjPmkq9K7EdUkJf79yPffY6N"
+
+	encrypted, err := AgeEncrypt([]byte(privateKey), identity.Recipient().String(), "")
+	assert.NoError(t, err)
+	assert.Contains(t, encrypted, "-----BEGIN AGE ENCRYPTED FILE-----")
+	assert.NotContains(t, encrypted, privateKey)
+	decrypted, err := AgeDecrypt(encrypted, identity.String(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, string(decrypted))
+
+	other, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	_, err = AgeDecrypt(encrypted, other.String(), "")
+	assert.Error(t, err)
+
+	encrypted, err = AgeEncrypt([]byte(privateKey), "", "correct horse battery staple")
+	assert.NoError(t, err)
+	decrypted, err = AgeDecrypt(encrypted, "", "correct horse battery staple")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, string(decrypted))
+
+	assert.False(t, ValidAgeRecipient("age1invalid"))
+	_, err = AgeEncrypt([]byte(privateKey), "age1invalid", "")
+	assert.Equal(t, ErrInvalidRecipient, err)
 }
@@ -3,6 +3,7 @@ package errors
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -17,12 +18,76 @@ func (e *KeyGenError) Error() string {
 }
 
 var (
-	ErrUnsupportedNetwork  = &KeyGenError{Code: 400, Message: "Unsupported network"}
-	ErrInternalServerError = &KeyGenError{Code: 500, Message: "Internal server error"}
-	ErrInvalidUserID       = &KeyGenError{Code: 400, Message: "userId must be a positive integer"}
-	ErrNetworkRequired     = &KeyGenError{Code: 400, Message: "Network is required"}
+	ErrUnsupportedNetwork     = &KeyGenError{Code: 400, Message: "Unsupported network"}
+	ErrInternalServerError    = &KeyGenError{Code: 500, Message: "Internal server error"}
+	ErrInvalidUserID          = &KeyGenError{Code: 400, Message: "userId must be a positive integer"}
+	ErrNetworkRequired        = &KeyGenError{Code: 400, Message: "Network is required"}
+	ErrUserIDOutOfRange       = &KeyGenError{Code: 400, Message: "userId must be lower than 2147483648"}
+	ErrMainnetDisabled        = &KeyGenError{Code: 403, Message: "Mainnet networks are disabled in this environment"}
+	ErrKeyNotFound            = &KeyGenError{Code: 404, Message: "Key not found"}
+	ErrRevealDisabled         = &KeyGenError{Code: 403, Message: "Private key reveal is disabled"}
+	ErrUnauthorized           = &KeyGenError{Code: 401, Message: "Unauthorized"}
+	ErrForbidden              = &KeyGenError{Code: 403, Message: "The caller is not allowed to perform this action"}
+	ErrInvalidScope           = &KeyGenError{Code: 400, Message: "Unknown scope"}
+	ErrAccountKeyExport       = &KeyGenError{Code: 400, Message: "Extended public key export is not supported for this network"}
+	ErrRecordIntegrity        = &KeyGenError{Code: 500, Message: "Stored key record failed integrity verification"}
+	ErrWatchOnly              = &KeyGenError{Code: 400, Message: "No private key is stored for watch-only records"}
+	ErrNetworkExists          = &KeyGenError{Code: 409, Message: "Network already exists"}
+	ErrSealed                 = &KeyGenError{Code: 503, Message: "Service is sealed"}
+	ErrNotSealed              = &KeyGenError{Code: 400, Message: "Service is already unsealed"}
+	ErrInvalidShare           = &KeyGenError{Code: 400, Message: "Invalid unseal share"}
+	ErrUnsealFailed           = &KeyGenError{Code: 400, Message: "Shares do not combine to the master seed, unseal progress was reset"}
+	ErrRateLimited            = &KeyGenError{Code: 429, Message: "Rate limit exceeded"}
+	ErrRevealApprovalRequired = &KeyGenError{Code: 403, Message: "Private key reveal requires an approved reveal request"}
+	ErrRevealRequestNotFound  = &KeyGenError{Code: 404, Message: "Reveal request not found"}
+	ErrRevealQuorumDisabled   = &KeyGenError{Code: 400, Message: "Reveal requests are not enabled"}
+	ErrNotRevealApprover      = &KeyGenError{Code: 403, Message: "The caller is not a designated reveal approver"}
+	ErrSelfApproval           = &KeyGenError{Code: 403, Message: "Requesters cannot approve their own reveal request"}
+	ErrAlreadyApproved        = &KeyGenError{Code: 409, Message: "Reveal request was already approved"}
+	ErrRevealNotApproved      = &KeyGenError{Code: 409, Message: "Reveal request is not approved yet"}
+	ErrRevealRequestExpired   = &KeyGenError{Code: 410, Message: "Reveal request expired"}
+	ErrRevealAlreadyFetched   = &KeyGenError{Code: 410, Message: "Private key of this reveal request was already fetched"}
+	ErrExportFormat           = &KeyGenError{Code: 400, Message: "Export format is not supported for this network"}
+	ErrPassphraseRequired     = &KeyGenError{Code: 400, Message: "A passphrase is required to export a private key"}
+	ErrInvalidRecipient       = &KeyGenError{Code: 400, Message: "Recipient must be an age X25519 public key"}
+	ErrImportUnsupported      = &KeyGenError{Code: 400, Message: "Key import is not supported for this network"}
+	ErrAddressMismatch        = &KeyGenError{Code: 400, Message: "Address does not match the imported private key"}
+	ErrKeyExists              = &KeyGenError{Code: 409, Message: "Keys already exist for this user ID and network"}
+	ErrSigningUnsupported     = &KeyGenError{Code: 400, Message: "Message signing is not supported for this network"}
+	ErrAPIClientNotFound      = &KeyGenError{Code: 404, Message: "API client not found"}
+	ErrInvalidNetworkName     = &KeyGenError{Code: 400, Message: "Network names may only contain lowercase letters, digits and dashes"}
+	ErrRequestTooLarge        = &KeyGenError{Code: 413, Message: "Request body too large"}
 )
 
+// PolicyError is a request refused by the authorization policy. It is
+// answered with ErrForbidden and the reason, so callers can tell which part
+// of the request the policy does not allow.
+type PolicyError struct {
+	Action  string
+	Network string
+	UserID  int
+	// Reason is one of action_not_granted, network_not_allowed or
+	// user_id_not_allowed
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s denied by policy on network %q for user %d: %s", e.Action, e.Network, e.UserID, e.Reason)
+}
+
+// RateLimitError is a request refused because the caller, the user ID or
+// the whole service used up a rate limit. It is answered with 429 and a
+// Retry-After header.
+type RateLimitError struct {
+	// Limit is client, user, generation, ip, sys or passphrase_kdf
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %s", e.Limit, e.RetryAfter)
+}
+
 func NewKeyGenError(code int, message string) *KeyGenError {
 	return &KeyGenError{Code: code, Message: message}
 }
@@ -42,6 +107,14 @@ func FormatValidationError(err error) string {
 			if err.Tag() == "required" {
 				sb.WriteString("Network is required. ")
 			}
+		case "BaseNetwork":
+			if err.Tag() == "required" {
+				sb.WriteString("BaseNetwork is required. ")
+			}
+		case "ExtendedPublicKey":
+			if err.Tag() == "required" {
+				sb.WriteString("ExtendedPublicKey is required. ")
+			}
 		}
 	}
 	return strings.TrimSpace(sb.String())
@@ -0,0 +1,39 @@
+package kms
+
+import (
+	"fmt"
+	"os"
+)
+
+// ConfigFromEnv reads the key manager configuration shared by the service
+// and its commands:
+//
+//	KMS_BACKEND              backend, "local" by default
+//	KMS_KEYSTORE_FILE        local keystore file, replaces the variables below
+//	ENCRYPTION_KEY           current key-encryption key (base64)
+//	ENCRYPTION_KEY_ID        optional version label of ENCRYPTION_KEY
+//	ENCRYPTION_RETIRED_KEYS  id:base64 keys only used for decryption
+//	SIGNING_KEY              optional base64 signing key of the local backend
+//	PKCS11_MODULE, PKCS11_TOKEN_LABEL, PKCS11_PIN, PKCS11_KEY_LABEL and
+//	PKCS11_SIGNING_KEY_LABEL configure the pkcs11 backend
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Backend:      os.Getenv("KMS_BACKEND"),
+		KeystoreFile: os.Getenv("KMS_KEYSTORE_FILE"),
+		CurrentKey:   Key{ID: os.Getenv("ENCRYPTION_KEY_ID"), Material: os.Getenv("ENCRYPTION_KEY")},
+		SigningKey:   os.Getenv("SIGNING_KEY"),
+		PKCS11: PKCS11Config{
+			Module:          os.Getenv("PKCS11_MODULE"),
+			TokenLabel:      os.Getenv("PKCS11_TOKEN_LABEL"),
+			PIN:             os.Getenv("PKCS11_PIN"),
+			KeyLabel:        os.Getenv("PKCS11_KEY_LABEL"),
+			SigningKeyLabel: os.Getenv("PKCS11_SIGNING_KEY_LABEL"),
+		},
+	}
+	retired, err := ParseKeys(os.Getenv("ENCRYPTION_RETIRED_KEYS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("ENCRYPTION_RETIRED_KEYS: %w", err)
+	}
+	cfg.RetiredKeys = retired
+	return cfg, nil
+}
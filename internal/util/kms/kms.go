@@ -0,0 +1,97 @@
+// Package kms abstracts the key management system that holds the
+// key-encryption keys. The service only ever asks it to wrap, unwrap or sign,
+// so key material can stay inside a KMS or HSM.
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownKey         = errors.New("unknown key-encryption key")
+	ErrUnknownBackend     = errors.New("unknown key manager backend")
+	ErrSigningUnsupported = errors.New("key manager has no signing key")
+	ErrWiped              = errors.New("key manager has been wiped")
+)
+
+// KeyManager wraps data keys under its current key-encryption key and signs
+// digests. Retired keys only need to be available to Unwrap.
+type KeyManager interface {
+	// KeyID identifies the current key, new wraps are made with it.
+	KeyID() string
+	// Wrap seals plaintext under the current key. associatedData is
+	// authenticated and must be presented again to Unwrap.
+	Wrap(ctx context.Context, plaintext, associatedData []byte) (wrapped []byte, keyID string, err error)
+	// Unwrap opens data wrapped by the key identified by keyID.
+	Unwrap(ctx context.Context, keyID string, wrapped, associatedData []byte) ([]byte, error)
+	// Sign signs a 32 byte digest with the manager's secp256k1 signing key and
+	// returns the DER encoded ECDSA signature.
+	Sign(ctx context.Context, digest []byte) ([]byte, error)
+	// PublicKey returns the compressed public key that verifies Sign.
+	PublicKey(ctx context.Context) ([]byte, error)
+}
+
+// Wiper is implemented by key managers that can drop their key material, or
+// their access to it, from process memory. A wiped key manager fails every
+// operation with ErrWiped and has to be created again.
+type Wiper interface {
+	Wipe()
+}
+
+const (
+	BackendLocal  = "local"
+	BackendPKCS11 = "pkcs11"
+)
+
+// Config selects and configures a KeyManager backend.
+type Config struct {
+	Backend string
+	// KeystoreFile is a local keystore, see LoadKeystore. If empty the local
+	// backend uses CurrentKey and RetiredKeys.
+	KeystoreFile string
+	CurrentKey   Key
+	RetiredKeys  []Key
+	// SigningKey is the optional base64 signing key of the local backend,
+	// see LocalKeyManager.SetSigningKey. A keystore file brings its own.
+	SigningKey string
+	PKCS11     PKCS11Config
+}
+
+// PKCS11Config locates the keys of the pkcs11 backend on an HSM token.
+type PKCS11Config struct {
+	// Module is the path of the PKCS#11 library, e.g. libsofthsm2.so.
+	Module     string
+	TokenLabel string
+	PIN        string
+	// KeyLabel is the AES-256 key that wraps new data keys. Keys of earlier
+	// rotations stay on the token and are found by the label in the record.
+	KeyLabel string
+	// SigningKeyLabel is an optional secp256k1 key pair used by Sign.
+	SigningKeyLabel string
+}
+
+// New returns the KeyManager configured by cfg. An empty backend is local.
+func New(cfg Config) (KeyManager, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		if cfg.KeystoreFile != "" {
+			return LoadKeystore(cfg.KeystoreFile)
+		}
+		m, err := NewLocalKeyManager(cfg.CurrentKey, cfg.RetiredKeys...)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.SigningKey != "" {
+			if err := m.SetSigningKey(cfg.SigningKey); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case BackendPKCS11:
+		return newPKCS11KeyManager(cfg.PKCS11)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Backend)
+	}
+}
@@ -0,0 +1,307 @@
+package kms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Key is a base64 encoded key-encryption key and its version identifier. An
+// empty ID defaults to a fingerprint of the key.
+type Key struct {
+	ID       string `json:"id"`
+	Material string `json:"material"`
+}
+
+// LocalKeyManager keeps its keys in process memory. Wrapping uses
+// XChaCha20-Poly1305. The signing key is derived from a dedicated key, see
+// SetSigningKey, so that it survives rotations of the key-encryption key.
+type LocalKeyManager struct {
+	// mu guards the key material against Wipe
+	mu         sync.RWMutex
+	currentID  string
+	keys       map[string][]byte
+	signingKey *btcec.PrivateKey
+	wiped      bool
+}
+
+// NewLocalKeyManager uses current for all new wraps and keeps retired keys
+// for unwrapping records written before a rotation.
+func NewLocalKeyManager(current Key, retired ...Key) (*LocalKeyManager, error) {
+	m := &LocalKeyManager{keys: make(map[string][]byte, len(retired)+1)}
+	for i, k := range append([]Key{current}, retired...) {
+		decoded, err := decodeKey(k.Material)
+		if err != nil {
+			return nil, err
+		}
+		id := k.ID
+		if id == "" {
+			id = keyFingerprint(decoded)
+		}
+		if _, exists := m.keys[id]; exists {
+			log.WithField("kek_id", id).Error("Duplicate encryption key version")
+			return nil, fmt.Errorf("duplicate encryption key version %q", id)
+		}
+		m.keys[id] = decoded
+		if i == 0 {
+			m.currentID = id
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"kek_id":  m.currentID,
+		"retired": len(retired),
+	}).Info("Local key manager setup successful")
+	return m, nil
+}
+
+// SetSigningKey derives the secp256k1 signing key from material, a base64
+// encoded 32 byte key kept apart from the key-encryption keys. Without it
+// Sign and PublicKey fail with ErrSigningUnsupported.
+func (m *LocalKeyManager) SetSigningKey(material string) error {
+	decoded, err := base64.StdEncoding.DecodeString(material)
+	if err != nil || len(decoded) != chacha20poly1305.KeySize {
+		log.Errorf("Signing key must be %d bytes long and base64 encoded", chacha20poly1305.KeySize)
+		return fmt.Errorf("signing key must be %d bytes long and base64 encoded", chacha20poly1305.KeySize)
+	}
+	signingSeed := sha256.Sum256(append([]byte("crypto-keygen-service/signing-key/"), decoded...))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wiped {
+		return ErrWiped
+	}
+	m.signingKey, _ = btcec.PrivKeyFromBytes(signingSeed[:])
+	return nil
+}
+
+// keystoreFile is the on-disk format of a local keystore.
+type keystoreFile struct {
+	Current string `json:"current"`
+	Keys    []Key  `json:"keys"`
+	// Signing is the optional base64 signing key, see SetSigningKey
+	Signing string `json:"signing,omitempty"`
+}
+
+// LoadKeystore reads a JSON keystore of the form
+//
+//	{"current": "2024q3", "keys": [{"id": "2024q3", "material": "<base64>"}, ...], "signing": "<base64>"}
+//
+// where every key other than current is retired.
+func LoadKeystore(path string) (*LocalKeyManager, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keystore %s: %w", path, err)
+	}
+
+	var current *Key
+	var retired []Key
+	for i, k := range file.Keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("invalid keystore %s: key without id", path)
+		}
+		if k.ID == file.Current {
+			current = &file.Keys[i]
+		} else {
+			retired = append(retired, k)
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("invalid keystore %s: current key %q not found", path, file.Current)
+	}
+	m, err := NewLocalKeyManager(*current, retired...)
+	if err != nil {
+		return nil, err
+	}
+	if file.Signing != "" {
+		if err := m.SetSigningKey(file.Signing); err != nil {
+			return nil, fmt.Errorf("invalid keystore %s: %w", path, err)
+		}
+	}
+	return m, nil
+}
+
+// ParseKeys parses a comma separated list of id:base64 keys, as used for
+// ENCRYPTION_RETIRED_KEYS.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, material, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry, expected id:base64")
+		}
+		keys = append(keys, Key{ID: id, Material: material})
+	}
+	return keys, nil
+}
+
+func decodeKey(keyString string) ([]byte, error) {
+	if keyString == "" {
+		log.Error("ENCRYPTION_KEY not set")
+		return nil, errors.New("ENCRYPTION_KEY not set")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(keyString)
+	if err != nil {
+		log.WithError(err).Error("Invalid ENCRYPTION_KEY")
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEY: %w", err)
+	}
+
+	if len(decoded) != chacha20poly1305.KeySize {
+		log.Errorf("ENCRYPTION_KEY must be %d bytes long", chacha20poly1305.KeySize)
+		return nil, fmt.Errorf("ENCRYPTION_KEY must be %d bytes long", chacha20poly1305.KeySize)
+	}
+	return decoded, nil
+}
+
+// keyFingerprint identifies a key without revealing it.
+func keyFingerprint(k []byte) string {
+	sum := sha256.Sum256(append([]byte("crypto-keygen-service/kek-id/"), k...))
+	return "local:" + hex.EncodeToString(sum[:8])
+}
+
+func (m *LocalKeyManager) KeyID() string {
+	return m.currentID
+}
+
+// Wipe zeroes all keys, see Wiper.
+func (m *LocalKeyManager) Wipe() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, k := range m.keys {
+		for i := range k {
+			k[i] = 0
+		}
+		delete(m.keys, id)
+	}
+	if m.signingKey != nil {
+		m.signingKey.Zero()
+		m.signingKey = nil
+	}
+	m.wiped = true
+	log.WithField("kek_id", m.currentID).Warn("Local key manager wiped")
+}
+
+func (m *LocalKeyManager) Wrap(_ context.Context, plaintext, associatedData []byte) ([]byte, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.wiped {
+		return nil, "", ErrWiped
+	}
+	wrapped, err := seal(m.keys[m.currentID], plaintext, associatedData)
+	if err != nil {
+		return nil, "", err
+	}
+	return wrapped, m.currentID, nil
+}
+
+// Unwrap opens wrapped with the key identified by keyID. An empty keyID is
+// used for records that predate key versioning and tries every key, current
+// key first.
+func (m *LocalKeyManager) Unwrap(_ context.Context, keyID string, wrapped, associatedData []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.wiped {
+		return nil, ErrWiped
+	}
+	if keyID != "" {
+		k, ok := m.keys[keyID]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return open(k, wrapped, associatedData)
+	}
+
+	plaintext, err := open(m.keys[m.currentID], wrapped, associatedData)
+	for id, k := range m.keys {
+		if err == nil {
+			break
+		}
+		if id != m.currentID {
+			plaintext, err = open(k, wrapped, associatedData)
+		}
+	}
+	return plaintext, err
+}
+
+func (m *LocalKeyManager) Sign(_ context.Context, digest []byte) ([]byte, error) {
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("digest must be %d bytes long", sha256.Size)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkSigningKey(); err != nil {
+		return nil, err
+	}
+	return ecdsa.Sign(m.signingKey, digest).Serialize(), nil
+}
+
+func (m *LocalKeyManager) PublicKey(context.Context) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkSigningKey(); err != nil {
+		return nil, err
+	}
+	return m.signingKey.PubKey().SerializeCompressed(), nil
+}
+
+func (m *LocalKeyManager) checkSigningKey() error {
+	switch {
+	case m.wiped:
+		return ErrWiped
+	case m.signingKey == nil:
+		return ErrSigningUnsupported
+	}
+	return nil
+}
+
+// seal encrypts with XChaCha20-Poly1305 and prepends the random nonce.
+func seal(k, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(k, data, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
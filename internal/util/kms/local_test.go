@@ -0,0 +1,131 @@
+package kms
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	sampleEncryptionKey  = "4GRrhM8ClnrSmCrDvyFzPKdkJF9NcRkKwxlmIrsYhx0="
+	rotatedEncryptionKey = "qF3tHDT0VvDOFJVbHa3AZ4vxW2KDwVblQkGAq0pfUEs="
+	signingKey           = "n1Zp2QvXh0m8T4kS7eYbR3cWq6LjA5uFd9gHs2NtKxE="
+)
+
+func TestLocalWrapUnwrap(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewLocalKeyManager(Key{Material: sampleEncryptionKey})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^local:[0-9a-f]{16}$`, m.KeyID())
+
+	wrapped, keyID, err := m.Wrap(ctx, []byte("data key"), []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, m.KeyID(), keyID)
+
+	plaintext, err := m.Unwrap(ctx, keyID, wrapped, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(plaintext))
+
+	_, err = m.Unwrap(ctx, keyID, wrapped, []byte("other"))
+	assert.Error(t, err)
+	_, err = m.Unwrap(ctx, "v9", wrapped, []byte("ad"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = NewLocalKeyManager(Key{ID: "v2", Material: rotatedEncryptionKey}, Key{ID: "v2", Material: sampleEncryptionKey})
+	assert.Error(t, err)
+	_, err = NewLocalKeyManager(Key{Material: "c2hvcnQ="})
+	assert.Error(t, err)
+}
+
+func TestLocalSign(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewLocalKeyManager(Key{Material: sampleEncryptionKey})
+	assert.NoError(t, err)
+	_, err = m.Sign(ctx, make([]byte, sha256.Size))
+	assert.ErrorIs(t, err, ErrSigningUnsupported)
+	assert.NoError(t, m.SetSigningKey(signingKey))
+
+	digest := sha256.Sum256([]byte("audit checkpoint"))
+	der, err := m.Sign(ctx, digest[:])
+	assert.NoError(t, err)
+	compressed, err := m.PublicKey(ctx)
+	assert.NoError(t, err)
+
+	signature, err := ecdsa.ParseDERSignature(der)
+	assert.NoError(t, err)
+	publicKey, err := btcec.ParsePubKey(compressed)
+	assert.NoError(t, err)
+	assert.True(t, signature.Verify(digest[:], publicKey))
+
+	_, err = m.Sign(ctx, []byte("not a digest"))
+	assert.Error(t, err)
+
+	// Rotating the key-encryption key keeps the signing key
+	rotated, err := New(Config{CurrentKey: Key{Material: rotatedEncryptionKey}, RetiredKeys: []Key{{Material: sampleEncryptionKey}}, SigningKey: signingKey})
+	assert.NoError(t, err)
+	rotatedPublicKey, err := rotated.PublicKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, compressed, rotatedPublicKey)
+
+	m.Wipe()
+	_, err = m.Sign(ctx, digest[:])
+	assert.ErrorIs(t, err, ErrWiped)
+}
+
+func TestLoadKeystore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	keystore := `{"current": "v2", "keys": [
+		{"id": "v1", "material": "` + sampleEncryptionKey + `"},
+		{"id": "v2", "material": "` + rotatedEncryptionKey + `"}
+	], "signing": "` + signingKey + `"}`
+	assert.NoError(t, os.WriteFile(path, []byte(keystore), 0o600))
+
+	m, err := New(Config{KeystoreFile: path})
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", m.KeyID())
+	_, err = m.PublicKey(ctx)
+	assert.NoError(t, err)
+
+	// v1 is retired but still unwraps
+	v1, err := NewLocalKeyManager(Key{ID: "v1", Material: sampleEncryptionKey})
+	assert.NoError(t, err)
+	wrapped, _, err := v1.Wrap(ctx, []byte("data key"), nil)
+	assert.NoError(t, err)
+	plaintext, err := m.Unwrap(ctx, "v1", wrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(plaintext))
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"current": "v3", "keys": []}`), 0o600))
+	_, err = LoadKeystore(path)
+	assert.Error(t, err)
+
+	_, err = New(Config{Backend: "cloud"})
+	assert.ErrorIs(t, err, ErrUnknownBackend)
+}
+
+func TestWrapSeed(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewLocalKeyManager(Key{ID: "v1", Material: sampleEncryptionKey})
+	assert.NoError(t, err)
+
+	wrapped, err := WrapSeed(ctx, m, []byte("sample-master-seed"))
+	assert.NoError(t, err)
+	assert.Regexp(t, `^v1:`, wrapped)
+
+	seed, err := UnwrapSeed(ctx, m, wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, "sample-master-seed", string(seed))
+
+	// A wrapped seed is not a data key
+	_, err = m.Unwrap(ctx, "v1", []byte(wrapped[3:]), nil)
+	assert.Error(t, err)
+	_, err = UnwrapSeed(ctx, m, "no key id")
+	assert.Error(t, err)
+}
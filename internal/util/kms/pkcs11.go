@@ -0,0 +1,275 @@
+//go:build cgo
+
+package kms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/miekg/pkcs11"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pkcs11KeyIDPrefix = "pkcs11:"
+	gcmNonceSize      = 12
+	gcmTagBits        = 128
+)
+
+// PKCS11KeyManager keeps its keys on an HSM token. Data keys are wrapped with
+// AES-256-GCM and digests signed with a secp256k1 key pair, neither key ever
+// leaves the token.
+type PKCS11KeyManager struct {
+	// mu serializes use of the session, PKCS#11 sessions are not safe for
+	// concurrent use.
+	mu              sync.Mutex
+	ctx             *pkcs11.Ctx
+	session         pkcs11.SessionHandle
+	keyLabel        string
+	signingKeyLabel string
+	closed          bool
+}
+
+func newPKCS11KeyManager(cfg PKCS11Config) (KeyManager, error) {
+	return NewPKCS11KeyManager(cfg)
+}
+
+// NewPKCS11KeyManager loads the PKCS#11 module, logs into the token labelled
+// cfg.TokenLabel and checks that the wrapping key exists.
+func NewPKCS11KeyManager(cfg PKCS11Config) (*PKCS11KeyManager, error) {
+	if cfg.Module == "" || cfg.TokenLabel == "" || cfg.KeyLabel == "" {
+		return nil, errors.New("PKCS11_MODULE, PKCS11_TOKEN_LABEL and PKCS11_KEY_LABEL must be set")
+	}
+
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %s", cfg.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module: %w", err)
+	}
+
+	m := &PKCS11KeyManager{ctx: ctx, keyLabel: cfg.KeyLabel, signingKeyLabel: cfg.SigningKeyLabel}
+	if err := m.open(cfg.TokenLabel, cfg.PIN); err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	if _, err := m.findObject(pkcs11.CKO_SECRET_KEY, cfg.KeyLabel); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"token":  cfg.TokenLabel,
+		"kek_id": m.KeyID(),
+	}).Info("PKCS#11 key manager setup successful")
+	return m, nil
+}
+
+func (m *PKCS11KeyManager) open(tokenLabel, pin string) error {
+	slots, err := m.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := m.ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != tokenLabel {
+			continue
+		}
+		session, err := m.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return fmt.Errorf("failed to open PKCS#11 session: %w", err)
+		}
+		err = m.ctx.Login(session, pkcs11.CKU_USER, pin)
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			m.ctx.CloseSession(session)
+			return fmt.Errorf("failed to log into PKCS#11 token: %w", err)
+		}
+		m.session = session
+		return nil
+	}
+	return fmt.Errorf("PKCS#11 token %q not found", tokenLabel)
+}
+
+// Wipe logs out of the token, the keys themselves never leave the HSM.
+func (m *PKCS11KeyManager) Wipe() {
+	if err := m.Close(); err != nil {
+		log.WithError(err).Error("Failed to close PKCS#11 session")
+	}
+}
+
+// Close logs out of the token and unloads the module.
+func (m *PKCS11KeyManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.ctx.Logout(m.session)
+	m.ctx.CloseSession(m.session)
+	err := m.ctx.Finalize()
+	m.ctx.Destroy()
+	return err
+}
+
+// findObject must be called with mu held or before the manager is shared.
+func (m *PKCS11KeyManager) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	if m.closed {
+		return 0, ErrWiped
+	}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := m.ctx.FindObjectsInit(m.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := m.ctx.FindObjects(m.session, 1)
+	if finalErr := m.ctx.FindObjectsFinal(m.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("%w: PKCS#11 object %q", ErrUnknownKey, label)
+	}
+	return objects[0], nil
+}
+
+func (m *PKCS11KeyManager) KeyID() string {
+	return pkcs11KeyIDPrefix + m.keyLabel
+}
+
+// Wrap encrypts with AES-256-GCM on the token and prepends the nonce.
+func (m *PKCS11KeyManager) Wrap(_ context.Context, plaintext, associatedData []byte) ([]byte, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.findObject(pkcs11.CKO_SECRET_KEY, m.keyLabel)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, "", err
+	}
+
+	params := pkcs11.NewGCMParams(nonce, associatedData, gcmTagBits)
+	defer params.Free()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := m.ctx.EncryptInit(m.session, mechanism, key); err != nil {
+		return nil, "", err
+	}
+	ciphertext, err := m.ctx.Encrypt(m.session, plaintext)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(nonce, ciphertext...), m.KeyID(), nil
+}
+
+// Unwrap decrypts with the token key named by keyID. Records that predate
+// key versioning do not name a key and must be migrated with the local
+// backend first.
+func (m *PKCS11KeyManager) Unwrap(_ context.Context, keyID string, wrapped, associatedData []byte) ([]byte, error) {
+	label, ok := strings.CutPrefix(keyID, pkcs11KeyIDPrefix)
+	if !ok || label == "" {
+		return nil, ErrUnknownKey
+	}
+	if len(wrapped) < gcmNonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.findObject(pkcs11.CKO_SECRET_KEY, label)
+	if err != nil {
+		return nil, err
+	}
+	params := pkcs11.NewGCMParams(wrapped[:gcmNonceSize], associatedData, gcmTagBits)
+	defer params.Free()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := m.ctx.DecryptInit(m.session, mechanism, key); err != nil {
+		return nil, err
+	}
+	return m.ctx.Decrypt(m.session, wrapped[gcmNonceSize:])
+}
+
+// Sign signs digest with CKM_ECDSA and converts the raw r||s signature to a
+// low-S DER signature.
+func (m *PKCS11KeyManager) Sign(_ context.Context, digest []byte) ([]byte, error) {
+	if m.signingKeyLabel == "" {
+		return nil, ErrSigningUnsupported
+	}
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("digest must be %d bytes long", sha256.Size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.findObject(pkcs11.CKO_PRIVATE_KEY, m.signingKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.ctx.SignInit(m.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, key); err != nil {
+		return nil, err
+	}
+	raw, err := m.ctx.Sign(m.session, digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 64 {
+		return nil, fmt.Errorf("unexpected ECDSA signature length %d, is %q a secp256k1 key?", len(raw), m.signingKeyLabel)
+	}
+
+	var r, s btcec.ModNScalar
+	r.SetByteSlice(raw[:32])
+	s.SetByteSlice(raw[32:])
+	if s.IsOverHalfOrder() {
+		s.Negate()
+	}
+	return ecdsa.NewSignature(&r, &s).Serialize(), nil
+}
+
+func (m *PKCS11KeyManager) PublicKey(context.Context) ([]byte, error) {
+	if m.signingKeyLabel == "" {
+		return nil, ErrSigningUnsupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, err := m.findObject(pkcs11.CKO_PUBLIC_KEY, m.signingKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := m.ctx.GetAttributeValue(m.session, key, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// CKA_EC_POINT is a DER encoded OCTET STRING holding the point
+	var point []byte
+	if _, err := asn1.Unmarshal(attributes[0].Value, &point); err != nil {
+		return nil, fmt.Errorf("invalid CKA_EC_POINT: %w", err)
+	}
+	publicKey, err := btcec.ParsePubKey(point)
+	if err != nil {
+		return nil, err
+	}
+	return publicKey.SerializeCompressed(), nil
+}
@@ -0,0 +1,9 @@
+//go:build !cgo
+
+package kms
+
+import "errors"
+
+func newPKCS11KeyManager(PKCS11Config) (KeyManager, error) {
+	return nil, errors.New("the pkcs11 key manager requires a build with cgo enabled")
+}
@@ -0,0 +1,190 @@
+//go:build cgo
+
+package kms
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTokenLabel = "keygen-test"
+	testSOPIN      = "12345678"
+	testUserPIN    = "1234"
+)
+
+// softHSMModule locates libsofthsm2.so, the tests are skipped without it.
+func softHSMModule(t *testing.T) string {
+	for _, path := range []string{
+		os.Getenv("SOFTHSM2_MODULE"),
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	t.Skip("SoftHSM2 not found, install softhsm2 or set SOFTHSM2_MODULE")
+	return ""
+}
+
+// newSoftHSMToken initializes a token in a fresh SoftHSM2 store with an AES
+// wrapping key per label and a secp256k1 signing key pair.
+func newSoftHSMToken(t *testing.T, keyLabels ...string) PKCS11Config {
+	module := softHSMModule(t)
+
+	dir := t.TempDir()
+	tokenDir := filepath.Join(dir, "tokens")
+	require.NoError(t, os.Mkdir(tokenDir, 0o700))
+	conf := filepath.Join(dir, "softhsm2.conf")
+	require.NoError(t, os.WriteFile(conf, []byte("directories.tokendir = "+tokenDir+"\nobjectstore.backend = file\nlog.level = ERROR\n"), 0o600))
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	ctx := pkcs11.New(module)
+	require.NotNil(t, ctx)
+	require.NoError(t, ctx.Initialize())
+	defer func() {
+		ctx.Finalize()
+		ctx.Destroy()
+	}()
+
+	slots, err := ctx.GetSlotList(true)
+	require.NoError(t, err)
+	require.NotEmpty(t, slots)
+	require.NoError(t, ctx.InitToken(slots[0], testSOPIN, testTokenLabel))
+
+	// SoftHSM moves an initialized token to a new slot
+	slots, err = ctx.GetSlotList(true)
+	require.NoError(t, err)
+	var slot uint
+	for _, s := range slots {
+		info, err := ctx.GetTokenInfo(s)
+		if err == nil && strings.TrimSpace(info.Label) == testTokenLabel {
+			slot = s
+		}
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	require.NoError(t, err)
+	defer ctx.CloseSession(session)
+	require.NoError(t, ctx.Login(session, pkcs11.CKU_SO, testSOPIN))
+	require.NoError(t, ctx.InitPIN(session, testUserPIN))
+	require.NoError(t, ctx.Logout(session))
+	require.NoError(t, ctx.Login(session, pkcs11.CKU_USER, testUserPIN))
+	defer ctx.Logout(session)
+
+	for _, label := range keyLabels {
+		_, err := ctx.GenerateKey(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		})
+		require.NoError(t, err)
+	}
+
+	secp256k1, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 10})
+	require.NoError(t, err)
+	_, _, err = ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "signing"),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, secp256k1),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "signing"),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		})
+	require.NoError(t, err)
+
+	return PKCS11Config{
+		Module:          module,
+		TokenLabel:      testTokenLabel,
+		PIN:             testUserPIN,
+		KeyLabel:        keyLabels[0],
+		SigningKeyLabel: "signing",
+	}
+}
+
+func TestPKCS11WrapUnwrap(t *testing.T) {
+	ctx := context.Background()
+	cfg := newSoftHSMToken(t, "kek-v1", "kek-v2")
+
+	v1, err := New(Config{Backend: BackendPKCS11, PKCS11: cfg})
+	require.NoError(t, err)
+	assert.Equal(t, "pkcs11:kek-v1", v1.KeyID())
+
+	wrapped, keyID, err := v1.Wrap(ctx, []byte("data key"), []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pkcs11:kek-v1", keyID)
+
+	plaintext, err := v1.Unwrap(ctx, keyID, wrapped, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(plaintext))
+	_, err = v1.Unwrap(ctx, keyID, wrapped, []byte("other"))
+	assert.Error(t, err)
+	_, err = v1.Unwrap(ctx, "", wrapped, []byte("ad"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.NoError(t, v1.(*PKCS11KeyManager).Close())
+
+	// After rotating to kek-v2 the v1 key is found by the label in keyID
+	cfg.KeyLabel = "kek-v2"
+	v2, err := NewPKCS11KeyManager(cfg)
+	require.NoError(t, err)
+	defer v2.Close()
+	assert.Equal(t, "pkcs11:kek-v2", v2.KeyID())
+	plaintext, err = v2.Unwrap(ctx, keyID, wrapped, []byte("ad"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(plaintext))
+
+	_, err = v2.Unwrap(ctx, "pkcs11:kek-v9", wrapped, []byte("ad"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	cfg.KeyLabel = "kek-v9"
+	_, err = NewPKCS11KeyManager(cfg)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestPKCS11Sign(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewPKCS11KeyManager(newSoftHSMToken(t, "kek-v1"))
+	require.NoError(t, err)
+	defer m.Close()
+
+	digest := sha256.Sum256([]byte("audit checkpoint"))
+	der, err := m.Sign(ctx, digest[:])
+	assert.NoError(t, err)
+	compressed, err := m.PublicKey(ctx)
+	assert.NoError(t, err)
+
+	signature, err := ecdsa.ParseDERSignature(der)
+	assert.NoError(t, err)
+	publicKey, err := btcec.ParsePubKey(compressed)
+	assert.NoError(t, err)
+	assert.True(t, signature.Verify(digest[:], publicKey))
+}
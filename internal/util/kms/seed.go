@@ -0,0 +1,36 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// seedAssociatedData keeps a wrapped seed from being unwrapped as a data key
+// and vice versa.
+var seedAssociatedData = []byte("crypto-keygen-service/master-seed")
+
+// WrapSeed seals the master seed with the current key, so that only the
+// wrapped form has to be stored in the deployment configuration. The result
+// has the form <key id>:<base64>.
+func WrapSeed(ctx context.Context, keyManager KeyManager, seed []byte) (string, error) {
+	wrapped, keyID, err := keyManager.Wrap(ctx, seed, seedAssociatedData)
+	if err != nil {
+		return "", err
+	}
+	return keyID + ":" + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapSeed opens a seed sealed by WrapSeed.
+func UnwrapSeed(ctx context.Context, keyManager KeyManager, wrappedSeed string) ([]byte, error) {
+	i := strings.LastIndex(wrappedSeed, ":")
+	if i <= 0 {
+		return nil, errors.New("invalid wrapped seed, expected <key id>:<base64>")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedSeed[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return keyManager.Unwrap(ctx, wrappedSeed[:i], wrapped, seedAssociatedData)
+}
@@ -0,0 +1,40 @@
+package mnemonic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// DefaultEntropyBits yields a 24 word mnemonic.
+const DefaultEntropyBits = 256
+
+var ErrInvalidMnemonic = errors.New("invalid BIP39 mnemonic")
+
+// Generate returns a new English BIP39 mnemonic with the given amount of
+// entropy (128, 160, 192, 224 or 256 bits).
+func Generate(entropyBits int) (string, error) {
+	entropy, err := bip39.NewEntropy(entropyBits)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate entropy: %w", err)
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// Normalize collapses the whitespace between words so that mnemonics copied
+// from paper backups or multi-line env files are accepted.
+func Normalize(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// ToSeed validates the mnemonic checksum and stretches it with the optional
+// passphrase into a 64 byte seed (PBKDF2-HMAC-SHA512, 2048 rounds).
+func ToSeed(mnemonic, passphrase string) ([]byte, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(Normalize(mnemonic), passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMnemonic, err)
+	}
+	return seed, nil
+}
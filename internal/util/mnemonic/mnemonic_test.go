@@ -0,0 +1,42 @@
+package mnemonic
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const vectorMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestToSeed(t *testing.T) {
+	seed, err := ToSeed(vectorMnemonic, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4", hex.EncodeToString(seed))
+
+	withPassphrase, err := ToSeed(vectorMnemonic, "TREZOR")
+	assert.NoError(t, err)
+	assert.NotEqual(t, seed, withPassphrase)
+
+	// Extra whitespace and line breaks are tolerated.
+	spaced, err := ToSeed("  "+strings.ReplaceAll(vectorMnemonic, " ", "\n  ")+" ", "")
+	assert.NoError(t, err)
+	assert.Equal(t, seed, spaced)
+}
+
+func TestToSeedRejectsBadChecksum(t *testing.T) {
+	_, err := ToSeed(strings.Replace(vectorMnemonic, "about", "abandon", 1), "")
+	assert.ErrorIs(t, err, ErrInvalidMnemonic)
+}
+
+func TestGenerate(t *testing.T) {
+	m, err := Generate(DefaultEntropyBits)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Fields(m), 24)
+	_, err = ToSeed(m, "")
+	assert.NoError(t, err)
+
+	_, err = Generate(100)
+	assert.Error(t, err)
+}
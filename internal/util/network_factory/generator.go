@@ -1,11 +1,109 @@
 package network_factory
 
 type KeyPairAndAddress struct {
-	PublicKey  string
-	PrivateKey string
-	Address    string
+	PublicKey      string
+	PrivateKey     string
+	Address        string
+	DerivationPath string
+	AddressType    string
+	Chain          string
+	ChainID        uint64
+	// WatchOnly marks keys derived from an extended public key, they never
+	// have a private key.
+	WatchOnly bool
+	// Imported marks keys generated elsewhere and imported into the store.
+	Imported bool
+}
+
+// Public returns a copy without the private key.
+func (k KeyPairAndAddress) Public() KeyPairAndAddress {
+	k.PrivateKey = ""
+	return k
 }
 
 type KeyGenerator interface {
 	GenerateKeyPairAndAddress(userID int) (KeyPairAndAddress, error)
 }
+
+// AccountKey is the account-level extended public key from which every
+// address of a network is derived at <DerivationPath>/0/userID.
+type AccountKey struct {
+	ExtendedPublicKey string
+	DerivationPath    string
+	// MasterFingerprint identifies the master key the account belongs to.
+	MasterFingerprint string
+}
+
+// AccountKeyExporter is implemented by generators that can export their
+// account-level extended public key for watch-only use.
+type AccountKeyExporter interface {
+	AccountKey() (AccountKey, error)
+}
+
+// PublicKeyDescriber is implemented by generators that can turn a public key
+// derived elsewhere, e.g. from a registered extended public key, into an
+// address record of their network.
+type PublicKeyDescriber interface {
+	// DescribePublicKey fills everything but PrivateKey and DerivationPath
+	// for a compressed secp256k1 public key.
+	DescribePublicKey(publicKey []byte) (KeyPairAndAddress, error)
+	// AccountPath is the standard path of account 0 on this network.
+	AccountPath() string
+	// ExtendedPublicKeyVersions are the version bytes of the extended public
+	// keys of this network, e.g. those of xpub, ypub and zpub on Bitcoin
+	// mainnet.
+	ExtendedPublicKeyVersions() [][]byte
+}
+
+// ChainInfo is implemented by generators that know whether they produce keys
+// for a production chain, so that deployments can refuse mainnet generation.
+type ChainInfo interface {
+	IsMainnet() bool
+}
+
+// PrivateKeyEncrypter is implemented by generators whose private keys have a
+// standard passphrase-encrypted encoding, e.g. BIP38 for Bitcoin.
+type PrivateKeyEncrypter interface {
+	// EncryptedKeyFormat names the encoding, e.g. "bip38".
+	EncryptedKeyFormat() string
+	EncryptPrivateKey(privateKey, passphrase string) (string, error)
+}
+
+// PrivateKeyImporter is implemented by generators that can take over private
+// keys generated elsewhere.
+type PrivateKeyImporter interface {
+	// ImportPrivateKey decodes privateKey, in one of the encodings of the
+	// network and decrypted with passphrase if it is encrypted, and describes
+	// it like a generated key but without DerivationPath.
+	ImportPrivateKey(privateKey, passphrase string) (KeyPairAndAddress, error)
+}
+
+// MessageSignature is a signature of a message by the key of an address, in
+// the encoding wallets of the network exchange them.
+type MessageSignature struct {
+	// Scheme names the signature format, e.g. eip-191 or bip-137.
+	Scheme    string
+	Signature string
+	// Digest is the hash that was signed, set for structured data so that
+	// callers can audit what they signed.
+	Digest string
+}
+
+// MessageSigner is implemented by generators whose keys can sign messages,
+// e.g. to prove the ownership of an address.
+type MessageSigner interface {
+	// SignMessage signs message with privateKey, in the encoding of
+	// KeyPairAndAddress.PrivateKey.
+	SignMessage(privateKey string, message []byte) (MessageSignature, error)
+	// VerifyMessage reports whether signature is a signature of message by
+	// the key of address, it fails for malformed addresses and signatures.
+	VerifyMessage(address string, message []byte, signature string) (bool, error)
+}
+
+// TypedDataSigner is implemented by generators whose keys can sign typed
+// structured data, e.g. EIP-712 for Ethereum.
+type TypedDataSigner interface {
+	// SignTypedData validates and hashes typedData, JSON in the format of the
+	// network, and signs the digest with privateKey.
+	SignTypedData(privateKey string, typedData []byte) (MessageSignature, error)
+}
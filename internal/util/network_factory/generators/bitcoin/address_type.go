@@ -0,0 +1,72 @@
+package bitcoin
+
+import (
+	"crypto-keygen-service/internal/util/network_factory/hd"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// SLIP-132 version bytes of SegWit extended public keys.
+var (
+	ypubVersion = []byte{0x04, 0x9d, 0x7c, 0xb2}
+	zpubVersion = []byte{0x04, 0xb2, 0x47, 0x46}
+	upubVersion = []byte{0x04, 0x4a, 0x52, 0x62}
+	vpubVersion = []byte{0x04, 0x5f, 0x1c, 0xf6}
+)
+
+type AddressType string
+
+const (
+	P2PKH      AddressType = "p2pkh"
+	P2SHP2WPKH AddressType = "p2sh-p2wpkh"
+	P2WPKH     AddressType = "p2wpkh"
+	P2TR       AddressType = "p2tr"
+)
+
+// DefaultAddressType is used when no address type is configured, keeping the
+// legacy behaviour of the bare "bitcoin" network.
+const DefaultAddressType = P2PKH
+
+var AddressTypes = []AddressType{P2PKH, P2SHP2WPKH, P2WPKH, P2TR}
+
+func IsAddressType(s string) bool {
+	for _, t := range AddressTypes {
+		if string(t) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Purpose returns the BIP44/49/84/86 purpose used to derive this address type.
+func (t AddressType) Purpose() uint32 {
+	switch t {
+	case P2SHP2WPKH:
+		return hd.PurposeBIP49
+	case P2WPKH:
+		return hd.PurposeBIP84
+	case P2TR:
+		return hd.PurposeBIP86
+	default:
+		return hd.PurposeBIP44
+	}
+}
+
+// ExtendedPublicKeyVersion returns the version bytes that wallets expect for
+// account keys of this address type: xpub/tpub for P2PKH and P2TR, ypub/upub
+// for P2SH-P2WPKH and zpub/vpub for P2WPKH.
+func (t AddressType) ExtendedPublicKeyVersion(params *chaincfg.Params) []byte {
+	mainnet := params.Net == chaincfg.MainNetParams.Net
+	switch {
+	case t == P2SHP2WPKH && mainnet:
+		return ypubVersion
+	case t == P2SHP2WPKH:
+		return upubVersion
+	case t == P2WPKH && mainnet:
+		return zpubVersion
+	case t == P2WPKH:
+		return vpubVersion
+	default:
+		return params.HDPublicKeyID[:]
+	}
+}
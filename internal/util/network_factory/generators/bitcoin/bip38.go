@@ -0,0 +1,140 @@
+package bitcoin
+
+import (
+	"crypto/aes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/chaincfg"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
+)
+
+// FormatBIP38 is the passphrase-encrypted private key encoding of Bitcoin
+// keys, see EncryptBIP38.
+const FormatBIP38 = "bip38"
+
+// BIP38 non EC-multiplied keys: prefix 0x0142, then a flag byte with
+// bit 0x20 set for compressed public keys.
+const (
+	bip38FlagCompressed = 0x20
+	bip38FlagNoECMult   = 0xc0
+)
+
+var (
+	errWrongNetwork = errors.New("private key is not for this network")
+	// ErrBIP38Passphrase is returned when a BIP38 key does not decrypt with
+	// the passphrase.
+	ErrBIP38Passphrase = errors.New("wrong BIP38 passphrase")
+	// ErrBIP38Unsupported is returned for malformed and EC-multiplied keys.
+	ErrBIP38Unsupported = errors.New("not a BIP38 non EC-multiplied key")
+)
+
+// EncryptBIP38 encrypts wif with passphrase as a BIP38 non EC-multiplied key
+// (6P...). The address hash is taken from the P2PKH address of the key on
+// params, as the BIP specifies whatever address type the key is used with.
+func EncryptBIP38(wif *btcutil.WIF, passphrase string, params *chaincfg.Params) (string, error) {
+	if !wif.IsForNet(params) {
+		return "", errWrongNetwork
+	}
+	address, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.SerializePubKey()), params)
+	if err != nil {
+		return "", err
+	}
+	addressHash := doubleSHA256([]byte(address.EncodeAddress()))[:4]
+
+	derived, err := scrypt.Key(norm.NFC.Bytes([]byte(passphrase)), addressHash, 16384, 8, 8, 64)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(derived[32:])
+	if err != nil {
+		return "", err
+	}
+	privateKey := wif.PrivKey.Key.Bytes()
+	encrypted := make([]byte, 32)
+	for i := 0; i < 32; i++ {
+		encrypted[i] = privateKey[i] ^ derived[i]
+	}
+	// AES-256 in ECB mode on the two halves
+	block.Encrypt(encrypted[:16], encrypted[:16])
+	block.Encrypt(encrypted[16:], encrypted[16:])
+
+	flag := byte(bip38FlagNoECMult)
+	if wif.CompressPubKey {
+		flag |= bip38FlagCompressed
+	}
+	payload := append([]byte{0x01, 0x42, flag}, addressHash...)
+	payload = append(payload, encrypted...)
+	return base58.Encode(append(payload, doubleSHA256(payload)[:4]...)), nil
+}
+
+// DecryptBIP38 decrypts a BIP38 non EC-multiplied key to a WIF of params.
+// EC-multiplied keys, made with intermediate codes, are not supported.
+func DecryptBIP38(encrypted, passphrase string, params *chaincfg.Params) (*btcutil.WIF, error) {
+	decoded := base58.Decode(encrypted)
+	if len(decoded) != 43 || decoded[0] != 0x01 || decoded[1] != 0x42 || decoded[2]&^bip38FlagCompressed != bip38FlagNoECMult {
+		return nil, ErrBIP38Unsupported
+	}
+	payload, checksum := decoded[:39], decoded[39:]
+	if string(doubleSHA256(payload)[:4]) != string(checksum) {
+		return nil, ErrBIP38Unsupported
+	}
+	compressed := payload[2]&bip38FlagCompressed != 0
+	addressHash := payload[3:7]
+
+	derived, err := scrypt.Key(norm.NFC.Bytes([]byte(passphrase)), addressHash, 16384, 8, 8, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived[32:])
+	if err != nil {
+		return nil, err
+	}
+	privateKey := make([]byte, 32)
+	block.Decrypt(privateKey[:16], payload[7:23])
+	block.Decrypt(privateKey[16:], payload[23:39])
+	for i := 0; i < 32; i++ {
+		privateKey[i] ^= derived[i]
+	}
+
+	key, _ := btcec.PrivKeyFromBytes(privateKey)
+	wif, err := btcutil.NewWIF(key, params, compressed)
+	if err != nil {
+		return nil, err
+	}
+	// The address hash doubles as the check of the passphrase
+	address, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.SerializePubKey()), params)
+	if err != nil {
+		return nil, err
+	}
+	if string(doubleSHA256([]byte(address.EncodeAddress()))[:4]) != string(addressHash) {
+		return nil, ErrBIP38Passphrase
+	}
+	return wif, nil
+}
+
+// EncryptedKeyFormat reports the passphrase-encrypted encoding of Bitcoin
+// keys.
+func (g *BitcoinKeyGen) EncryptedKeyFormat() string {
+	return FormatBIP38
+}
+
+// EncryptPrivateKey encrypts a WIF private key of the configured network
+// with passphrase, see EncryptBIP38.
+func (g *BitcoinKeyGen) EncryptPrivateKey(privateKey, passphrase string) (string, error) {
+	wif, err := btcutil.DecodeWIF(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return EncryptBIP38(wif, passphrase, g.params())
+}
+
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
@@ -3,52 +3,186 @@ package bitcoin
 import (
 	"crypto-keygen-service/internal/util/errors"
 	. "crypto-keygen-service/internal/util/network_factory"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/binary"
+	"crypto-keygen-service/internal/util/network_factory/hd"
 	"encoding/hex"
 
-	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/sirupsen/logrus"
 )
 
 type BitcoinKeyGen struct {
-	MasterSeed []byte
+	MasterSeed  []byte
+	AddressType AddressType
+	// Params selects the Bitcoin network, defaults to mainnet.
+	Params *chaincfg.Params
 }
 
 func (g *BitcoinKeyGen) GenerateKeyPairAndAddress(userID int) (KeyPairAndAddress, error) {
-	// Derive a user-specific seed using HMAC-SHA256
-	userSeed := deriveUserSeed(g.MasterSeed, userID)
+	addressType := g.addressType()
+	params := g.params()
 
-	privateKey, publicKey := btcec.PrivKeyFromBytes(btcec.S256(), userSeed)
+	// Derive the user key at m/purpose'/coinType'/0'/0/userID
+	path, err := hd.UserPath(addressType.Purpose(), coinType(params), userID)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	extendedKey, err := hd.DeriveFromSeed(g.MasterSeed, path)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
 
-	pubKeyHash := btcutil.Hash160(publicKey.SerializeCompressed())
+	privateKey, err := extendedKey.ECPrivKey()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate Bitcoin private key")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to generate Bitcoin private key")
+	}
 
-	address, err := btcutil.NewAddressPubKeyHash(pubKeyHash, &chaincfg.MainNetParams)
+	keyPairAndAddress, err := g.DescribePublicKey(privateKey.PubKey().SerializeCompressed())
 	if err != nil {
-		logrus.WithError(err).Error("Failed to generate Bitcoin address")
-		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to generate Bitcoin address")
+		return KeyPairAndAddress{}, err
 	}
-	publicKeyHex := hex.EncodeToString(publicKey.SerializeCompressed())
-	privateKeyWIF, err := btcutil.NewWIF(privateKey, &chaincfg.MainNetParams, true)
+	privateKeyWIF, err := btcutil.NewWIF(privateKey, params, true)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to encode Bitcoin private key to WIF")
 		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to encode Bitcoin private key to WIF")
 	}
 
-	logrus.Info("Generated Bitcoin key pair")
+	logrus.WithFields(logrus.Fields{
+		"address_type":    addressType,
+		"chain":           params.Name,
+		"derivation_path": path.String(),
+	}).Info("Generated Bitcoin key pair")
+
+	keyPairAndAddress.PrivateKey = privateKeyWIF.String()
+	keyPairAndAddress.DerivationPath = path.String()
+	return keyPairAndAddress, nil
+}
+
+// DescribePublicKey encodes a public key as an address of the configured
+// address type and network. Uncompressed public keys are only encoded as
+// P2PKH addresses.
+func (g *BitcoinKeyGen) DescribePublicKey(publicKey []byte) (KeyPairAndAddress, error) {
+	addressType := g.addressType()
+	params := g.params()
+
+	parsedPublicKey, err := btcec.ParsePubKey(publicKey)
+	if err != nil {
+		logrus.WithError(err).Error("Invalid Bitcoin public key")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid Bitcoin public key")
+	}
+
+	serialized := parsedPublicKey.SerializeCompressed()
+	var address btcutil.Address
+	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
+		// Only P2PKH addresses commit to uncompressed public keys, segwit
+		// and taproot outputs require compressed ones
+		if addressType != P2PKH {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Uncompressed public keys are only supported for P2PKH addresses")
+		}
+		serialized = parsedPublicKey.SerializeUncompressed()
+		address, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(serialized), params)
+	} else {
+		address, err = encodeAddress(addressType, parsedPublicKey, params)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate Bitcoin address")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to generate Bitcoin address")
+	}
 
 	return KeyPairAndAddress{
-		Address:    address.EncodeAddress(),
-		PublicKey:  publicKeyHex,
-		PrivateKey: privateKeyWIF.String(),
+		Address:     address.EncodeAddress(),
+		PublicKey:   hex.EncodeToString(serialized),
+		AddressType: string(addressType),
+		Chain:       params.Name,
 	}, nil
 }
 
-func deriveUserSeed(masterSeed []byte, userID int) []byte {
-	h := hmac.New(sha256.New, masterSeed)
-	binary.Write(h, binary.BigEndian, int64(userID))
-	return h.Sum(nil)
+// AccountPath returns the path of account 0, e.g. m/84'/0'/0'.
+func (g *BitcoinKeyGen) AccountPath() string {
+	return hd.AccountPath(g.addressType().Purpose(), coinType(g.params()), 0).String()
+}
+
+// ExtendedPublicKeyVersions returns the versions of every address type on
+// the configured network, xpub, ypub and zpub on mainnet and tpub, upub and
+// vpub on the test networks.
+func (g *BitcoinKeyGen) ExtendedPublicKeyVersions() [][]byte {
+	params := g.params()
+	return [][]byte{
+		params.HDPublicKeyID[:],
+		P2SHP2WPKH.ExtendedPublicKeyVersion(params),
+		P2WPKH.ExtendedPublicKeyVersion(params),
+	}
+}
+
+// AccountKey exports the extended public key of account 0, e.g. the zpub at
+// m/84'/0'/0' for native SegWit on mainnet.
+func (g *BitcoinKeyGen) AccountKey() (AccountKey, error) {
+	addressType := g.addressType()
+	params := g.params()
+
+	path := hd.AccountPath(addressType.Purpose(), coinType(params), 0)
+	extendedPublicKey, fingerprint, err := hd.AccountExtendedPublicKey(g.MasterSeed, path, addressType.ExtendedPublicKeyVersion(params))
+	if err != nil {
+		return AccountKey{}, err
+	}
+
+	return AccountKey{
+		ExtendedPublicKey: extendedPublicKey,
+		DerivationPath:    path.String(),
+		MasterFingerprint: fingerprint,
+	}, nil
+}
+
+func (g *BitcoinKeyGen) IsMainnet() bool {
+	return g.params().Net == chaincfg.MainNetParams.Net
+}
+
+func (g *BitcoinKeyGen) params() *chaincfg.Params {
+	if g.Params == nil {
+		return &chaincfg.MainNetParams
+	}
+	return g.Params
+}
+
+func coinType(params *chaincfg.Params) uint32 {
+	if params.Net == chaincfg.MainNetParams.Net {
+		return hd.CoinTypeBitcoin
+	}
+	return hd.CoinTypeTestnet
+}
+
+func (g *BitcoinKeyGen) addressType() AddressType {
+	if g.AddressType == "" {
+		return DefaultAddressType
+	}
+	return g.AddressType
+}
+
+func encodeAddress(addressType AddressType, publicKey *btcec.PublicKey, params *chaincfg.Params) (btcutil.Address, error) {
+	pubKeyHash := btcutil.Hash160(publicKey.SerializeCompressed())
+
+	switch addressType {
+	case P2SHP2WPKH:
+		witnessAddress, err := btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, params)
+		if err != nil {
+			return nil, err
+		}
+		redeemScript, err := txscript.PayToAddrScript(witnessAddress)
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressScriptHash(redeemScript, params)
+	case P2WPKH:
+		return btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, params)
+	case P2TR:
+		// BIP86: key path only spend, the output key commits to no script tree.
+		outputKey := txscript.ComputeTaprootKeyNoScript(publicKey)
+		return btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), params)
+	default:
+		return btcutil.NewAddressPubKeyHash(pubKeyHash, params)
+	}
 }
@@ -2,8 +2,12 @@ package bitcoin_test
 
 import (
 	"crypto-keygen-service/internal/util/network_factory/generators/bitcoin"
+	"encoding/hex"
 	"strings"
 	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 )
 
 func TestGenerateKeyPairAndAddress(t *testing.T) {
@@ -48,3 +52,328 @@ func TestGenerateKeyPairAndAddress(t *testing.T) {
 		t.Errorf("Expected a valid private key, got an empty string")
 	}
 }
+
+// BIP39 seed of "abandon abandon ... about" with an empty passphrase.
+const vectorSeedHex = "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+
+func TestGenerateKeyPairAndAddressBIP44Vector(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+	keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed}
+
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if keyPair.DerivationPath != "m/44'/0'/0'/0/0" {
+		t.Errorf("Unexpected derivation path %s", keyPair.DerivationPath)
+	}
+	if keyPair.Address != "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA" {
+		t.Errorf("Unexpected address %s", keyPair.Address)
+	}
+	if keyPair.PrivateKey != "L4p2b9VAf8k5aUahF1JCJUzZkgNEAqLfq8DDdQiyAprQAKSbu8hf" {
+		t.Errorf("Unexpected private key %s", keyPair.PrivateKey)
+	}
+}
+
+func TestGenerateKeyPairAndAddressTypesVector(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+
+	tests := []struct {
+		addressType bitcoin.AddressType
+		path        string
+		address     string
+	}{
+		{bitcoin.P2PKH, "m/44'/0'/0'/0/0", "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA"},
+		{bitcoin.P2SHP2WPKH, "m/49'/0'/0'/0/0", "37VucYSaXLCAsxYyAPfbSi9eh4iEcbShgf"},
+		{bitcoin.P2WPKH, "m/84'/0'/0'/0/0", "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+		{bitcoin.P2TR, "m/86'/0'/0'/0/0", "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+	}
+
+	for _, tt := range tests {
+		keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: tt.addressType}
+		keyPair, err := keyGen.GenerateKeyPairAndAddress(0)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.addressType, err)
+		}
+		if keyPair.DerivationPath != tt.path {
+			t.Errorf("%s: unexpected derivation path %s", tt.addressType, keyPair.DerivationPath)
+		}
+		if keyPair.Address != tt.address {
+			t.Errorf("%s: unexpected address %s", tt.addressType, keyPair.Address)
+		}
+		if keyPair.AddressType != string(tt.addressType) {
+			t.Errorf("%s: unexpected address type %s", tt.addressType, keyPair.AddressType)
+		}
+	}
+}
+
+func TestGenerateKeyPairAndAddressTestnet(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+
+	tests := []struct {
+		params  *chaincfg.Params
+		path    string
+		address string
+	}{
+		{&chaincfg.TestNet3Params, "m/84'/1'/0'/0/0", "tb1q6rz28mcfaxtmd6v789l9rrlrusdprr9pqcpvkl"},
+		{&chaincfg.SigNetParams, "m/84'/1'/0'/0/0", "tb1q6rz28mcfaxtmd6v789l9rrlrusdprr9pqcpvkl"},
+		{&chaincfg.RegressionNetParams, "m/84'/1'/0'/0/0", "bcrt1q6rz28mcfaxtmd6v789l9rrlrusdprr9pz3cppk"},
+	}
+
+	for _, tt := range tests {
+		keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: bitcoin.P2WPKH, Params: tt.params}
+		keyPair, err := keyGen.GenerateKeyPairAndAddress(0)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.params.Name, err)
+		}
+		if keyPair.DerivationPath != tt.path {
+			t.Errorf("%s: unexpected derivation path %s", tt.params.Name, keyPair.DerivationPath)
+		}
+		if keyPair.Address != tt.address {
+			t.Errorf("%s: unexpected address %s", tt.params.Name, keyPair.Address)
+		}
+		if keyPair.Chain != tt.params.Name {
+			t.Errorf("%s: unexpected chain %s", tt.params.Name, keyPair.Chain)
+		}
+		if keyGen.IsMainnet() {
+			t.Errorf("%s: expected a test network", tt.params.Name)
+		}
+		if !strings.HasPrefix(keyPair.PrivateKey, "c") {
+			t.Errorf("%s: expected a testnet WIF, got %s", tt.params.Name, keyPair.PrivateKey)
+		}
+	}
+}
+
+func TestAccountKeyVector(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+
+	tests := []struct {
+		addressType bitcoin.AddressType
+		path        string
+		xpub        string
+	}{
+		{bitcoin.P2PKH, "m/44'/0'/0'", "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"},
+		{bitcoin.P2SHP2WPKH, "m/49'/0'/0'", "ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP"},
+		{bitcoin.P2WPKH, "m/84'/0'/0'", "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"},
+		{bitcoin.P2TR, "m/86'/0'/0'", "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ"},
+	}
+
+	for _, tt := range tests {
+		keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: tt.addressType}
+		accountKey, err := keyGen.AccountKey()
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.addressType, err)
+		}
+		if accountKey.DerivationPath != tt.path {
+			t.Errorf("%s: unexpected derivation path %s", tt.addressType, accountKey.DerivationPath)
+		}
+		if accountKey.ExtendedPublicKey != tt.xpub {
+			t.Errorf("%s: unexpected extended public key %s", tt.addressType, accountKey.ExtendedPublicKey)
+		}
+		if accountKey.MasterFingerprint != "73c5da0a" {
+			t.Errorf("%s: unexpected fingerprint %s", tt.addressType, accountKey.MasterFingerprint)
+		}
+	}
+}
+
+func TestEncryptBIP38(t *testing.T) {
+	// Test vectors of BIP38, no EC multiply
+	tests := []struct {
+		wif        string
+		passphrase string
+		encrypted  string
+	}{
+		{"5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR", "TestingOneTwoThree", "6PRVWUbkzzsbcVac2qwfssoUJAN1Xhrg6bNk8J7Nzm5H7kxEbn2Nh2ZoGg"},
+		{"L44B5gGEpqEDRS9vVPz7QT35jcBG2r3CZwSwQ4fCewXAhAhqGVpP", "TestingOneTwoThree", "6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo"},
+	}
+
+	for _, tt := range tests {
+		wif, err := btcutil.DecodeWIF(tt.wif)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		encrypted, err := bitcoin.EncryptBIP38(wif, tt.passphrase, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if encrypted != tt.encrypted {
+			t.Errorf("Expected %s, got %s", tt.encrypted, encrypted)
+		}
+	}
+
+	// Keys of another network are refused
+	keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: []byte("test-master-seed-1234"), Params: &chaincfg.TestNet3Params}
+	if _, err := keyGen.EncryptPrivateKey(tests[1].wif, "passphrase"); err == nil {
+		t.Error("Expected an error for a mainnet key on testnet")
+	}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	encrypted, err := keyGen.EncryptPrivateKey(keyPair.PrivateKey, "passphrase")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "6PY") {
+		t.Errorf("Expected a compressed BIP38 key, got %s", encrypted)
+	}
+}
+
+func TestImportPrivateKey(t *testing.T) {
+	mainnet := &bitcoin.BitcoinKeyGen{Params: &chaincfg.MainNetParams}
+	// Compressed test vector of TestEncryptBIP38
+	imported, err := mainnet.ImportPrivateKey("6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo", "TestingOneTwoThree")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if imported.PrivateKey != "L44B5gGEpqEDRS9vVPz7QT35jcBG2r3CZwSwQ4fCewXAhAhqGVpP" {
+		t.Errorf("Unexpected private key %s", imported.PrivateKey)
+	}
+	if imported.Address != "164MQi977u9GUteHr4EPH27VkkdxmfCvGW" {
+		t.Errorf("Unexpected address %s", imported.Address)
+	}
+	for _, tt := range []struct{ privateKey, passphrase string }{
+		{"6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo", "wrong"},
+		{"6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo", ""},
+		{"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", ""},
+		{"not a key", ""},
+	} {
+		if _, err := mainnet.ImportPrivateKey(tt.privateKey, tt.passphrase); err == nil {
+			t.Errorf("Expected an error for %s", tt.privateKey)
+		}
+	}
+
+	// Uncompressed test vector of TestEncryptBIP38, as BIP38 or WIF
+	for _, tt := range []struct{ privateKey, passphrase string }{
+		{"6PRVWUbkzzsbcVac2qwfssoUJAN1Xhrg6bNk8J7Nzm5H7kxEbn2Nh2ZoGg", "TestingOneTwoThree"},
+		{"5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR", ""},
+	} {
+		imported, err := mainnet.ImportPrivateKey(tt.privateKey, tt.passphrase)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if imported.PrivateKey != "5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR" {
+			t.Errorf("Unexpected private key %s", imported.PrivateKey)
+		}
+		if imported.Address != "1Jq6MksXQVWzrznvZzxkV6oY57oWXD9TXB" {
+			t.Errorf("Unexpected address %s", imported.Address)
+		}
+		if !strings.HasPrefix(imported.PublicKey, "04") {
+			t.Errorf("Expected an uncompressed public key, got %s", imported.PublicKey)
+		}
+		// Segwit and taproot outputs require compressed keys
+		for _, addressType := range []bitcoin.AddressType{bitcoin.P2SHP2WPKH, bitcoin.P2WPKH, bitcoin.P2TR} {
+			keyGen := &bitcoin.BitcoinKeyGen{AddressType: addressType, Params: &chaincfg.MainNetParams}
+			if _, err := keyGen.ImportPrivateKey(tt.privateKey, tt.passphrase); err == nil {
+				t.Errorf("Expected an error for an uncompressed %s key", addressType)
+			}
+		}
+	}
+
+	// A generated key imports to the same address, as WIF or hex
+	keyGen := &bitcoin.BitcoinKeyGen{MasterSeed: []byte("test-master-seed-1234"), AddressType: bitcoin.P2WPKH, Params: &chaincfg.TestNet3Params}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	wif, _ := btcutil.DecodeWIF(keyPair.PrivateKey)
+	for _, privateKey := range []string{keyPair.PrivateKey, hex.EncodeToString(wif.PrivKey.Serialize())} {
+		imported, err := keyGen.ImportPrivateKey(privateKey, "")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if imported.Address != keyPair.Address || imported.PrivateKey != keyPair.PrivateKey {
+			t.Errorf("Expected %s, got %s", keyPair.Address, imported.Address)
+		}
+	}
+	if _, err := mainnet.ImportPrivateKey(keyPair.PrivateKey, ""); err == nil {
+		t.Error("Expected an error for a testnet key on mainnet")
+	}
+}
+
+func TestVerifyMessageBIP322Vectors(t *testing.T) {
+	// Test vectors of BIP322, all signed by L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k
+	keyGen := &bitcoin.BitcoinKeyGen{Params: &chaincfg.MainNetParams}
+	tests := []struct {
+		address   string
+		message   string
+		signature string
+	}{
+		{"bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l", "", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{"bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l", "Hello World", "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{"bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3", "Hello World", "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ=="},
+	}
+
+	for _, tt := range tests {
+		valid, err := keyGen.VerifyMessage(tt.address, []byte(tt.message), tt.signature)
+		if err != nil || !valid {
+			t.Errorf("Expected %q to verify for %s, got %v", tt.message, tt.address, err)
+		}
+		valid, err = keyGen.VerifyMessage(tt.address, []byte(tt.message+"!"), tt.signature)
+		if err != nil || valid {
+			t.Errorf("Expected %q not to verify for %s, got %v", tt.message+"!", tt.address, err)
+		}
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	const privateKey = "L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k"
+	message := []byte("Hello World")
+	tests := []struct {
+		addressType bitcoin.AddressType
+		scheme      string
+	}{
+		{bitcoin.P2PKH, bitcoin.SchemeBIP137},
+		{bitcoin.P2SHP2WPKH, bitcoin.SchemeBIP137},
+		{bitcoin.P2WPKH, bitcoin.SchemeBIP137},
+		{bitcoin.P2TR, bitcoin.SchemeBIP322Simple},
+	}
+
+	wif, _ := btcutil.DecodeWIF(privateKey)
+	addresses := map[bitcoin.AddressType]string{}
+	for _, tt := range tests {
+		keyGen := &bitcoin.BitcoinKeyGen{AddressType: tt.addressType, Params: &chaincfg.MainNetParams}
+		keyPair, err := keyGen.DescribePublicKey(wif.SerializePubKey())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		addresses[tt.addressType] = keyPair.Address
+
+		signature, err := keyGen.SignMessage(privateKey, message)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if signature.Scheme != tt.scheme {
+			t.Errorf("%s: expected scheme %s, got %s", tt.addressType, tt.scheme, signature.Scheme)
+		}
+		valid, err := keyGen.VerifyMessage(keyPair.Address, message, signature.Signature)
+		if err != nil || !valid {
+			t.Errorf("%s: expected the signature to verify, got %v", tt.addressType, err)
+		}
+		if valid, _ := keyGen.VerifyMessage(keyPair.Address, []byte("Hello World!"), signature.Signature); valid {
+			t.Errorf("%s: expected the signature not to verify another message", tt.addressType)
+		}
+	}
+	if addresses[bitcoin.P2WPKH] != "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l" || addresses[bitcoin.P2TR] != "bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3" {
+		t.Errorf("Unexpected addresses %v", addresses)
+	}
+
+	// BIP137 signatures verify for any address of the key, not another key's
+	keyGen := &bitcoin.BitcoinKeyGen{Params: &chaincfg.MainNetParams}
+	signature, _ := keyGen.SignMessage(privateKey, message)
+	if valid, _ := keyGen.VerifyMessage(addresses[bitcoin.P2WPKH], message, signature.Signature); !valid {
+		t.Error("Expected the P2PKH signature to verify for the P2WPKH address")
+	}
+	if valid, _ := keyGen.VerifyMessage("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", message, signature.Signature); valid {
+		t.Error("Expected the signature not to verify for another address")
+	}
+	testnet := &bitcoin.BitcoinKeyGen{AddressType: bitcoin.P2WPKH, Params: &chaincfg.TestNet3Params}
+	testnetPair, _ := testnet.DescribePublicKey(wif.SerializePubKey())
+	if _, err := keyGen.VerifyMessage(testnetPair.Address, message, signature.Signature); err == nil {
+		t.Error("Expected an error for a testnet address on mainnet")
+	}
+	if _, err := testnet.SignMessage(privateKey, message); err == nil {
+		t.Error("Expected an error for a mainnet key on testnet")
+	}
+}
@@ -0,0 +1,64 @@
+package bitcoin
+
+import (
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"encoding/hex"
+	stderrors "errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/sirupsen/logrus"
+)
+
+// ImportPrivateKey decodes a WIF, a hex or, with passphrase, a BIP38 private
+// key of the configured network and describes it with the configured
+// address type. Keys of uncompressed public keys are only imported for
+// P2PKH, the other address types require compressed keys.
+func (g *BitcoinKeyGen) ImportPrivateKey(privateKey, passphrase string) (KeyPairAndAddress, error) {
+	params := g.params()
+	privateKey = strings.TrimSpace(privateKey)
+
+	var wif *btcutil.WIF
+	if strings.HasPrefix(privateKey, "6P") {
+		if passphrase == "" {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "A passphrase is required to decrypt a BIP38 private key")
+		}
+		decrypted, err := DecryptBIP38(privateKey, passphrase, params)
+		if stderrors.Is(err, ErrBIP38Passphrase) {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Wrong passphrase for the BIP38 private key")
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to decrypt BIP38 private key")
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid or unsupported BIP38 private key")
+		}
+		wif = decrypted
+	} else if decoded, err := btcutil.DecodeWIF(privateKey); err == nil {
+		if !decoded.IsForNet(params) {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "WIF private key is not for this network")
+		}
+		wif = decoded
+	} else if raw, err := hex.DecodeString(strings.TrimPrefix(privateKey, "0x")); err == nil && len(raw) == btcec.PrivKeyBytesLen {
+		key, _ := btcec.PrivKeyFromBytes(raw)
+		// PrivKeyFromBytes reduces modulo the curve order
+		if key.Key.IsZero() || hex.EncodeToString(key.Serialize()) != hex.EncodeToString(raw) {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid Bitcoin private key")
+		}
+		if wif, err = btcutil.NewWIF(key, params, true); err != nil {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid Bitcoin private key")
+		}
+	} else {
+		return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Private key must be a WIF, hex or BIP38 encoded Bitcoin key")
+	}
+	if !wif.CompressPubKey && g.addressType() != P2PKH {
+		return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Uncompressed Bitcoin private keys are only supported for P2PKH addresses")
+	}
+
+	keyPairAndAddress, err := g.DescribePublicKey(wif.SerializePubKey())
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	keyPairAndAddress.PrivateKey = wif.String()
+	return keyPairAndAddress, nil
+}
@@ -0,0 +1,236 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"encoding/base64"
+	stderrors "errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Message signature schemes of Bitcoin keys.
+const (
+	// SchemeBIP137 is the compact signature of Bitcoin Core's signmessage,
+	// with the header byte of BIP137 telling the address type apart.
+	SchemeBIP137 = "bip-137"
+	// SchemeBIP322Simple is the witness of a BIP322 virtual transaction,
+	// used for Taproot addresses which have no BIP137 header.
+	SchemeBIP322Simple = "bip-322-simple"
+)
+
+const bitcoinSignedMessagePrefix = "Bitcoin Signed Message:\n"
+
+var errMalformedWitness = stderrors.New("malformed witness")
+
+// BIP137 header bytes of compressed keys, recovery ID 0. Uncompressed P2PKH
+// keys start at 27.
+const (
+	bip137HeaderP2PKH      = 31
+	bip137HeaderP2SHP2WPKH = 35
+	bip137HeaderP2WPKH     = 39
+)
+
+// SignMessage signs message with a WIF private key of the configured network
+// for the configured address type, as BIP137 or, for P2TR, BIP322 simple.
+// Signatures are base64 encoded.
+func (g *BitcoinKeyGen) SignMessage(privateKey string, message []byte) (MessageSignature, error) {
+	params := g.params()
+	wif, err := btcutil.DecodeWIF(privateKey)
+	if err != nil || !wif.IsForNet(params) {
+		return MessageSignature{}, errors.NewKeyGenError(400, "Invalid Bitcoin private key")
+	}
+
+	addressType := g.addressType()
+	if addressType == P2TR {
+		address, err := encodeAddress(addressType, wif.PrivKey.PubKey(), params)
+		if err != nil {
+			return MessageSignature{}, err
+		}
+		signature, err := signBIP322Simple(wif.PrivKey, address, message)
+		if err != nil {
+			return MessageSignature{}, err
+		}
+		return MessageSignature{Scheme: SchemeBIP322Simple, Signature: signature}, nil
+	}
+
+	signature := ecdsa.SignCompact(wif.PrivKey, bitcoinMessageHash(message), wif.CompressPubKey)
+	if wif.CompressPubKey {
+		// SignCompact sets the header of compressed P2PKH keys
+		switch addressType {
+		case P2SHP2WPKH:
+			signature[0] += bip137HeaderP2SHP2WPKH - bip137HeaderP2PKH
+		case P2WPKH:
+			signature[0] += bip137HeaderP2WPKH - bip137HeaderP2PKH
+		}
+	}
+	return MessageSignature{Scheme: SchemeBIP137, Signature: base64.StdEncoding.EncodeToString(signature)}, nil
+}
+
+// VerifyMessage verifies a base64 BIP137 signature, whatever its header
+// claims the address type is, like Electrum and Trezor do, or a BIP322 simple
+// signature of a SegWit address of the configured network.
+func (g *BitcoinKeyGen) VerifyMessage(address string, message []byte, signature string) (bool, error) {
+	params := g.params()
+	decodedAddress, err := btcutil.DecodeAddress(address, params)
+	if err != nil || !decodedAddress.IsForNet(params) {
+		return false, errors.NewKeyGenError(400, "Invalid Bitcoin address for this network")
+	}
+	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, errors.NewKeyGenError(400, "Signature must be base64 encoded")
+	}
+
+	if len(decodedSignature) == 65 && decodedSignature[0] >= 27 && decodedSignature[0] <= 42 {
+		return verifyBIP137(decodedAddress, message, decodedSignature, params), nil
+	}
+	return verifyBIP322Simple(decodedAddress, message, decodedSignature)
+}
+
+func verifyBIP137(address btcutil.Address, message, signature []byte, params *chaincfg.Params) bool {
+	// RecoverCompact only knows the P2PKH headers
+	header := signature[0]
+	if header >= bip137HeaderP2SHP2WPKH {
+		header = bip137HeaderP2PKH + (header-bip137HeaderP2PKH)%4
+	}
+	publicKey, compressed, err := ecdsa.RecoverCompact(append([]byte{header}, signature[1:]...), bitcoinMessageHash(message))
+	if err != nil {
+		return false
+	}
+
+	serialized := publicKey.SerializeUncompressed()
+	if compressed {
+		serialized = publicKey.SerializeCompressed()
+	}
+	var candidate btcutil.Address
+	switch address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		candidate, err = btcutil.NewAddressPubKeyHash(btcutil.Hash160(serialized), params)
+	case *btcutil.AddressScriptHash:
+		if !compressed {
+			return false
+		}
+		candidate, err = encodeAddress(P2SHP2WPKH, publicKey, params)
+	case *btcutil.AddressWitnessPubKeyHash:
+		if !compressed {
+			return false
+		}
+		candidate, err = encodeAddress(P2WPKH, publicKey, params)
+	default:
+		return false
+	}
+	return err == nil && candidate.EncodeAddress() == address.EncodeAddress()
+}
+
+// bitcoinMessageHash is the double SHA-256 of the prefixed message that
+// signmessage signs.
+func bitcoinMessageHash(message []byte) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, bitcoinSignedMessagePrefix)
+	_ = wire.WriteVarBytes(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+func signBIP322Simple(privateKey *btcec.PrivateKey, address btcutil.Address, message []byte) (string, error) {
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return "", err
+	}
+	toSign := bip322ToSign(pkScript, message)
+	fetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	signature, err := txscript.RawTxInTaprootSignature(toSign, txscript.NewTxSigHashes(toSign, fetcher), 0, 0, pkScript, []byte{}, txscript.SigHashDefault, privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := writeWitness(&buf, wire.TxWitness{signature}); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func verifyBIP322Simple(address btcutil.Address, message, signature []byte) (bool, error) {
+	switch address.(type) {
+	case *btcutil.AddressWitnessPubKeyHash, *btcutil.AddressTaproot:
+	default:
+		return false, errors.NewKeyGenError(400, "BIP322 simple signatures are only supported for SegWit addresses")
+	}
+	witness, err := readWitness(bytes.NewReader(signature))
+	if err != nil {
+		return false, errors.NewKeyGenError(400, "Invalid BIP322 signature")
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return false, err
+	}
+
+	toSign := bip322ToSign(pkScript, message)
+	toSign.TxIn[0].Witness = witness
+	fetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	engine, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(toSign, fetcher), 0, fetcher)
+	if err != nil {
+		return false, nil
+	}
+	return engine.Execute() == nil, nil
+}
+
+// bip322ToSign builds the unsigned to_sign transaction of BIP322, which
+// spends the to_spend transaction committing to message.
+func bip322ToSign(pkScript, message []byte) *wire.MsgTx {
+	messageHash := chainhash.TaggedHash([]byte("BIP0322-signed-message"), message)
+	scriptSig, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(messageHash[:]).Script()
+
+	toSpend := wire.NewMsgTx(0)
+	toSpendInput := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xffffffff), scriptSig, nil)
+	toSpendInput.Sequence = 0
+	toSpend.AddTxIn(toSpendInput)
+	toSpend.AddTxOut(wire.NewTxOut(0, pkScript))
+
+	toSpendHash := toSpend.TxHash()
+	toSign := wire.NewMsgTx(0)
+	toSignInput := wire.NewTxIn(wire.NewOutPoint(&toSpendHash, 0), nil, nil)
+	toSignInput.Sequence = 0
+	toSign.AddTxIn(toSignInput)
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return toSign
+}
+
+func writeWitness(buf *bytes.Buffer, witness wire.TxWitness) error {
+	if err := wire.WriteVarInt(buf, 0, uint64(len(witness))); err != nil {
+		return err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(buf, 0, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWitness(r *bytes.Reader) (wire.TxWitness, error) {
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(r.Len()) {
+		return nil, errMalformedWitness
+	}
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		if witness[i], err = wire.ReadVarBytes(r, 0, txscript.MaxScriptSize, "witness item"); err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, errMalformedWitness
+	}
+	return witness, nil
+}
@@ -0,0 +1,12 @@
+package bitcoin
+
+import "github.com/btcsuite/btcd/chaincfg"
+
+// Networks maps the network suffix used in network identifiers
+// ("bitcoin", "bitcoin-testnet", ...) to its chain parameters.
+var Networks = map[string]*chaincfg.Params{
+	"":        &chaincfg.MainNetParams,
+	"testnet": &chaincfg.TestNet3Params,
+	"signet":  &chaincfg.SigNetParams,
+	"regtest": &chaincfg.RegressionNetParams,
+}
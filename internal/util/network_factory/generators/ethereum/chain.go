@@ -0,0 +1,23 @@
+package ethereum
+
+// Chain labels Ethereum records with the chain they are meant for. Test
+// chains share their keys with each other but not with mainnet, see
+// EthereumKeyGen.
+type Chain struct {
+	Name string
+	ID   uint64
+}
+
+var (
+	Mainnet = Chain{Name: "mainnet", ID: 1}
+	Sepolia = Chain{Name: "sepolia", ID: 11155111}
+	Holesky = Chain{Name: "holesky", ID: 17000}
+)
+
+// Chains maps the network suffix used in network identifiers
+// ("ethereum", "ethereum-sepolia", ...) to its chain.
+var Chains = map[string]Chain{
+	"":        Mainnet,
+	"sepolia": Sepolia,
+	"holesky": Holesky,
+}
@@ -3,46 +3,121 @@ package ethereum
 import (
 	"crypto-keygen-service/internal/util/errors"
 	. "crypto-keygen-service/internal/util/network_factory"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/binary"
+	"crypto-keygen-service/internal/util/network_factory/hd"
 	"encoding/hex"
 
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
 )
 
 type EthereumKeyGen struct {
 	MasterSeed []byte
+	// Chain labels the generated records and selects the coin type, defaults
+	// to mainnet.
+	Chain Chain
 }
 
 func (g *EthereumKeyGen) GenerateKeyPairAndAddress(userID int) (KeyPairAndAddress, error) {
-	// Derive a user-specific seed using HMAC-SHA256
-	userSeed := deriveUserSeed(g.MasterSeed, userID)
-	privateKey, err := crypto.ToECDSA(userSeed)
+	// Derive the user key at m/44'/60'/0'/0/userID, m/44'/1'/0'/0/userID on
+	// test chains
+	path, err := hd.UserPath(hd.PurposeBIP44, g.coinType(), userID)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	extendedKey, err := hd.DeriveFromSeed(g.MasterSeed, path)
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+
+	ecPrivateKey, err := extendedKey.ECPrivKey()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to generate Ethereum private key")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to generate Ethereum private key")
+	}
+	privateKey, err := crypto.ToECDSA(ecPrivateKey.Serialize())
 	if err != nil {
 		logrus.WithError(err).Error("Failed to generate Ethereum private key")
 		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to generate Ethereum private key")
 	}
 
-	privateKeyHex := hex.EncodeToString(crypto.FromECDSA(privateKey))
-	publicKeyHex := hex.EncodeToString(crypto.FromECDSAPub(&privateKey.PublicKey))
-	address := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+	keyPairAndAddress, err := g.DescribePublicKey(ecPrivateKey.PubKey().SerializeCompressed())
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
 
 	logrus.WithFields(logrus.Fields{
-		"address":    address,
-		"public_key": publicKeyHex,
+		"address":         keyPairAndAddress.Address,
+		"public_key":      keyPairAndAddress.PublicKey,
+		"derivation_path": path.String(),
+		"chain":           keyPairAndAddress.Chain,
 	}).Info("Generated Ethereum key pair")
 
+	keyPairAndAddress.PrivateKey = hex.EncodeToString(crypto.FromECDSA(privateKey))
+	keyPairAndAddress.DerivationPath = path.String()
+	return keyPairAndAddress, nil
+}
+
+// DescribePublicKey returns the address and uncompressed public key of a
+// compressed secp256k1 public key.
+func (g *EthereumKeyGen) DescribePublicKey(publicKey []byte) (KeyPairAndAddress, error) {
+	ecdsaPublicKey, err := crypto.DecompressPubkey(publicKey)
+	if err != nil {
+		logrus.WithError(err).Error("Invalid Ethereum public key")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid Ethereum public key")
+	}
+
+	chain := g.chain()
 	return KeyPairAndAddress{
-		Address:    address,
-		PublicKey:  publicKeyHex,
-		PrivateKey: privateKeyHex,
+		Address:   crypto.PubkeyToAddress(*ecdsaPublicKey).Hex(),
+		PublicKey: hex.EncodeToString(crypto.FromECDSAPub(ecdsaPublicKey)),
+		Chain:     chain.Name,
+		ChainID:   chain.ID,
 	}, nil
 }
 
-func deriveUserSeed(masterSeed []byte, userID int) []byte {
-	h := hmac.New(sha256.New, masterSeed)
-	binary.Write(h, binary.BigEndian, int64(userID))
-	return h.Sum(nil)
+// AccountPath returns m/44'/60'/0', m/44'/1'/0' on test chains.
+func (g *EthereumKeyGen) AccountPath() string {
+	return hd.AccountPath(hd.PurposeBIP44, g.coinType(), 0).String()
+}
+
+// ExtendedPublicKeyVersions returns the version of xpub, Ethereum wallets
+// use it on every chain.
+func (g *EthereumKeyGen) ExtendedPublicKeyVersions() [][]byte {
+	return [][]byte{chaincfg.MainNetParams.HDPublicKeyID[:]}
+}
+
+// AccountKey exports the xpub at AccountPath.
+func (g *EthereumKeyGen) AccountKey() (AccountKey, error) {
+	path := hd.AccountPath(hd.PurposeBIP44, g.coinType(), 0)
+	extendedPublicKey, fingerprint, err := hd.AccountExtendedPublicKey(g.MasterSeed, path, chaincfg.MainNetParams.HDPublicKeyID[:])
+	if err != nil {
+		return AccountKey{}, err
+	}
+
+	return AccountKey{
+		ExtendedPublicKey: extendedPublicKey,
+		DerivationPath:    path.String(),
+		MasterFingerprint: fingerprint,
+	}, nil
+}
+
+func (g *EthereumKeyGen) IsMainnet() bool {
+	return g.chain() == Mainnet
+}
+
+// coinType keeps the keys of test chains apart from mainnet keys, like the
+// Bitcoin test networks.
+func (g *EthereumKeyGen) coinType() uint32 {
+	if g.IsMainnet() {
+		return hd.CoinTypeEthereum
+	}
+	return hd.CoinTypeTestnet
+}
+
+func (g *EthereumKeyGen) chain() Chain {
+	if g.Chain == (Chain{}) {
+		return Mainnet
+	}
+	return g.Chain
 }
@@ -1,8 +1,19 @@
 package ethereum_test
 
 import (
+	"bytes"
 	"crypto-keygen-service/internal/util/network_factory/generators/ethereum"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestGenerateKeyPairAndAddress(t *testing.T) {
@@ -43,3 +54,390 @@ func TestGenerateKeyPairAndAddress(t *testing.T) {
 		t.Errorf("Expected a valid private key, got an empty string")
 	}
 }
+
+// BIP39 seed of "abandon abandon ... about" with an empty passphrase.
+const vectorSeedHex = "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+
+func TestGenerateKeyPairAndAddressBIP44Vector(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: masterSeed}
+
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if keyPair.DerivationPath != "m/44'/60'/0'/0/0" {
+		t.Errorf("Unexpected derivation path %s", keyPair.DerivationPath)
+	}
+	if keyPair.Address != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Errorf("Unexpected address %s", keyPair.Address)
+	}
+}
+
+func TestTestChainsUseTestnetCoinType(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+	mainnet, err := (&ethereum.EthereumKeyGen{MasterSeed: masterSeed}).GenerateKeyPairAndAddress(0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, chain := range []ethereum.Chain{ethereum.Sepolia, ethereum.Holesky} {
+		keyGen := &ethereum.EthereumKeyGen{MasterSeed: masterSeed, Chain: chain}
+		keyPair, err := keyGen.GenerateKeyPairAndAddress(0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if keyPair.DerivationPath != "m/44'/1'/0'/0/0" {
+			t.Errorf("Unexpected derivation path %s on %s", keyPair.DerivationPath, chain.Name)
+		}
+		if keyPair.Address == mainnet.Address || keyPair.PrivateKey == mainnet.PrivateKey {
+			t.Errorf("Expected %s to not reuse the mainnet key", chain.Name)
+		}
+		if path := keyGen.AccountPath(); path != "m/44'/1'/0'" {
+			t.Errorf("Unexpected account path %s on %s", path, chain.Name)
+		}
+		accountKey, err := keyGen.AccountKey()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if accountKey.DerivationPath != "m/44'/1'/0'" {
+			t.Errorf("Unexpected account key path %s on %s", accountKey.DerivationPath, chain.Name)
+		}
+	}
+}
+
+func TestAccountKeyDerivesUserAddresses(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: masterSeed}
+
+	accountKey, err := keyGen.AccountKey()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if accountKey.DerivationPath != "m/44'/60'/0'" {
+		t.Errorf("Unexpected derivation path %s", accountKey.DerivationPath)
+	}
+
+	// <account>/0/0 must be the address of user 0
+	extendedKey, err := hdkeychain.NewKeyFromString(accountKey.ExtendedPublicKey)
+	if err != nil {
+		t.Fatalf("Expected a valid extended public key, got %v", err)
+	}
+	extendedKey, _ = extendedKey.Derive(0)
+	extendedKey, _ = extendedKey.Derive(0)
+	publicKey, _ := extendedKey.ECPubKey()
+	ecdsaKey, _ := crypto.DecompressPubkey(publicKey.SerializeCompressed())
+
+	if address := crypto.PubkeyToAddress(*ecdsaKey).Hex(); address != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Errorf("Unexpected address %s", address)
+	}
+}
+
+func TestEncryptKeystore(t *testing.T) {
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: []byte("test-master-seed-1234")}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	encrypted, err := ethereum.EncryptKeystore(keyPair.PrivateKey, "passphrase", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := keystore.DecryptKey([]byte(encrypted), "wrong"); err == nil {
+		t.Error("Expected the wrong passphrase to fail")
+	}
+	key, err := keystore.DecryptKey([]byte(encrypted), "passphrase")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if key.Address.Hex() != keyPair.Address {
+		t.Errorf("Expected address %s, got %s", keyPair.Address, key.Address.Hex())
+	}
+	if hex.EncodeToString(crypto.FromECDSA(key.PrivateKey)) != keyPair.PrivateKey {
+		t.Error("Expected the decrypted private key to match")
+	}
+
+	if _, err := ethereum.EncryptKeystore("not-hex", "passphrase", keystore.LightScryptN, keystore.LightScryptP); err == nil {
+		t.Error("Expected an error for an invalid private key")
+	}
+}
+
+func TestImportPrivateKey(t *testing.T) {
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: []byte("test-master-seed-1234")}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	encrypted, err := ethereum.EncryptKeystore(keyPair.PrivateKey, "passphrase", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, tt := range []struct{ privateKey, passphrase string }{
+		{keyPair.PrivateKey, ""},
+		{"0x" + keyPair.PrivateKey, ""},
+		{encrypted, "passphrase"},
+	} {
+		imported, err := keyGen.ImportPrivateKey(tt.privateKey, tt.passphrase)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if imported.Address != keyPair.Address || imported.PublicKey != keyPair.PublicKey || imported.PrivateKey != keyPair.PrivateKey {
+			t.Errorf("Expected %s, got %s", keyPair.Address, imported.Address)
+		}
+	}
+
+	for _, tt := range []struct{ privateKey, passphrase string }{
+		{encrypted, "wrong"},
+		{encrypted, ""},
+		{"{}", "passphrase"},
+		{"not-hex", ""},
+	} {
+		if _, err := keyGen.ImportPrivateKey(tt.privateKey, tt.passphrase); err == nil {
+			t.Errorf("Expected an error for %s", tt.privateKey)
+		}
+	}
+}
+
+func TestImportKeystoreKDF(t *testing.T) {
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: []byte("test-master-seed-1234")}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	encrypted, err := ethereum.EncryptKeystore(keyPair.PrivateKey, "passphrase", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(encrypted), &parsed); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	originalSalt := parsed["crypto"].(map[string]interface{})["kdfparams"].(map[string]interface{})["salt"]
+	// withKDF returns the keystore with its key derivation replaced
+	withKDF := func(kdf string, params map[string]interface{}) string {
+		cryptoJSON := parsed["crypto"].(map[string]interface{})
+		cryptoJSON["kdf"] = kdf
+		cryptoJSON["kdfparams"] = params
+		replaced, err := json.Marshal(parsed)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return string(replaced)
+	}
+	salt := strings.Repeat("ab", 32)
+
+	for _, tt := range []struct {
+		name     string
+		keystore string
+	}{
+		{"scrypt n above 2^18", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 1 << 19, "r": 8, "p": 1, "salt": salt})},
+		{"scrypt cost above the standard", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 1 << 18, "r": 8, "p": 2, "salt": salt})},
+		{"scrypt n not a power of two", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4097, "r": 8, "p": 1, "salt": salt})},
+		{"scrypt r other than 8", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096, "r": 1024, "p": 1, "salt": salt})},
+		{"scrypt without p", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096, "r": 8, "salt": salt})},
+		{"scrypt dklen other than 32", withKDF("scrypt", map[string]interface{}{"dklen": 64, "n": 4096, "r": 8, "p": 1, "salt": salt})},
+		{"scrypt string n", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": "4096", "r": 8, "p": 1, "salt": salt})},
+		{"scrypt fractional n", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096.5, "r": 8, "p": 1, "salt": salt})},
+		{"scrypt numeric salt", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096, "r": 8, "p": 1, "salt": 1})},
+		{"scrypt non hex salt", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096, "r": 8, "p": 1, "salt": "zz"})},
+		{"scrypt oversized salt", withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": 4096, "r": 8, "p": 1, "salt": strings.Repeat("ab", 65)})},
+		{"pbkdf2 c above 2^20", withKDF("pbkdf2", map[string]interface{}{"dklen": 32, "c": 1<<20 + 1, "prf": "hmac-sha256", "salt": salt})},
+		{"pbkdf2 other prf", withKDF("pbkdf2", map[string]interface{}{"dklen": 32, "c": 1 << 18, "prf": "hmac-sha512", "salt": salt})},
+		{"pbkdf2 string c", withKDF("pbkdf2", map[string]interface{}{"dklen": 32, "c": "262144", "prf": "hmac-sha256", "salt": salt})},
+		{"unknown kdf", withKDF("argon2id", map[string]interface{}{"dklen": 32, "salt": salt})},
+		{"kdfparams not an object", withKDF("scrypt", nil)},
+	} {
+		_, err := keyGen.ImportPrivateKey(tt.keystore, "passphrase")
+		if err == nil || !strings.Contains(err.Error(), "Invalid or unsupported keystore") {
+			t.Errorf("%s: expected the keystore to be refused, got %v", tt.name, err)
+		}
+	}
+
+	// Bounded parameters reach the decryption
+	imported, err := keyGen.ImportPrivateKey(withKDF("scrypt", map[string]interface{}{"dklen": 32, "n": keystore.LightScryptN, "r": 8, "p": keystore.LightScryptP, "salt": originalSalt}), "passphrase")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if imported.Address != keyPair.Address {
+		t.Errorf("Expected %s, got %s", keyPair.Address, imported.Address)
+	}
+	_, err = keyGen.ImportPrivateKey(withKDF("pbkdf2", map[string]interface{}{"dklen": 32, "c": 1024, "prf": "hmac-sha256", "salt": salt}), "passphrase")
+	if err == nil || !strings.Contains(err.Error(), "Wrong passphrase") {
+		t.Errorf("Expected the pbkdf2 keystore to be decrypted and fail its MAC, got %v", err)
+	}
+}
+
+func TestSignMessageVector(t *testing.T) {
+	// Example of web3.eth.accounts.sign
+	keyGen := &ethereum.EthereumKeyGen{}
+	signature, err := keyGen.SignMessage("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318", []byte("Some data"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"
+	if signature.Signature != expected {
+		t.Errorf("Expected %s, got %s", expected, signature.Signature)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	keyGen := &ethereum.EthereumKeyGen{MasterSeed: []byte("test-master-seed-1234")}
+	keyPair, err := keyGen.GenerateKeyPairAndAddress(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	message := []byte("Hello World")
+
+	signature, err := keyGen.SignMessage(keyPair.PrivateKey, message)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if signature.Scheme != ethereum.SchemeEIP191 {
+		t.Errorf("Unexpected scheme %s", signature.Scheme)
+	}
+	decoded, err := hexutil.Decode(signature.Signature)
+	if err != nil || len(decoded) != 65 || (decoded[64] != 27 && decoded[64] != 28) {
+		t.Fatalf("Expected a 65 byte signature with v 27 or 28, got %s", signature.Signature)
+	}
+	// personal_sign signs the prefixed message
+	publicKey, err := crypto.SigToPub(accounts.TextHash(message), append(decoded[:64:64], decoded[64]-27))
+	if err != nil || crypto.PubkeyToAddress(*publicKey).Hex() != keyPair.Address {
+		t.Errorf("Expected the signature to recover to %s", keyPair.Address)
+	}
+
+	for _, address := range []string{keyPair.Address, strings.ToLower(keyPair.Address)} {
+		valid, err := keyGen.VerifyMessage(address, message, signature.Signature)
+		if err != nil || !valid {
+			t.Errorf("Expected the signature to verify for %s, got %v", address, err)
+		}
+	}
+	// v of 0 or 1 is accepted as well
+	valid, err := keyGen.VerifyMessage(keyPair.Address, message, hexutil.Encode(append(decoded[:64:64], decoded[64]-27)))
+	if err != nil || !valid {
+		t.Errorf("Expected the signature with v %d to verify, got %v", decoded[64]-27, err)
+	}
+	if valid, _ := keyGen.VerifyMessage(keyPair.Address, []byte("Hello World!"), signature.Signature); valid {
+		t.Error("Expected the signature not to verify another message")
+	}
+	if valid, _ := keyGen.VerifyMessage("0x0000000000000000000000000000000000000001", message, signature.Signature); valid {
+		t.Error("Expected the signature not to verify for another address")
+	}
+	for _, tt := range []struct{ address, signature string }{
+		{"not-an-address", signature.Signature},
+		{keyPair.Address, "0x1234"},
+	} {
+		if _, err := keyGen.VerifyMessage(tt.address, message, tt.signature); err == nil {
+			t.Errorf("Expected an error for %s %s", tt.address, tt.signature)
+		}
+	}
+}
+
+// mailTypedData is the example of EIP-712
+const mailTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+func TestSignTypedData(t *testing.T) {
+	// The private key of the example is keccak256("cow")
+	privateKey := hex.EncodeToString(crypto.Keccak256([]byte("cow")))
+	keyGen := &ethereum.EthereumKeyGen{}
+
+	signature, err := keyGen.SignTypedData(privateKey, []byte(mailTypedData))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if signature.Scheme != ethereum.SchemeEIP712 {
+		t.Errorf("Unexpected scheme %s", signature.Scheme)
+	}
+	if expected := "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"; signature.Digest != expected {
+		t.Errorf("Expected digest %s, got %s", expected, signature.Digest)
+	}
+	expected := "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"
+	if signature.Signature != expected {
+		t.Errorf("Expected signature %s, got %s", expected, signature.Signature)
+	}
+
+	// The domain has to be for the chain of the network
+	sepolia := &ethereum.EthereumKeyGen{Chain: ethereum.Sepolia}
+	if _, err := sepolia.SignTypedData(privateKey, []byte(mailTypedData)); err == nil {
+		t.Error("Expected an error for a mainnet domain on sepolia")
+	}
+
+	for name, typedData := range map[string]string{
+		"not JSON":             "{",
+		"unknown primary type": strings.Replace(mailTypedData, `"primaryType": "Mail"`, `"primaryType": "Letter"`, 1),
+		"domain primary type":  strings.Replace(mailTypedData, `"primaryType": "Mail"`, `"primaryType": "EIP712Domain"`, 1),
+		"no domain type":       strings.Replace(mailTypedData, `"EIP712Domain"`, `"Domain"`, 1),
+		"undefined type":       strings.Replace(mailTypedData, `"type": "Person"}`, `"type": "Human"}`, 1),
+		"invalid address":      strings.Replace(mailTypedData, `0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB`, `Bob`, 1),
+		"extra field":          strings.Replace(mailTypedData, `"contents": "Hello, Bob!"`, `"contents": "Hello, Bob!", "cc": "Alice"`, 1),
+		"no message":           strings.Replace(mailTypedData, `"message"`, `"body"`, 1),
+		"no domain chainId":    strings.Replace(mailTypedData, `"chainId": 1,`, ``, 1),
+		"no chainId type":      strings.Replace(mailTypedData, `{"name": "chainId", "type": "uint256"},`, ``, 1),
+		"no chainId at all":    strings.Replace(strings.Replace(mailTypedData, `"chainId": 1,`, ``, 1), `{"name": "chainId", "type": "uint256"},`, ``, 1),
+		"string chainId type":  strings.Replace(mailTypedData, `{"name": "chainId", "type": "uint256"}`, `{"name": "chainId", "type": "string"}`, 1),
+	} {
+		if _, err := keyGen.SignTypedData(privateKey, []byte(typedData)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHashTypedDataKeepsLargeIntegers(t *testing.T) {
+	permit := `{
+		"types": {
+			"EIP712Domain": [{"name": "chainId", "type": "uint256"}],
+			"Permit": [{"name": "value", "type": "uint256"}]
+		},
+		"primaryType": "Permit",
+		"domain": {"chainId": 1},
+		"message": {"value": %s}
+	}`
+	keyGen := &ethereum.EthereumKeyGen{}
+	number, err := keyGen.HashTypedData([]byte(fmt.Sprintf(permit, "1000000000000000001")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	quoted, err := keyGen.HashTypedData([]byte(fmt.Sprintf(permit, `"1000000000000000001"`)))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rounded, err := keyGen.HashTypedData([]byte(fmt.Sprintf(permit, "1000000000000000000")))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(number, quoted) || bytes.Equal(number, rounded) {
+		t.Error("Expected numbers to be hashed exactly")
+	}
+}
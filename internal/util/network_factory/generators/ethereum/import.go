@@ -0,0 +1,120 @@
+package ethereum
+
+import (
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// maxKeystoreScryptCost bounds N·P of imported keystores to the cost of
+	// the standard scrypt parameters, 256 MiB and about a second per import.
+	maxKeystoreScryptCost = keystore.StandardScryptN * keystore.StandardScryptP
+	// maxKeystorePBKDF2Rounds bounds the iterations of pbkdf2 keystores.
+	maxKeystorePBKDF2Rounds = 1 << 20
+	// maxKeystoreSaltLen bounds the salt, in bytes.
+	maxKeystoreSaltLen = 64
+)
+
+// keystoreKDF holds the key derivation of a keystore v3 JSON.
+type keystoreKDF struct {
+	Crypto struct {
+		KDF       string          `json:"kdf"`
+		KDFParams json.RawMessage `json:"kdfparams"`
+	} `json:"crypto"`
+}
+
+type scryptParams struct {
+	DKLen int    `json:"dklen"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+}
+
+type pbkdf2Params struct {
+	DKLen int    `json:"dklen"`
+	C     int    `json:"c"`
+	PRF   string `json:"prf"`
+	Salt  string `json:"salt"`
+}
+
+// checkKeystoreKDF refuses keystores whose key derivation is malformed or
+// costlier than the standard parameters, before any key is derived: the
+// parameters come from the caller and keystore.DecryptKey neither bounds nor
+// type checks them.
+func checkKeystoreKDF(keystoreJSON []byte) bool {
+	var kdf keystoreKDF
+	if err := json.Unmarshal(keystoreJSON, &kdf); err != nil {
+		return false
+	}
+	switch kdf.Crypto.KDF {
+	case "scrypt":
+		var params scryptParams
+		if err := json.Unmarshal(kdf.Crypto.KDFParams, &params); err != nil {
+			return false
+		}
+		return params.DKLen == 32 && params.R == 8 &&
+			params.N > 1 && params.N&(params.N-1) == 0 && params.P > 0 &&
+			params.N <= maxKeystoreScryptCost/params.P &&
+			validKeystoreSalt(params.Salt)
+	case "pbkdf2":
+		var params pbkdf2Params
+		if err := json.Unmarshal(kdf.Crypto.KDFParams, &params); err != nil {
+			return false
+		}
+		return params.DKLen == 32 && params.PRF == "hmac-sha256" &&
+			params.C > 0 && params.C <= maxKeystorePBKDF2Rounds &&
+			validKeystoreSalt(params.Salt)
+	}
+	return false
+}
+
+func validKeystoreSalt(salt string) bool {
+	decoded, err := hex.DecodeString(salt)
+	return err == nil && len(decoded) > 0 && len(decoded) <= maxKeystoreSaltLen
+}
+
+// ImportPrivateKey decodes a hex or, with passphrase, a keystore v3 JSON
+// private key. Keystores are only decrypted with scrypt or pbkdf2 parameters
+// no costlier than the standard ones.
+func (g *EthereumKeyGen) ImportPrivateKey(privateKey, passphrase string) (KeyPairAndAddress, error) {
+	privateKey = strings.TrimSpace(privateKey)
+
+	var ecdsaKey *ecdsa.PrivateKey
+	if strings.HasPrefix(privateKey, "{") {
+		if passphrase == "" {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "A passphrase is required to decrypt a keystore")
+		}
+		if !checkKeystoreKDF([]byte(privateKey)) {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid or unsupported keystore")
+		}
+		key, err := keystore.DecryptKey([]byte(privateKey), passphrase)
+		if err == keystore.ErrDecrypt {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Wrong passphrase for the keystore")
+		}
+		if err != nil {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Invalid or unsupported keystore")
+		}
+		ecdsaKey = key.PrivateKey
+	} else {
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+		if err != nil {
+			return KeyPairAndAddress{}, errors.NewKeyGenError(400, "Private key must be a hex or keystore v3 encoded Ethereum key")
+		}
+		ecdsaKey = key
+	}
+
+	keyPairAndAddress, err := g.DescribePublicKey(crypto.CompressPubkey(&ecdsaKey.PublicKey))
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	keyPairAndAddress.PrivateKey = hex.EncodeToString(crypto.FromECDSA(ecdsaKey))
+	return keyPairAndAddress, nil
+}
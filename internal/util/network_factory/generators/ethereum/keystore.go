@@ -0,0 +1,46 @@
+package ethereum
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+// FormatKeystore is the passphrase-encrypted private key encoding of
+// Ethereum keys, see EncryptKeystore.
+const FormatKeystore = "keystore-v3"
+
+// EncryptKeystore encrypts a hex private key with passphrase as Web3 Secret
+// Storage v3 JSON, with scrypt parameters scryptN and scryptP, e.g.
+// keystore.StandardScryptN and keystore.StandardScryptP.
+func EncryptKeystore(privateKey, passphrase string, scryptN, scryptP int) (string, error) {
+	ecdsaKey, err := crypto.HexToECDSA(privateKey)
+	if err != nil {
+		return "", err
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := keystore.EncryptKey(&keystore.Key{
+		Id:         id,
+		Address:    crypto.PubkeyToAddress(ecdsaKey.PublicKey),
+		PrivateKey: ecdsaKey,
+	}, passphrase, scryptN, scryptP)
+	if err != nil {
+		return "", err
+	}
+	return string(encrypted), nil
+}
+
+// EncryptedKeyFormat reports the passphrase-encrypted encoding of Ethereum
+// keys.
+func (g *EthereumKeyGen) EncryptedKeyFormat() string {
+	return FormatKeystore
+}
+
+// EncryptPrivateKey encrypts a hex private key with passphrase as a keystore
+// with the standard scrypt parameters, the ones geth uses.
+func (g *EthereumKeyGen) EncryptPrivateKey(privateKey, passphrase string) (string, error) {
+	return EncryptKeystore(privateKey, passphrase, keystore.StandardScryptN, keystore.StandardScryptP)
+}
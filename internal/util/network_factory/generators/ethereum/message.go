@@ -0,0 +1,67 @@
+package ethereum
+
+import (
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SchemeEIP191 is the personal_sign signature of Ethereum keys, over the
+// message prefixed with "\x19Ethereum Signed Message:\n" and its length.
+const SchemeEIP191 = "eip-191"
+
+// SignMessage signs message with a hex private key as personal_sign does.
+// The signature is 0x prefixed hex of r, s and v, with v 27 or 28.
+func (g *EthereumKeyGen) SignMessage(privateKey string, message []byte) (MessageSignature, error) {
+	signature, err := signHash(privateKey, accounts.TextHash(message))
+	if err != nil {
+		return MessageSignature{}, err
+	}
+	return MessageSignature{Scheme: SchemeEIP191, Signature: signature}, nil
+}
+
+// VerifyMessage verifies a personal_sign signature, with v 0, 1, 27 or 28.
+func (g *EthereumKeyGen) VerifyMessage(address string, message []byte, signature string) (bool, error) {
+	return verifyHash(address, accounts.TextHash(message), signature)
+}
+
+// signHash signs a 32 byte hash with a hex private key, with v 27 or 28 as
+// wallets return it.
+func signHash(privateKey string, hash []byte) (string, error) {
+	ecdsaKey, err := crypto.HexToECDSA(privateKey)
+	if err != nil {
+		return "", errors.NewKeyGenError(400, "Invalid Ethereum private key")
+	}
+	signature, err := crypto.Sign(hash, ecdsaKey)
+	if err != nil {
+		return "", err
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(signature), nil
+}
+
+func verifyHash(address string, hash []byte, signature string) (bool, error) {
+	if !common.IsHexAddress(address) {
+		return false, errors.NewKeyGenError(400, "Invalid Ethereum address")
+	}
+	decoded, err := hexutil.Decode(signature)
+	if err != nil || len(decoded) != crypto.SignatureLength {
+		return false, errors.NewKeyGenError(400, "Signature must be 65 bytes of 0x prefixed hex")
+	}
+	if decoded[crypto.RecoveryIDOffset] >= 27 {
+		decoded[crypto.RecoveryIDOffset] -= 27
+	}
+	if decoded[crypto.RecoveryIDOffset] > 1 {
+		return false, nil
+	}
+
+	publicKey, err := crypto.SigToPub(hash, decoded)
+	if err != nil {
+		return false, nil
+	}
+	return crypto.PubkeyToAddress(*publicKey) == common.HexToAddress(address), nil
+}
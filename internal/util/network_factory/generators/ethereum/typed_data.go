@@ -0,0 +1,98 @@
+package ethereum
+
+import (
+	"bytes"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/sirupsen/logrus"
+)
+
+// SchemeEIP712 is the signature of EIP-712 typed structured data, as
+// eth_signTypedData_v4 returns it.
+const SchemeEIP712 = "eip-712"
+
+// SignTypedData signs the EIP-712 digest of typedData, see HashTypedData,
+// with a hex private key. The signature is 0x prefixed hex of r, s and v,
+// with v 27 or 28.
+func (g *EthereumKeyGen) SignTypedData(privateKey string, typedData []byte) (MessageSignature, error) {
+	digest, err := g.HashTypedData(typedData)
+	if err != nil {
+		return MessageSignature{}, err
+	}
+	signature, err := signHash(privateKey, digest)
+	if err != nil {
+		return MessageSignature{}, err
+	}
+	return MessageSignature{Scheme: SchemeEIP712, Signature: signature, Digest: hexutil.Encode(digest)}, nil
+}
+
+// HashTypedData validates typedData, the JSON object of eth_signTypedData_v4
+// with types, primaryType, domain and message, and returns its EIP-712
+// digest. The domain has to declare the chainId of the configured chain.
+func (g *EthereumKeyGen) HashTypedData(data []byte) ([]byte, error) {
+	var typedData apitypes.TypedData
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Numbers are kept exact, apitypes would parse them as float64
+	decoder.UseNumber()
+	if err := decoder.Decode(&typedData); err != nil {
+		return nil, errors.NewKeyGenError(400, "Typed data must be a JSON object with types, primaryType, domain and message")
+	}
+	numbersToStrings(map[string]interface{}(typedData.Message))
+
+	domainType, ok := typedData.Types["EIP712Domain"]
+	if !ok {
+		return nil, errors.NewKeyGenError(400, "Typed data must declare the EIP712Domain type")
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok || typedData.PrimaryType == "EIP712Domain" {
+		return nil, errors.NewKeyGenError(400, "Typed data primaryType must be one of its types")
+	}
+	// Without a chainId the signature could be replayed on every chain
+	if !declaresChainID(domainType) || typedData.Domain.ChainId == nil {
+		return nil, errors.NewKeyGenError(400, "Typed data domain must declare a uint256 chainId")
+	}
+	if id := (*hexutil.Big)(typedData.Domain.ChainId).ToInt(); !id.IsUint64() || id.Uint64() != g.chain().ID {
+		return nil, errors.NewKeyGenError(400, "Typed data domain chainId does not match the network")
+	}
+
+	digest, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		logrus.WithError(err).Warn("Invalid EIP-712 typed data")
+		return nil, errors.NewKeyGenError(400, "Invalid typed data: "+err.Error())
+	}
+	return digest, nil
+}
+
+func declaresChainID(domainType []apitypes.Type) bool {
+	for _, field := range domainType {
+		if field.Name == "chainId" {
+			return field.Type == "uint256"
+		}
+	}
+	return false
+}
+
+// numbersToStrings replaces the json.Numbers in a decoded JSON value by their
+// literal, which apitypes parses exactly. Objects and arrays are updated in
+// place.
+func numbersToStrings(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		return value.String()
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = numbersToStrings(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = numbersToStrings(item)
+		}
+		return value
+	default:
+		return value
+	}
+}
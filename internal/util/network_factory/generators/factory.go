@@ -5,14 +5,34 @@ import (
 	"crypto-keygen-service/internal/util/network_factory"
 	"crypto-keygen-service/internal/util/network_factory/generators/bitcoin"
 	"crypto-keygen-service/internal/util/network_factory/generators/ethereum"
+	"strings"
 )
 
+// GetKeyGenerator resolves network identifiers of the form
+// <coin>[-<chain>][-<address type>], e.g. "bitcoin", "bitcoin-testnet-p2wpkh"
+// or "ethereum-sepolia".
 func GetKeyGenerator(network string) (network_factory.KeyGenerator, error) {
-	switch network {
+	coin, rest, _ := strings.Cut(network, "-")
+	switch coin {
 	case "bitcoin":
-		return &bitcoin.BitcoinKeyGen{}, nil
+		suffix, addressType := rest, bitcoin.DefaultAddressType
+		for _, t := range bitcoin.AddressTypes {
+			if s, ok := strings.CutSuffix(rest, string(t)); ok && (s == "" || strings.HasSuffix(s, "-")) {
+				suffix, addressType = strings.TrimSuffix(s, "-"), t
+				break
+			}
+		}
+		params, ok := bitcoin.Networks[suffix]
+		if !ok {
+			return nil, errors.ErrUnsupportedNetwork
+		}
+		return &bitcoin.BitcoinKeyGen{AddressType: addressType, Params: params}, nil
 	case "ethereum":
-		return &ethereum.EthereumKeyGen{}, nil
+		chain, ok := ethereum.Chains[rest]
+		if !ok {
+			return nil, errors.ErrUnsupportedNetwork
+		}
+		return &ethereum.EthereumKeyGen{Chain: chain}, nil
 	default:
 		return nil, errors.ErrUnsupportedNetwork
 	}
@@ -1,6 +1,7 @@
 package generators
 
 import (
+	"crypto-keygen-service/internal/util/network_factory"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,18 @@ func TestGetKeyGenerator(t *testing.T) {
 	generator, err = GetKeyGenerator("ethereum")
 	assert.NoError(t, err)
 	assert.NotNil(t, generator)
+	generator, err = GetKeyGenerator("bitcoin-p2wpkh")
+	assert.NoError(t, err)
+	assert.NotNil(t, generator)
+	generator, err = GetKeyGenerator("bitcoin-testnet-p2tr")
+	assert.NoError(t, err)
+	assert.False(t, generator.(network_factory.ChainInfo).IsMainnet())
+	generator, err = GetKeyGenerator("ethereum-sepolia")
+	assert.NoError(t, err)
+	assert.False(t, generator.(network_factory.ChainInfo).IsMainnet())
+	generator, err = GetKeyGenerator("bitcoin-p2xx")
+	assert.Error(t, err)
+	assert.Nil(t, generator)
 	generator, err = GetKeyGenerator("unsupported")
 	assert.Error(t, err)
 	assert.Nil(t, generator)
@@ -0,0 +1,97 @@
+package watchonly
+
+import (
+	"bytes"
+	"crypto-keygen-service/internal/util/errors"
+	. "crypto-keygen-service/internal/util/network_factory"
+	"crypto-keygen-service/internal/util/network_factory/hd"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidExtendedPublicKey = errors.NewKeyGenError(400, "Invalid extended public key")
+	ErrExtendedPublicKeyNetwork = errors.NewKeyGenError(400, "Extended public key is not for the network of the account")
+)
+
+// WatchOnlyKeyGen derives addresses from an account-level extended public key
+// registered by a tenant who keeps the seed. It never sees a private key; the
+// base generator only provides the address encoding of its network.
+type WatchOnlyKeyGen struct {
+	accountKey  *hdkeychain.ExtendedKey
+	accountPath string
+	base        PublicKeyDescriber
+}
+
+// NewWatchOnlyKeyGen parses extendedPublicKey (xpub, ypub, zpub, tpub, ...),
+// whose version has to be one of the network of base. An empty accountPath
+// defaults to the standard account path of base.
+func NewWatchOnlyKeyGen(base PublicKeyDescriber, extendedPublicKey, accountPath string) (*WatchOnlyKeyGen, error) {
+	accountKey, err := hdkeychain.NewKeyFromString(extendedPublicKey)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to parse extended public key")
+		return nil, ErrInvalidExtendedPublicKey
+	}
+	if accountKey.IsPrivate() {
+		return nil, errors.NewKeyGenError(400, "Extended private keys are not accepted, register the extended public key")
+	}
+	// A tpub must not serve mainnet addresses, nor an xpub testnet ones
+	if !slices.ContainsFunc(base.ExtendedPublicKeyVersions(), func(version []byte) bool {
+		return bytes.Equal(version, accountKey.Version())
+	}) {
+		return nil, ErrExtendedPublicKeyNetwork
+	}
+
+	if accountPath == "" {
+		accountPath = base.AccountPath()
+	}
+	if _, err := hd.ParsePath(accountPath); err != nil {
+		return nil, errors.NewKeyGenError(400, err.Error())
+	}
+
+	return &WatchOnlyKeyGen{accountKey: accountKey, accountPath: accountPath, base: base}, nil
+}
+
+func (g *WatchOnlyKeyGen) GenerateKeyPairAndAddress(userID int) (KeyPairAndAddress, error) {
+	if userID < 0 || uint64(userID) >= uint64(hd.HardenedKeyStart) {
+		return KeyPairAndAddress{}, errors.ErrUserIDOutOfRange
+	}
+
+	// Derive <account>/0/userID, public derivation only
+	childKey, err := hd.Derive(g.accountKey, hd.Path{0, uint32(userID)})
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	publicKey, err := childKey.ECPubKey()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to derive watch-only public key")
+		return KeyPairAndAddress{}, errors.NewKeyGenError(500, "Failed to derive watch-only public key")
+	}
+
+	keyPairAndAddress, err := g.base.DescribePublicKey(publicKey.SerializeCompressed())
+	if err != nil {
+		return KeyPairAndAddress{}, err
+	}
+	keyPairAndAddress.DerivationPath = fmt.Sprintf("%s/0/%d", g.accountPath, userID)
+	keyPairAndAddress.WatchOnly = true
+
+	logrus.WithField("derivation_path", keyPairAndAddress.DerivationPath).Info("Derived watch-only address")
+	return keyPairAndAddress, nil
+}
+
+// AccountKey returns the registered extended public key. The master
+// fingerprint is unknown to the service.
+func (g *WatchOnlyKeyGen) AccountKey() (AccountKey, error) {
+	return AccountKey{
+		ExtendedPublicKey: g.accountKey.String(),
+		DerivationPath:    g.accountPath,
+	}, nil
+}
+
+func (g *WatchOnlyKeyGen) IsMainnet() bool {
+	chainInfo, ok := g.base.(ChainInfo)
+	return ok && chainInfo.IsMainnet()
+}
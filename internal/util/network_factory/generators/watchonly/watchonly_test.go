@@ -0,0 +1,88 @@
+package watchonly_test
+
+import (
+	"crypto-keygen-service/internal/util/network_factory"
+	"crypto-keygen-service/internal/util/network_factory/generators/bitcoin"
+	"crypto-keygen-service/internal/util/network_factory/generators/ethereum"
+	"crypto-keygen-service/internal/util/network_factory/generators/watchonly"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+)
+
+// BIP39 seed of "abandon abandon ... about" with an empty passphrase.
+const vectorSeedHex = "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+
+func TestWatchOnlyMatchesSeedDerivation(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+
+	bases := []interface {
+		network_factory.KeyGenerator
+		network_factory.AccountKeyExporter
+		network_factory.PublicKeyDescriber
+	}{
+		&bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: bitcoin.P2WPKH},
+		&bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: bitcoin.P2TR},
+		&ethereum.EthereumKeyGen{MasterSeed: masterSeed},
+	}
+
+	for _, base := range bases {
+		accountKey, err := base.AccountKey()
+		assert.NoError(t, err)
+
+		// The base generator is only used for address encoding
+		keyGen, err := watchonly.NewWatchOnlyKeyGen(base, accountKey.ExtendedPublicKey, "")
+		assert.NoError(t, err)
+
+		for _, userID := range []int{0, 42} {
+			expected, err := base.GenerateKeyPairAndAddress(userID)
+			assert.NoError(t, err)
+			watched, err := keyGen.GenerateKeyPairAndAddress(userID)
+			assert.NoError(t, err)
+
+			assert.Equal(t, expected.Address, watched.Address)
+			assert.Equal(t, expected.PublicKey, watched.PublicKey)
+			assert.Equal(t, expected.DerivationPath, watched.DerivationPath)
+			assert.Empty(t, watched.PrivateKey)
+			assert.True(t, watched.WatchOnly)
+		}
+	}
+}
+
+func TestNewWatchOnlyKeyGenRejectsInvalidKeys(t *testing.T) {
+	base := &bitcoin.BitcoinKeyGen{}
+
+	_, err := watchonly.NewWatchOnlyKeyGen(base, "not-an-xpub", "")
+	assert.Error(t, err)
+
+	// BIP32 test vector 1 master private key
+	_, err = watchonly.NewWatchOnlyKeyGen(base, "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi", "")
+	assert.Error(t, err)
+
+	_, err = watchonly.NewWatchOnlyKeyGen(base, "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8", "84'/0'/0'")
+	assert.Error(t, err)
+}
+
+func TestNewWatchOnlyKeyGenRejectsOtherNetworks(t *testing.T) {
+	masterSeed, _ := hex.DecodeString(vectorSeedHex)
+	mainnet := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: bitcoin.P2WPKH}
+	testnet := &bitcoin.BitcoinKeyGen{MasterSeed: masterSeed, AddressType: bitcoin.P2WPKH, Params: &chaincfg.TestNet3Params}
+
+	zpub, err := mainnet.AccountKey()
+	assert.NoError(t, err)
+	vpub, err := testnet.AccountKey()
+	assert.NoError(t, err)
+
+	_, err = watchonly.NewWatchOnlyKeyGen(mainnet, vpub.ExtendedPublicKey, "")
+	assert.Equal(t, watchonly.ErrExtendedPublicKeyNetwork, err)
+	_, err = watchonly.NewWatchOnlyKeyGen(testnet, zpub.ExtendedPublicKey, "")
+	assert.Equal(t, watchonly.ErrExtendedPublicKeyNetwork, err)
+	_, err = watchonly.NewWatchOnlyKeyGen(&ethereum.EthereumKeyGen{Chain: ethereum.Sepolia}, vpub.ExtendedPublicKey, "")
+	assert.Equal(t, watchonly.ErrExtendedPublicKeyNetwork, err)
+
+	// Any address type of the network is accepted, e.g. a vpub for P2PKH
+	_, err = watchonly.NewWatchOnlyKeyGen(&bitcoin.BitcoinKeyGen{Params: &chaincfg.TestNet3Params}, vpub.ExtendedPublicKey, "")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,169 @@
+package hd
+
+import (
+	"crypto-keygen-service/internal/util/errors"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HardenedKeyStart is the index offset of hardened children (BIP32).
+	HardenedKeyStart = hdkeychain.HardenedKeyStart
+
+	PurposeBIP44 uint32 = 44 // P2PKH
+	PurposeBIP49 uint32 = 49 // P2SH-P2WPKH
+	PurposeBIP84 uint32 = 84 // P2WPKH
+	PurposeBIP86 uint32 = 86 // P2TR
+
+	CoinTypeBitcoin  uint32 = 0
+	CoinTypeTestnet  uint32 = 1 // all Bitcoin and Ethereum test networks (SLIP-44)
+	CoinTypeEthereum uint32 = 60
+)
+
+// Path is a BIP32 derivation path relative to the master key.
+type Path []uint32
+
+// NewBIP44Path returns m/purpose'/coinType'/account'/change/index.
+func NewBIP44Path(purpose, coinType, account, change, index uint32) Path {
+	return Path{
+		purpose + HardenedKeyStart,
+		coinType + HardenedKeyStart,
+		account + HardenedKeyStart,
+		change,
+		index,
+	}
+}
+
+// AccountPath returns m/purpose'/coinType'/account'.
+func AccountPath(purpose, coinType, account uint32) Path {
+	return Path{
+		purpose + HardenedKeyStart,
+		coinType + HardenedKeyStart,
+		account + HardenedKeyStart,
+	}
+}
+
+// UserPath maps a user ID onto the external chain of the first account,
+// e.g. m/44'/0'/0'/0/userID.
+func UserPath(purpose, coinType uint32, userID int) (Path, error) {
+	if userID < 0 || uint64(userID) >= uint64(HardenedKeyStart) {
+		return nil, errors.ErrUserIDOutOfRange
+	}
+	return NewBIP44Path(purpose, coinType, 0, 0, uint32(userID)), nil
+}
+
+func (p Path) String() string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, i := range p {
+		if i >= HardenedKeyStart {
+			fmt.Fprintf(&sb, "/%d'", i-HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&sb, "/%d", i)
+		}
+	}
+	return sb.String()
+}
+
+// ParsePath parses paths of the form m/44'/0'/0'/0/1. Both ' and h are
+// accepted as hardened markers.
+func ParsePath(s string) (Path, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q", s)
+	}
+	path := make(Path, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedKeyStart {
+			return nil, fmt.Errorf("invalid derivation path %q", s)
+		}
+		if hardened {
+			index += uint64(HardenedKeyStart)
+		}
+		path = append(path, uint32(index))
+	}
+	return path, nil
+}
+
+// NewMasterKey builds the BIP32 master key for the given seed.
+func NewMasterKey(masterSeed []byte) (*hdkeychain.ExtendedKey, error) {
+	master, err := hdkeychain.NewMaster(masterSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to create BIP32 master key")
+		return nil, errors.NewKeyGenError(500, "Failed to create BIP32 master key")
+	}
+	return master, nil
+}
+
+// Derive walks path starting at key.
+func Derive(key *hdkeychain.ExtendedKey, path Path) (*hdkeychain.ExtendedKey, error) {
+	var err error
+	for _, i := range path {
+		key, err = key.Derive(i)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to derive BIP32 child key")
+			return nil, errors.NewKeyGenError(500, "Failed to derive BIP32 child key")
+		}
+	}
+	return key, nil
+}
+
+// Fingerprint returns the BIP32 key identifier prefix of key, as used by
+// wallets and output descriptors to reference the master key.
+func Fingerprint(key *hdkeychain.ExtendedKey) (string, error) {
+	publicKey, err := key.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(btcutil.Hash160(publicKey.SerializeCompressed())[:4]), nil
+}
+
+// AccountExtendedPublicKey derives path from the master key of masterSeed and
+// returns the neutered key serialized with the given version bytes, together
+// with the fingerprint of the master key.
+func AccountExtendedPublicKey(masterSeed []byte, path Path, version []byte) (string, string, error) {
+	master, err := NewMasterKey(masterSeed)
+	if err != nil {
+		return "", "", err
+	}
+	fingerprint, err := Fingerprint(master)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to compute master key fingerprint")
+		return "", "", errors.NewKeyGenError(500, "Failed to compute master key fingerprint")
+	}
+
+	account, err := Derive(master, path)
+	if err != nil {
+		return "", "", err
+	}
+	public, err := account.Neuter()
+	if err == nil {
+		public, err = public.CloneWithVersion(version)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to export extended public key")
+		return "", "", errors.NewKeyGenError(500, "Failed to export extended public key")
+	}
+	return public.String(), fingerprint, nil
+}
+
+// DeriveFromSeed builds the master key for masterSeed and derives path from it.
+func DeriveFromSeed(masterSeed []byte, path Path) (*hdkeychain.ExtendedKey, error) {
+	master, err := NewMasterKey(masterSeed)
+	if err != nil {
+		return nil, err
+	}
+	return Derive(master, path)
+}
@@ -0,0 +1,30 @@
+package hd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathStringAndParse(t *testing.T) {
+	path := NewBIP44Path(PurposeBIP44, CoinTypeBitcoin, 0, 0, 7)
+	assert.Equal(t, "m/44'/0'/0'/0/7", path.String())
+
+	parsed, err := ParsePath("m/44'/0'/0'/0/7")
+	assert.NoError(t, err)
+	assert.Equal(t, path, parsed)
+
+	parsed, err = ParsePath("m/44h/60h/0h/0/7")
+	assert.NoError(t, err)
+	assert.Equal(t, NewBIP44Path(PurposeBIP44, CoinTypeEthereum, 0, 0, 7), parsed)
+
+	_, err = ParsePath("44'/0'")
+	assert.Error(t, err)
+	_, err = ParsePath("m/x")
+	assert.Error(t, err)
+}
+
+func TestUserPathRejectsHardenedRange(t *testing.T) {
+	_, err := UserPath(PurposeBIP44, CoinTypeBitcoin, int(HardenedKeyStart))
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+// Package shamir splits a secret into N shares of which any M recover it
+// (Shamir's secret sharing over GF(2^8)). Every share carries the threshold
+// and a fingerprint of the secret, so shares of different secrets cannot be
+// mixed and a wrong combination is detected.
+package shamir
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const MaxShares = 255
+
+var (
+	ErrInvalidShare     = errors.New("invalid share")
+	ErrTooFewShares     = errors.New("not enough shares")
+	ErrMismatchedShares = errors.New("shares belong to different secrets")
+	ErrDuplicateShare   = errors.New("duplicate share")
+	ErrSecretMismatch   = errors.New("combined secret does not match the share fingerprint")
+)
+
+// Share is one point of every byte's polynomial, evaluated at X.
+type Share struct {
+	// SetID is a fingerprint of the secret shared by all its shares.
+	SetID     uint32
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+// String encodes the share as <set id>-<threshold>-<x>-<hex y>.
+func (s Share) String() string {
+	return fmt.Sprintf("%08x-%d-%d-%s", s.SetID, s.Threshold, s.X, hex.EncodeToString(s.Y))
+}
+
+// ParseShare decodes a share encoded by String.
+func ParseShare(s string) (Share, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 {
+		return Share{}, ErrInvalidShare
+	}
+	setID, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return Share{}, ErrInvalidShare
+	}
+	threshold, err := strconv.Atoi(parts[1])
+	if err != nil || threshold < 2 || threshold > MaxShares {
+		return Share{}, ErrInvalidShare
+	}
+	x, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil || x == 0 {
+		return Share{}, ErrInvalidShare
+	}
+	y, err := hex.DecodeString(parts[3])
+	if err != nil || len(y) == 0 {
+		return Share{}, ErrInvalidShare
+	}
+	return Share{SetID: uint32(setID), Threshold: threshold, X: byte(x), Y: y}, nil
+}
+
+// Split divides secret into n shares, any threshold of which recover it.
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if threshold < 2 || threshold > n || n > MaxShares {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= %d", MaxShares)
+	}
+
+	setID := fingerprint(secret)
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{SetID: setID, Threshold: threshold, X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	coefficients := make([]byte, threshold)
+	defer zero(coefficients)
+	for j, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Y[j] = evaluate(coefficients, shares[i].X)
+		}
+	}
+	return shares, nil
+}
+
+// Combine recovers the secret from at least threshold shares.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrTooFewShares
+	}
+	first := shares[0]
+	seen := make(map[byte]bool, len(shares))
+	for _, s := range shares {
+		if s.SetID != first.SetID || s.Threshold != first.Threshold || len(s.Y) != len(first.Y) {
+			return nil, ErrMismatchedShares
+		}
+		if s.X == 0 {
+			return nil, ErrInvalidShare
+		}
+		if seen[s.X] {
+			return nil, ErrDuplicateShare
+		}
+		seen[s.X] = true
+	}
+	if len(shares) < first.Threshold {
+		return nil, ErrTooFewShares
+	}
+
+	secret := make([]byte, len(first.Y))
+	for j := range secret {
+		secret[j] = interpolateAtZero(shares, j)
+	}
+	if fingerprint(secret) != first.SetID {
+		zero(secret)
+		return nil, ErrSecretMismatch
+	}
+	return secret, nil
+}
+
+// CheckValue returns a keyed check value of secret that identifies it
+// without revealing it. Unlike the fingerprint carried by every share it is
+// kept apart from the shares, so whoever submits them cannot choose it.
+func CheckValue(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("crypto-keygen-service/shamir/check-value"))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func fingerprint(secret []byte) uint32 {
+	sum := sha256.Sum256(append([]byte("crypto-keygen-service/shamir/"), secret...))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// evaluate computes the polynomial at x with Horner's method.
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = mul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// interpolateAtZero evaluates the Lagrange polynomial through the j-th byte
+// of every share at x = 0.
+func interpolateAtZero(shares []Share, j int) byte {
+	var result byte
+	for i, si := range shares {
+		basis := byte(1)
+		for k, sk := range shares {
+			if i != k {
+				// x_k / (x_k - x_i), subtraction is xor in GF(2^8)
+				basis = mul(basis, div(sk.X, sk.X^si.X))
+			}
+		}
+		result ^= mul(si.Y[j], basis)
+	}
+	return result
+}
+
+// mul multiplies in GF(2^8) modulo the AES polynomial x^8 + x^4 + x^3 + x + 1
+// without data dependent branches.
+func mul(a, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= -(b & 1) & a
+		a = (a << 1) ^ (-(a >> 7) & 0x1b)
+		b >>= 1
+	}
+	return product
+}
+
+// div divides by b != 0 using b^254 = b^-1.
+func div(a, b byte) byte {
+	inverse := b
+	for i := 0; i < 6; i++ {
+		inverse = mul(mul(inverse, inverse), b)
+	}
+	return mul(a, mul(inverse, inverse))
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,73 @@
+package shamir
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestField(t *testing.T) {
+	// 0x53 * 0xca = 0x01 in the AES field
+	assert.Equal(t, byte(0x01), mul(0x53, 0xca))
+	for b := 1; b < 256; b++ {
+		assert.Equal(t, byte(1), mul(byte(b), div(1, byte(b))), b)
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("sample-master-seed")
+
+	shares, err := Split(secret, 5, 3)
+	assert.NoError(t, err)
+	assert.Len(t, shares, 5)
+
+	// Any three shares recover the secret
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var selected []Share
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		combined, err := Combine(selected)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, combined)
+	}
+
+	_, err = Combine(shares[:2])
+	assert.ErrorIs(t, err, ErrTooFewShares)
+	_, err = Combine([]Share{shares[0], shares[0], shares[1]})
+	assert.ErrorIs(t, err, ErrDuplicateShare)
+
+	// A corrupted share is detected
+	corrupted := shares[1]
+	corrupted.Y = append([]byte{}, corrupted.Y...)
+	corrupted.Y[0] ^= 1
+	_, err = Combine([]Share{shares[0], corrupted, shares[2]})
+	assert.ErrorIs(t, err, ErrSecretMismatch)
+
+	// Shares of another secret cannot be mixed in
+	other, err := Split([]byte("other-master-seedx"), 5, 3)
+	assert.NoError(t, err)
+	_, err = Combine([]Share{shares[0], shares[1], other[2]})
+	assert.ErrorIs(t, err, ErrMismatchedShares)
+
+	_, err = Split(secret, 2, 3)
+	assert.Error(t, err)
+	_, err = Split(secret, 3, 1)
+	assert.Error(t, err)
+}
+
+func TestShareEncoding(t *testing.T) {
+	shares, err := Split([]byte("sample-master-seed"), 3, 2)
+	assert.NoError(t, err)
+
+	encoded := shares[2].String()
+	assert.Regexp(t, `^[0-9a-f]{8}-2-3-[0-9a-f]{36}$`, encoded)
+	decoded, err := ParseShare(" " + encoded + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, shares[2], decoded)
+
+	for _, invalid := range []string{"", "zz-2-1-00", "0000000a-1-1-00", "0000000a-2-0-00", "0000000a-2-1-", "0000000a-2-1-0g"} {
+		_, err := ParseShare(invalid)
+		assert.ErrorIs(t, err, ErrInvalidShare, invalid)
+	}
+}
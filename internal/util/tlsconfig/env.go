@@ -0,0 +1,37 @@
+package tlsconfig
+
+import (
+	"fmt"
+	"os"
+)
+
+// ConfigFromEnv reads the TLS configuration of the service:
+//
+//	TLS_CERT_FILE, TLS_KEY_FILE  server certificate and key, TLS is off when unset
+//	TLS_CLIENT_CA_FILE           CAs of client certificates
+//	TLS_CLIENT_AUTH              none (default), optional or require
+//	TLS_MIN_VERSION              1.2 (default) or 1.3
+//	TLS_CIPHER_SUITES            comma separated TLS 1.2 cipher suite names
+//
+// ok is false when TLS is not configured.
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	cfg = Config{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		ClientAuth:   os.Getenv("TLS_CLIENT_AUTH"),
+	}
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		return Config{}, false, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return Config{}, false, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.MinVersion, err = ParseVersion(os.Getenv("TLS_MIN_VERSION")); err != nil {
+		return Config{}, false, fmt.Errorf("TLS_MIN_VERSION: %w", err)
+	}
+	if cfg.CipherSuites, err = ParseCipherSuites(os.Getenv("TLS_CIPHER_SUITES")); err != nil {
+		return Config{}, false, fmt.Errorf("TLS_CIPHER_SUITES: %w", err)
+	}
+	return cfg, true, nil
+}
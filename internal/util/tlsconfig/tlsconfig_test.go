@@ -0,0 +1,307 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crypto-keygen-service/internal/auth"
+	"crypto-keygen-service/internal/util/tlsconfig"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues the certificates of a test.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	serial      int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{certificate: certificate, key: key, serial: 1}
+}
+
+// issue returns the PEM encoded certificate and key of template.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca.serial++
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) serverCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) clientCertificate(t *testing.T, template *x509.Certificate) tls.Certificate {
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certificate, err := tls.X509KeyPair(ca.issue(t, template))
+	require.NoError(t, err)
+	return certificate
+}
+
+func (ca *testCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw})
+}
+
+// testFiles are the files of a tlsconfig.Config.
+type testFiles struct {
+	t                   *testing.T
+	cert, key, clientCA string
+	modified            time.Time
+}
+
+func newTestFiles(t *testing.T) *testFiles {
+	dir := t.TempDir()
+	return &testFiles{
+		t:        t,
+		cert:     filepath.Join(dir, "server.crt"),
+		key:      filepath.Join(dir, "server.key"),
+		clientCA: filepath.Join(dir, "clients.crt"),
+		modified: time.Now().Add(-time.Minute),
+	}
+}
+
+// write replaces the files, with a distinct modification time so that
+// coarse file system timestamps do not hide the change.
+func (f *testFiles) write(server *testCA, clientCA *testCA) {
+	certPEM, keyPEM := server.serverCertificate(f.t)
+	f.modified = f.modified.Add(time.Second)
+	for file, content := range map[string][]byte{f.cert: certPEM, f.key: keyPEM, f.clientCA: clientCA.pem()} {
+		require.NoError(f.t, os.WriteFile(file, content, 0o600))
+		require.NoError(f.t, os.Chtimes(file, f.modified, f.modified))
+	}
+}
+
+func (f *testFiles) config(clientAuth string) tlsconfig.Config {
+	return tlsconfig.Config{CertFile: f.cert, KeyFile: f.key, ClientCAFile: f.clientCA, ClientAuth: clientAuth}
+}
+
+// startServer serves the principal of each request, authenticated by its
+// client certificate.
+func startServer(t *testing.T, reloader *tlsconfig.Reloader) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(auth.Middleware(auth.NewCertificateAuthenticator([]string{auth.ScopeReadPublic})))
+	router.GET("/whoami", func(c *gin.Context) {
+		principal, _ := auth.PrincipalFrom(c.Request.Context())
+		c.String(http.StatusOK, principal.String())
+	})
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func client(serverCA *testCA, certificates ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.certificate)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certificates},
+		DisableKeepAlives: true,
+	}}
+}
+
+func get(client *http.Client, url string) (int, string, error) {
+	resp, err := client.Get(url + "/whoami")
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverCA, clientCA, otherCA := newTestCA(t, "server CA"), newTestCA(t, "client CA"), newTestCA(t, "other CA")
+	files := newTestFiles(t)
+	files.write(serverCA, clientCA)
+	reloader, err := tlsconfig.NewReloader(files.config(tlsconfig.ClientAuthRequire))
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+
+	spiffeID, _ := url.Parse("spiffe://example.org/billing")
+	tests := []struct {
+		name        string
+		certificate *x509.Certificate
+		identity    string
+	}{
+		{"URI SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffeID}, DNSNames: []string{"billing.internal"}}, "cert:spiffe://example.org/billing"},
+		{"DNS SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, DNSNames: []string{"billing.internal"}}, "cert:billing.internal"},
+		{"email SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, EmailAddresses: []string{"ops@example.org"}}, "cert:ops@example.org"},
+		{"subject", &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}, "cert:billing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body, err := get(client(serverCA, clientCA.clientCertificate(t, tt.certificate)), server.URL)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, tt.identity, body)
+		})
+	}
+
+	t.Run("without certificate", func(t *testing.T) {
+		_, _, err := get(client(serverCA), server.URL)
+		assert.Error(t, err)
+	})
+	t.Run("certificate of another CA", func(t *testing.T) {
+		_, _, err := get(client(serverCA, otherCA.clientCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})), server.URL)
+		assert.Error(t, err)
+	})
+	t.Run("certificate without name", func(t *testing.T) {
+		code, _, err := get(client(serverCA, clientCA.clientCertificate(t, &x509.Certificate{})), server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+}
+
+func TestOptionalClientCertificate(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server CA"), newTestCA(t, "client CA")
+	files := newTestFiles(t)
+	files.write(serverCA, clientCA)
+	reloader, err := tlsconfig.NewReloader(files.config(tlsconfig.ClientAuthOptional))
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+
+	// The connection is accepted, the request lacks credentials
+	code, _, err := get(client(serverCA), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body, err := get(client(serverCA, clientCA.clientCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "cert:billing", body)
+}
+
+func TestReload(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server CA"), newTestCA(t, "client CA")
+	files := newTestFiles(t)
+	files.write(serverCA, clientCA)
+	reloader, err := tlsconfig.NewReloader(files.config(tlsconfig.ClientAuthRequire))
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Watch(10*time.Millisecond, stop)
+
+	oldClient := client(serverCA, clientCA.clientCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "old"}}))
+	_, body, err := get(oldClient, server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "cert:old", body)
+
+	// Both CAs are rotated
+	newServerCA, newClientCA := newTestCA(t, "new server CA"), newTestCA(t, "new client CA")
+	files.write(newServerCA, newClientCA)
+	newClient := client(newServerCA, newClientCA.clientCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "new"}}))
+	require.Eventually(t, func() bool {
+		_, body, err := get(newClient, server.URL)
+		return err == nil && body == "cert:new"
+	}, 5*time.Second, 10*time.Millisecond)
+	_, _, err = get(oldClient, server.URL)
+	assert.Error(t, err)
+
+	// A broken file keeps the certificates in use
+	require.NoError(t, os.WriteFile(files.clientCA, []byte("not a certificate"), 0o600))
+	assert.ErrorIs(t, reloader.Reload(), tlsconfig.ErrNoCertificates)
+	_, body, err = get(newClient, server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "cert:new", body)
+}
+
+func TestCipherSuites(t *testing.T) {
+	suites, err := tlsconfig.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	_, err = tlsconfig.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(t, err)
+
+	serverCA, clientCA := newTestCA(t, "server CA"), newTestCA(t, "client CA")
+	files := newTestFiles(t)
+	files.write(serverCA, clientCA)
+	cfg := files.config(tlsconfig.ClientAuthNone)
+	cfg.CipherSuites = suites[1:]
+	reloader, err := tlsconfig.NewReloader(cfg)
+	require.NoError(t, err)
+	server := startServer(t, reloader)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.certificate)
+	dial := func(suites ...uint16) (*tls.Conn, error) {
+		return tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: suites,
+		})
+	}
+	conn, err := dial(suites...)
+	require.NoError(t, err)
+	assert.Equal(t, suites[1], conn.ConnectionState().CipherSuite)
+	conn.Close()
+
+	_, err = dial(suites[0])
+	assert.Error(t, err)
+
+	// TLS 1.2 is the minimum
+	_, err = tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err)
+}
+
+func TestNewReloaderRequiresClientCA(t *testing.T) {
+	serverCA, clientCA := newTestCA(t, "server CA"), newTestCA(t, "client CA")
+	files := newTestFiles(t)
+	files.write(serverCA, clientCA)
+	cfg := files.config(tlsconfig.ClientAuthRequire)
+	cfg.ClientCAFile = ""
+	_, err := tlsconfig.NewReloader(cfg)
+	assert.Error(t, err)
+
+	cfg.ClientAuth = "sometimes"
+	_, err = tlsconfig.NewReloader(cfg)
+	assert.Error(t, err)
+}